@@ -1,39 +1,107 @@
 package adminstream
 
 import (
+	"encoding/json"
+	"log"
 	"sync"
+	"time"
 
+	"chillow/broker"
 	"chillow/model"
 )
 
 type Event struct {
-	Type   string        `json:"type"`
-	Report *model.Report `json:"report,omitempty"`
-	User   *model.User   `json:"user,omitempty"`
+	Type      string          `json:"type"`
+	Report    *model.Report   `json:"report,omitempty"`
+	User      *model.User     `json:"user,omitempty"`
+	Detection *Detection      `json:"detection,omitempty"`
+	Sanction  *model.Sanction `json:"sanction,omitempty"`
+}
+
+// Detection はウイルス検査で拒否した添付（attachment:infected）
+type Detection struct {
+	UserID     uint      `json:"user_id"`
+	Kind       string    `json:"kind"`
+	Filename   string    `json:"filename"`
+	Size       int64     `json:"size"`
+	SHA256     string    `json:"sha256"`
+	Signature  string    `json:"signature"`
+	Scanner    string    `json:"scanner"`
+	DetectedAt time.Time `json:"detected_at"`
 }
 
 type hub struct {
-	mu   sync.RWMutex
-	subs map[chan Event]struct{}
+	mu     sync.RWMutex
+	subs   map[chan Event]struct{}
+	closed bool
 }
 
 var defaultHub = &hub{subs: make(map[chan Event]struct{})}
 
+// Init 以降の Broadcast はブローカー経由で全ノードの購読者に届く
+var bus broker.Broker
+
+func Init(b broker.Broker) error {
+	if err := b.Subscribe(broker.ChannelAdmin, func(payload []byte) {
+		var evt Event
+		if err := json.Unmarshal(payload, &evt); err != nil {
+			log.Printf("⚠️ invalid admin event: %v", err)
+			return
+		}
+		deliver(evt)
+	}); err != nil {
+		return err
+	}
+	bus = b
+	return nil
+}
+
 func Subscribe() (chan Event, func()) {
 	ch := make(chan Event, 10)
 	defaultHub.mu.Lock()
+	defer defaultHub.mu.Unlock()
+	if defaultHub.closed {
+		close(ch)
+		return ch, func() {}
+	}
 	defaultHub.subs[ch] = struct{}{}
-	defaultHub.mu.Unlock()
 	cancel := func() {
 		defaultHub.mu.Lock()
+		defer defaultHub.mu.Unlock()
+		// Shutdown で閉じ済みの場合は二重 close しない
+		if _, ok := defaultHub.subs[ch]; ok {
+			delete(defaultHub.subs, ch)
+			close(ch)
+		}
+	}
+	return ch, cancel
+}
+
+// Shutdown は全購読チャネルを閉じて SSE ストリームを終了させる
+func Shutdown() {
+	defaultHub.mu.Lock()
+	defer defaultHub.mu.Unlock()
+	defaultHub.closed = true
+	for ch := range defaultHub.subs {
 		delete(defaultHub.subs, ch)
-		defaultHub.mu.Unlock()
 		close(ch)
 	}
-	return ch, cancel
 }
 
 func Broadcast(evt Event) {
+	if bus != nil {
+		payload, err := json.Marshal(evt)
+		if err == nil {
+			if err = bus.Publish(broker.ChannelAdmin, payload); err == nil {
+				return
+			}
+		}
+		log.Printf("⚠️ broker publish failed, delivering locally: %v", err)
+	}
+	deliver(evt)
+}
+
+func deliver(evt Event) {
 	defaultHub.mu.RLock()
 	defer defaultHub.mu.RUnlock()
 	for ch := range defaultHub.subs {
@@ -0,0 +1,63 @@
+package broker
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"chillow/config"
+)
+
+// 配信チャネル
+const (
+	ChannelRoom     = "room"     // ルームへのブロードキャスト
+	ChannelUser     = "user"     // ユーザー宛てイベント・強制切断
+	ChannelPresence = "presence" // ノードごとの在室状況
+	ChannelAdmin    = "admin"    // 管理画面向け SSE イベント
+)
+
+// Broker はノード間のイベント配信を抽象化する。
+// Publish したメッセージは送信元を含むすべてのノードの購読者に届く。
+type Broker interface {
+	Publish(channel string, payload []byte) error
+	Subscribe(channel string, handler func(payload []byte)) error
+	Close() error
+}
+
+var errClosed = errors.New("broker closed")
+
+var (
+	defaultBroker Broker
+	nodeID        = newNodeID()
+)
+
+func Init(cfg *config.Config) error {
+	switch strings.ToLower(cfg.Broker) {
+	case "", "memory":
+		defaultBroker = newMemoryBroker()
+	case "redis":
+		b, err := newRedisBroker(cfg)
+		if err != nil {
+			return err
+		}
+		defaultBroker = b
+	default:
+		return fmt.Errorf("unsupported broker: %s", cfg.Broker)
+	}
+	return nil
+}
+
+func Default() Broker { return defaultBroker }
+
+// NodeID はこのプロセスを識別する ID（起動ごとに変わる）
+func NodeID() string { return nodeID }
+
+func newNodeID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "node"
+	}
+	return hex.EncodeToString(b)
+}
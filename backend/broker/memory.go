@@ -0,0 +1,81 @@
+package broker
+
+import (
+	"sync"
+)
+
+// ---- In-memory implementation (単一ノード) ----
+
+type memoryBroker struct {
+	mu     sync.RWMutex
+	subs   map[string][]*memorySub
+	closed bool
+}
+
+type memorySub struct {
+	ch   chan []byte
+	done chan struct{} // Close で閉じる
+}
+
+func newMemoryBroker() *memoryBroker {
+	return &memoryBroker{subs: make(map[string][]*memorySub)}
+}
+
+// 購読者ごとに専用 goroutine で順番に処理する（Publish 元をブロックしにくくするため）
+func (b *memoryBroker) Subscribe(channel string, handler func(payload []byte)) error {
+	sub := &memorySub{ch: make(chan []byte, 1024), done: make(chan struct{})}
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		return errClosed
+	}
+	b.subs[channel] = append(b.subs[channel], sub)
+	b.mu.Unlock()
+	go func() {
+		for {
+			select {
+			case payload := <-sub.ch:
+				handler(payload)
+			case <-sub.done:
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+// Publish はイベントを捨てない（切断の指示なども流れる）。購読者のバッファが詰まっていれば空くまで待つ。
+// 待つ間はロックを持たないので、他の Publish や Close は止まらない
+func (b *memoryBroker) Publish(channel string, payload []byte) error {
+	b.mu.RLock()
+	if b.closed {
+		b.mu.RUnlock()
+		return errClosed
+	}
+	subs := b.subs[channel]
+	b.mu.RUnlock()
+	for _, sub := range subs {
+		select {
+		case sub.ch <- payload:
+		case <-sub.done:
+			return errClosed
+		}
+	}
+	return nil
+}
+
+func (b *memoryBroker) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return nil
+	}
+	b.closed = true
+	for channel, subs := range b.subs {
+		for _, sub := range subs {
+			close(sub.done)
+		}
+		delete(b.subs, channel)
+	}
+	return nil
+}
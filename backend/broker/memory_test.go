@@ -0,0 +1,102 @@
+package broker
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMemoryBrokerDelivers(t *testing.T) {
+	b := newMemoryBroker()
+	defer b.Close()
+	got := make(chan string, 2)
+	b.Subscribe(ChannelRoom, func(p []byte) { got <- string(p) })
+	b.Subscribe(ChannelAdmin, func(p []byte) { t.Errorf("admin got %q", p) })
+	if err := b.Publish(ChannelRoom, []byte("hi")); err != nil {
+		t.Fatal(err)
+	}
+	if v := receive(t, got); v != "hi" {
+		t.Fatalf("got %q", v)
+	}
+}
+
+// 詰まった購読者がいても捨てずに待ち、空いたら順番どおりすべて届ける
+func TestMemoryBrokerSlowSubscriber(t *testing.T) {
+	b := newMemoryBroker()
+	defer b.Close()
+	block := make(chan struct{})
+	var got []string
+	done := make(chan struct{})
+	b.Subscribe(ChannelUser, func(p []byte) {
+		<-block
+		got = append(got, string(p))
+		if len(got) == 3000 {
+			close(done)
+		}
+	})
+
+	published := make(chan struct{})
+	go func() {
+		for i := range 3000 {
+			if err := b.Publish(ChannelUser, []byte{byte(i)}); err != nil {
+				t.Error(err)
+			}
+		}
+		close(published)
+	}()
+	select {
+	case <-published:
+		t.Fatal("Publish did not wait for a full subscriber")
+	case <-time.After(100 * time.Millisecond):
+	}
+	// 待っている間も他のチャネルの Publish は止まらない
+	if err := b.Publish(ChannelRoom, []byte("x")); err != nil {
+		t.Fatal(err)
+	}
+
+	close(block)
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("messages were lost")
+	}
+	<-published
+	for i, p := range got {
+		if p != string([]byte{byte(i)}) {
+			t.Fatalf("message %d out of order", i)
+		}
+	}
+}
+
+// 詰まった購読者を待っている Publish も Close で戻る
+func TestMemoryBrokerCloseUnblocksPublish(t *testing.T) {
+	b := newMemoryBroker()
+	b.Subscribe(ChannelRoom, func([]byte) { select {} })
+	result := make(chan error, 1)
+	go func() {
+		for {
+			if err := b.Publish(ChannelRoom, []byte("x")); err != nil {
+				result <- err
+				return
+			}
+		}
+	}()
+	time.Sleep(50 * time.Millisecond)
+	closed := make(chan struct{})
+	go func() {
+		b.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Close blocked behind a waiting Publish")
+	}
+	select {
+	case err := <-result:
+		if err != errClosed {
+			t.Fatalf("publish = %v, want errClosed", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Publish still blocked after Close")
+	}
+}
@@ -0,0 +1,314 @@
+package broker
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"chillow/config"
+)
+
+// ---- Minimal Redis Pub/Sub (RESP2) ----
+
+const (
+	redisDialTimeout  = 5 * time.Second
+	redisWriteTimeout = 5 * time.Second
+	redisMaxBackoff   = 10 * time.Second
+)
+
+type redisBroker struct {
+	addr     string
+	password string
+	db       int
+	prefix   string
+
+	pubMu sync.Mutex
+	pub   *respConn
+
+	subMu    sync.Mutex
+	sub      *respConn
+	handlers map[string][]func(payload []byte)
+
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+func newRedisBroker(cfg *config.Config) (Broker, error) {
+	if cfg.RedisAddr == "" {
+		return nil, fmt.Errorf("REDIS_ADDR is required")
+	}
+	b := &redisBroker{
+		addr:     cfg.RedisAddr,
+		password: cfg.RedisPassword,
+		db:       cfg.RedisDB,
+		prefix:   cfg.BrokerChannelPrefix,
+		handlers: make(map[string][]func(payload []byte)),
+		closed:   make(chan struct{}),
+	}
+	// 起動時に疎通確認しておく（以降は切断されても自動で再接続）
+	conn, err := b.dial()
+	if err != nil {
+		return nil, err
+	}
+	b.pub = conn
+	go b.subscribeLoop()
+	return b, nil
+}
+
+func (b *redisBroker) channelName(channel string) string {
+	return b.prefix + ":" + channel
+}
+
+func (b *redisBroker) Publish(channel string, payload []byte) error {
+	b.pubMu.Lock()
+	defer b.pubMu.Unlock()
+
+	var lastErr error
+	for attempt := 0; attempt < 2; attempt++ {
+		if b.pub == nil {
+			conn, err := b.dial()
+			if err != nil {
+				lastErr = err
+				continue
+			}
+			b.pub = conn
+		}
+		if _, err := b.pub.do("PUBLISH", b.channelName(channel), string(payload)); err != nil {
+			lastErr = err
+			b.pub.close()
+			b.pub = nil
+			continue
+		}
+		return nil
+	}
+	return lastErr
+}
+
+func (b *redisBroker) Subscribe(channel string, handler func(payload []byte)) error {
+	b.subMu.Lock()
+	defer b.subMu.Unlock()
+	name := b.channelName(channel)
+	_, exists := b.handlers[name]
+	b.handlers[name] = append(b.handlers[name], handler)
+	if !exists && b.sub != nil {
+		// 接続中なら即座に購読。失敗しても再接続時に全チャネルを購読し直す
+		if err := b.sub.write("SUBSCRIBE", name); err != nil {
+			log.Printf("⚠️ redis subscribe %s failed: %v", name, err)
+		}
+	}
+	return nil
+}
+
+func (b *redisBroker) Close() error {
+	b.closeOnce.Do(func() {
+		close(b.closed)
+		b.pubMu.Lock()
+		if b.pub != nil {
+			b.pub.close()
+			b.pub = nil
+		}
+		b.pubMu.Unlock()
+		b.subMu.Lock()
+		if b.sub != nil {
+			b.sub.close()
+		}
+		b.subMu.Unlock()
+	})
+	return nil
+}
+
+func (b *redisBroker) isClosed() bool {
+	select {
+	case <-b.closed:
+		return true
+	default:
+		return false
+	}
+}
+
+func (b *redisBroker) subscribeLoop() {
+	backoff := 200 * time.Millisecond
+	for !b.isClosed() {
+		err := b.runSubscription()
+		if b.isClosed() {
+			return
+		}
+		log.Printf("⚠️ redis subscription lost: %v (retry in %s)", err, backoff)
+		select {
+		case <-time.After(backoff):
+		case <-b.closed:
+			return
+		}
+		backoff *= 2
+		if backoff > redisMaxBackoff {
+			backoff = redisMaxBackoff
+		}
+	}
+}
+
+func (b *redisBroker) runSubscription() error {
+	conn, err := b.dial()
+	if err != nil {
+		return err
+	}
+	b.subMu.Lock()
+	channels := make([]string, 0, len(b.handlers))
+	for name := range b.handlers {
+		channels = append(channels, name)
+	}
+	if len(channels) > 0 {
+		if err := conn.write(append([]string{"SUBSCRIBE"}, channels...)...); err != nil {
+			b.subMu.Unlock()
+			conn.close()
+			return err
+		}
+	}
+	b.sub = conn
+	b.subMu.Unlock()
+
+	defer func() {
+		b.subMu.Lock()
+		b.sub = nil
+		b.subMu.Unlock()
+		conn.close()
+	}()
+
+	for {
+		reply, err := conn.readReply()
+		if err != nil {
+			return err
+		}
+		parts, ok := reply.([]interface{})
+		if !ok || len(parts) != 3 {
+			continue
+		}
+		kind, _ := parts[0].(string)
+		if kind != "message" {
+			continue // subscribe の確認応答など
+		}
+		name, _ := parts[1].(string)
+		payload, _ := parts[2].(string)
+		b.subMu.Lock()
+		handlers := append([]func([]byte){}, b.handlers[name]...)
+		b.subMu.Unlock()
+		for _, h := range handlers {
+			h([]byte(payload))
+		}
+	}
+}
+
+func (b *redisBroker) dial() (*respConn, error) {
+	nc, err := net.DialTimeout("tcp", b.addr, redisDialTimeout)
+	if err != nil {
+		return nil, err
+	}
+	conn := &respConn{conn: nc, r: bufio.NewReader(nc), w: bufio.NewWriter(nc)}
+	if b.password != "" {
+		if _, err := conn.do("AUTH", b.password); err != nil {
+			conn.close()
+			return nil, err
+		}
+	}
+	if b.db != 0 {
+		if _, err := conn.do("SELECT", strconv.Itoa(b.db)); err != nil {
+			conn.close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+// ---- RESP helpers ----
+
+type respConn struct {
+	conn net.Conn
+	r    *bufio.Reader
+	w    *bufio.Writer
+}
+
+func (c *respConn) close() { _ = c.conn.Close() }
+
+func (c *respConn) do(args ...string) (interface{}, error) {
+	if err := c.write(args...); err != nil {
+		return nil, err
+	}
+	_ = c.conn.SetReadDeadline(time.Now().Add(redisWriteTimeout))
+	defer c.conn.SetReadDeadline(time.Time{})
+	return c.readReply()
+}
+
+func (c *respConn) write(args ...string) error {
+	_ = c.conn.SetWriteDeadline(time.Now().Add(redisWriteTimeout))
+	fmt.Fprintf(c.w, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(c.w, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	return c.w.Flush()
+}
+
+func (c *respConn) readReply() (interface{}, error) {
+	line, err := c.readLine()
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 {
+		return nil, errors.New("redis: empty reply")
+	}
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, errors.New("redis: " + line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(c.r, buf); err != nil {
+			return nil, err
+		}
+		return string(buf[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		items := make([]interface{}, 0, n)
+		for i := 0; i < n; i++ {
+			item, err := c.readReply()
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, item)
+		}
+		return items, nil
+	default:
+		return nil, fmt.Errorf("redis: unexpected reply %q", line)
+	}
+}
+
+func (c *respConn) readLine() (string, error) {
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	if len(line) < 2 || line[len(line)-2] != '\r' {
+		return "", errors.New("redis: malformed line")
+	}
+	return line[:len(line)-2], nil
+}
@@ -0,0 +1,345 @@
+package broker
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"chillow/config"
+)
+
+// fakeRedis は PUBLISH / SUBSCRIBE / AUTH / SELECT だけを受け付ける RESP2 サーバー
+type fakeRedis struct {
+	t  *testing.T
+	ln net.Listener
+
+	mu       sync.Mutex
+	conns    map[net.Conn]bool
+	subs     map[string]map[net.Conn]bool
+	commands []string
+}
+
+func newFakeRedis(t *testing.T) *fakeRedis {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &fakeRedis{t: t, ln: ln, conns: map[net.Conn]bool{}, subs: map[string]map[net.Conn]bool{}}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			s.mu.Lock()
+			s.conns[conn] = true
+			s.mu.Unlock()
+			go s.serve(conn)
+		}
+	}()
+	t.Cleanup(func() {
+		ln.Close()
+		s.dropAll()
+	})
+	return s
+}
+
+func (s *fakeRedis) addr() string { return s.ln.Addr().String() }
+
+// dropAll は接続をすべて切る（再接続の確認用）
+func (s *fakeRedis) dropAll() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for conn := range s.conns {
+		conn.Close()
+	}
+}
+
+func (s *fakeRedis) subscribers(channel string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.subs[channel])
+}
+
+func (s *fakeRedis) serve(conn net.Conn) {
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, conn)
+		for _, conns := range s.subs {
+			delete(conns, conn)
+		}
+		s.mu.Unlock()
+		conn.Close()
+	}()
+	r := bufio.NewReader(conn)
+	for {
+		args, err := readCommand(r)
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		s.commands = append(s.commands, args[0])
+		s.mu.Unlock()
+		switch args[0] {
+		case "AUTH", "SELECT":
+			fmt.Fprint(conn, "+OK\r\n")
+		case "SUBSCRIBE":
+			for i, channel := range args[1:] {
+				s.mu.Lock()
+				if s.subs[channel] == nil {
+					s.subs[channel] = map[net.Conn]bool{}
+				}
+				s.subs[channel][conn] = true
+				s.mu.Unlock()
+				fmt.Fprintf(conn, "*3\r\n$9\r\nsubscribe\r\n%s:%d\r\n", bulk(channel), i+1)
+			}
+		case "PUBLISH":
+			s.mu.Lock()
+			targets := make([]net.Conn, 0, len(s.subs[args[1]]))
+			for c := range s.subs[args[1]] {
+				targets = append(targets, c)
+			}
+			s.mu.Unlock()
+			for _, c := range targets {
+				fmt.Fprintf(c, "*3\r\n$7\r\nmessage\r\n%s%s", bulk(args[1]), bulk(args[2]))
+			}
+			fmt.Fprintf(conn, ":%d\r\n", len(targets))
+		default:
+			fmt.Fprintf(conn, "-ERR unknown command '%s'\r\n", args[0])
+		}
+	}
+}
+
+func bulk(s string) string { return "$" + strconv.Itoa(len(s)) + "\r\n" + s + "\r\n" }
+
+func readCommand(r *bufio.Reader) ([]string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 4 || line[0] != '*' {
+		return nil, fmt.Errorf("unexpected %q", line)
+	}
+	n, err := strconv.Atoi(line[1 : len(line)-2])
+	if err != nil {
+		return nil, err
+	}
+	args := make([]string, n)
+	for i := range args {
+		header, err := r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		size, err := strconv.Atoi(header[1 : len(header)-2])
+		if err != nil {
+			return nil, err
+		}
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		args[i] = string(buf[:size])
+	}
+	return args, nil
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func receive(t *testing.T, ch <-chan string) string {
+	t.Helper()
+	select {
+	case v := <-ch:
+		return v
+	case <-time.After(5 * time.Second):
+		t.Fatal("message not delivered")
+		return ""
+	}
+}
+
+func TestRedisBrokerPublishSubscribe(t *testing.T) {
+	srv := newFakeRedis(t)
+	b, err := newRedisBroker(&config.Config{RedisAddr: srv.addr(), RedisPassword: "pw", RedisDB: 2, BrokerChannelPrefix: "chillow"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	got := make(chan string, 10)
+	if err := b.Subscribe(ChannelRoom, func(p []byte) { got <- "room:" + string(p) }); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Subscribe(ChannelAdmin, func(p []byte) { got <- "admin:" + string(p) }); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "subscriptions", func() bool {
+		return srv.subscribers("chillow:room") == 1 && srv.subscribers("chillow:admin") == 1
+	})
+
+	// 改行を含むペイロードもそのまま届く
+	if err := b.Publish(ChannelRoom, []byte("hello\r\nworld")); err != nil {
+		t.Fatal(err)
+	}
+	if v := receive(t, got); v != "room:hello\r\nworld" {
+		t.Fatalf("got %q", v)
+	}
+	if err := b.Publish(ChannelAdmin, []byte("{}")); err != nil {
+		t.Fatal(err)
+	}
+	if v := receive(t, got); v != "admin:{}" {
+		t.Fatalf("got %q", v)
+	}
+
+	srv.mu.Lock()
+	commands := append([]string(nil), srv.commands...)
+	srv.mu.Unlock()
+	if commands[0] != "AUTH" || commands[1] != "SELECT" {
+		t.Fatalf("connection setup = %v, want AUTH then SELECT", commands)
+	}
+}
+
+func TestRedisBrokerReconnect(t *testing.T) {
+	srv := newFakeRedis(t)
+	b, err := newRedisBroker(&config.Config{RedisAddr: srv.addr(), BrokerChannelPrefix: "chillow"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	got := make(chan string, 10)
+	b.Subscribe(ChannelUser, func(p []byte) { got <- string(p) })
+	waitFor(t, "subscription", func() bool { return srv.subscribers("chillow:user") == 1 })
+
+	// 接続が切れたら購読し直し、Publish も新しい接続で送り直す
+	srv.dropAll()
+	waitFor(t, "unsubscribe", func() bool { return srv.subscribers("chillow:user") == 0 })
+	waitFor(t, "resubscription", func() bool { return srv.subscribers("chillow:user") == 1 })
+	if err := b.Publish(ChannelUser, []byte("after reconnect")); err != nil {
+		t.Fatal(err)
+	}
+	if v := receive(t, got); v != "after reconnect" {
+		t.Fatalf("got %q", v)
+	}
+
+	// 購読していないチャネルはサーバーに届いても配らない
+	b.Publish(ChannelPresence, []byte("ignored"))
+	select {
+	case v := <-got:
+		t.Fatalf("unexpected delivery %q", v)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestRedisBrokerServerError(t *testing.T) {
+	srv := newFakeRedis(t)
+	b, err := newRedisBroker(&config.Config{RedisAddr: srv.addr(), BrokerChannelPrefix: "chillow"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+	rb := b.(*redisBroker)
+	rb.pubMu.Lock()
+	_, err = rb.pub.do("PING")
+	rb.pubMu.Unlock()
+	if err == nil || err.Error() != "redis: ERR unknown command 'PING'" {
+		t.Fatalf("err = %v", err)
+	}
+}
+
+// ---- 実際の Redis に対する結合テスト（REDIS_TEST_ADDR を設定したときだけ実行する） ----
+//
+//	docker compose --profile redis up -d redis
+//	REDIS_TEST_ADDR=localhost:6379 go test ./broker
+
+func newTestRedis(t *testing.T) (*config.Config, *respConn) {
+	t.Helper()
+	addr := os.Getenv("REDIS_TEST_ADDR")
+	if addr == "" {
+		t.Skip("REDIS_TEST_ADDR is not set")
+	}
+	// 同じ Redis を使う他のテストや開発環境と混ざらないよう、テストごとに接頭辞を変える
+	cfg := &config.Config{
+		RedisAddr:           addr,
+		RedisPassword:       os.Getenv("REDIS_TEST_PASSWORD"),
+		BrokerChannelPrefix: fmt.Sprintf("chillow-test-%d", time.Now().UnixNano()),
+	}
+	control, err := (&redisBroker{addr: cfg.RedisAddr, password: cfg.RedisPassword}).dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(control.close)
+	return cfg, control
+}
+
+// numSub は channel を購読している接続の数
+func numSub(t *testing.T, control *respConn, channel string) int64 {
+	t.Helper()
+	reply, err := control.do("PUBSUB", "NUMSUB", channel)
+	if err != nil {
+		t.Fatal(err)
+	}
+	items, ok := reply.([]interface{})
+	if !ok || len(items) != 2 {
+		t.Fatalf("PUBSUB NUMSUB replied %v", reply)
+	}
+	n, _ := items[1].(int64)
+	return n
+}
+
+func TestRedisBrokerLocalRedis(t *testing.T) {
+	cfg, control := newTestRedis(t)
+	nodes := make([]Broker, 2)
+	got := make([]chan string, 2)
+	for i := range nodes {
+		b, err := newRedisBroker(cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer b.Close()
+		ch := make(chan string, 10)
+		if err := b.Subscribe(ChannelRoom, func(p []byte) { ch <- string(p) }); err != nil {
+			t.Fatal(err)
+		}
+		nodes[i], got[i] = b, ch
+	}
+	room := cfg.BrokerChannelPrefix + ":room"
+	waitFor(t, "subscriptions", func() bool { return numSub(t, control, room) == 2 })
+
+	// 1 つのノードから送ったものが両方のノードに届く
+	if err := nodes[0].Publish(ChannelRoom, []byte("hello\r\nworld")); err != nil {
+		t.Fatal(err)
+	}
+	for i := range got {
+		if v := receive(t, got[i]); v != "hello\r\nworld" {
+			t.Fatalf("node %d got %q", i, v)
+		}
+	}
+
+	// サーバー側で購読の接続を切られても購読し直す
+	if _, err := control.do("CLIENT", "KILL", "TYPE", "pubsub"); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "resubscription", func() bool { return numSub(t, control, room) == 2 })
+	if err := nodes[1].Publish(ChannelRoom, []byte("after reconnect")); err != nil {
+		t.Fatal(err)
+	}
+	for i := range got {
+		if v := receive(t, got[i]); v != "after reconnect" {
+			t.Fatalf("node %d got %q", i, v)
+		}
+	}
+}
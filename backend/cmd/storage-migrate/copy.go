@@ -0,0 +1,137 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"sync"
+	"sync/atomic"
+
+	"chillow/storage"
+)
+
+type copyOptions struct {
+	Prefix   string
+	Workers  int
+	DryRun   bool
+	SizeOnly bool // 移行先に同じサイズのものがあれば中身を比べずに省く
+}
+
+type copyStats struct {
+	Scanned     int64
+	Copied      int64
+	CopiedBytes int64
+	Skipped     int64 // 移行先に同じもの（SizeOnly なら同じサイズのもの）があった
+	Failed      int64
+}
+
+// copyObjects は src の prefix 配下を dst へコピーし、SHA-256 で中身を確かめる。
+// 列挙自体の失敗はエラーを返し、個々のオブジェクトの失敗は Failed に数えて続ける。
+func copyObjects(src, dst storage.Manager, opts copyOptions) (*copyStats, error) {
+	stats := &copyStats{}
+	workers := opts.Workers
+	if workers < 1 {
+		workers = 1
+	}
+	entries := make(chan storage.ObjectEntry)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for obj := range entries {
+				copied, err := copyObject(src, dst, obj, opts)
+				switch {
+				case err != nil:
+					atomic.AddInt64(&stats.Failed, 1)
+					log.Printf("⚠️ failed to copy %s: %v", obj.Key, err)
+				case copied:
+					atomic.AddInt64(&stats.Copied, 1)
+					atomic.AddInt64(&stats.CopiedBytes, obj.Size)
+				default:
+					atomic.AddInt64(&stats.Skipped, 1)
+				}
+			}
+		}()
+	}
+
+	err := src.List(opts.Prefix, func(obj storage.ObjectEntry) error {
+		n := atomic.AddInt64(&stats.Scanned, 1)
+		if n%1000 == 0 {
+			log.Printf("ℹ️ scanned %d objects (copied=%d skipped=%d failed=%d)",
+				n, atomic.LoadInt64(&stats.Copied), atomic.LoadInt64(&stats.Skipped), atomic.LoadInt64(&stats.Failed))
+		}
+		entries <- obj
+		return nil
+	})
+	close(entries)
+	wg.Wait()
+	return stats, err
+}
+
+// copyObject は 1 つのオブジェクトをコピーする（移行先に同じものがあれば false）。
+// 同じサイズでも途中で止まった書き込みなどで中身が違うことがあるので、既定では SHA-256 まで比べる
+func copyObject(src, dst storage.Manager, obj storage.ObjectEntry, opts copyOptions) (bool, error) {
+	existing, err := dst.Head(obj.Key)
+	if err != nil && !errors.Is(err, storage.ErrNotFound) {
+		return false, err
+	}
+	if existing != nil && existing.Size == obj.Size {
+		if opts.SizeOnly {
+			return false, nil
+		}
+		srcSum, err := objectChecksum(src, obj.Key)
+		if err != nil {
+			return false, err
+		}
+		dstSum, err := objectChecksum(dst, obj.Key)
+		if err != nil {
+			return false, err
+		}
+		if srcSum == dstSum {
+			return false, nil
+		}
+		log.Printf("ℹ️ %s differs at destination; copying again", obj.Key)
+	}
+	if opts.DryRun {
+		return true, nil
+	}
+
+	info, err := src.Head(obj.Key)
+	if err != nil {
+		return false, err
+	}
+	body, err := src.Get(obj.Key)
+	if err != nil {
+		return false, err
+	}
+	defer body.Close()
+	h := sha256.New()
+	if err := dst.PutStream(obj.Key, io.TeeReader(body, h), info.Size, info.ContentType); err != nil {
+		return false, err
+	}
+	srcSum := hex.EncodeToString(h.Sum(nil))
+
+	// 書き込んだものを読み直して確かめる
+	dstSum, err := objectChecksum(dst, obj.Key)
+	if err != nil {
+		return false, err
+	}
+	if dstSum != srcSum {
+		return false, fmt.Errorf("checksum mismatch: source %s, destination %s", srcSum, dstSum)
+	}
+	return true, nil
+}
+
+func objectChecksum(m storage.Manager, key string) (string, error) {
+	body, err := m.Get(key)
+	if err != nil {
+		return "", err
+	}
+	defer body.Close()
+	hash, _, err := storage.HashReader(body)
+	return hash, err
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"testing"
+
+	"chillow/config"
+	"chillow/storage"
+)
+
+// countingManager は PutStream の回数を数える（コピーを省いたことを確かめる）
+type countingManager struct {
+	storage.Manager
+	puts int
+}
+
+func (m *countingManager) PutStream(key string, body io.Reader, size int64, contentType string) error {
+	m.puts++
+	return m.Manager.PutStream(key, body, size, contentType)
+}
+
+func newLocal(t *testing.T, backendURL string) storage.Manager {
+	t.Helper()
+	m, err := storage.New(&config.Config{
+		AttachmentStorage:    "local",
+		UploadDir:            t.TempDir(),
+		BackendURL:           backendURL,
+		StorageSigningSecret: "test",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func put(t *testing.T, m storage.Manager, key, body string) {
+	t.Helper()
+	if err := m.Put(key, []byte(body), "text/plain"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// content は保存されている中身（なければ空）
+func content(t *testing.T, m storage.Manager, key string) string {
+	t.Helper()
+	r, err := m.Get(key)
+	if errors.Is(err, storage.ErrNotFound) {
+		return ""
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	b, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func TestCopyObject(t *testing.T) {
+	const key = "chat/1/a.txt"
+	tests := []struct {
+		name     string
+		existing string // 移行先にあらかじめ置く中身（空なら置かない）
+		opts     copyOptions
+		copied   bool
+		puts     int
+		want     string // コピー後の移行先の中身
+	}{
+		{name: "missing", copied: true, puts: 1, want: "hello"},
+		{name: "identical", existing: "hello", copied: false, puts: 0, want: "hello"},
+		{name: "same size, different content", existing: "HELLO", copied: true, puts: 1, want: "hello"},
+		{name: "different size", existing: "hi", copied: true, puts: 1, want: "hello"},
+		{name: "size only", existing: "HELLO", opts: copyOptions{SizeOnly: true}, copied: false, puts: 0, want: "HELLO"},
+		{name: "dry run", opts: copyOptions{DryRun: true}, copied: true, puts: 0, want: ""},
+		{name: "dry run mismatch", existing: "HELLO", opts: copyOptions{DryRun: true}, copied: true, puts: 0, want: "HELLO"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src := newLocal(t, "http://api")
+			dst := &countingManager{Manager: newLocal(t, "http://api")}
+			put(t, src, key, "hello")
+			if tt.existing != "" {
+				put(t, dst.Manager, key, tt.existing)
+			}
+
+			copied, err := copyObject(src, dst, storage.ObjectEntry{Key: key, Size: 5}, tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if copied != tt.copied {
+				t.Errorf("copied = %v, want %v", copied, tt.copied)
+			}
+			if dst.puts != tt.puts {
+				t.Errorf("wrote %d times, want %d", dst.puts, tt.puts)
+			}
+			if got := content(t, dst, key); got != tt.want {
+				t.Errorf("destination = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCopyObjects(t *testing.T) {
+	src := newLocal(t, "http://api")
+	dst := newLocal(t, "http://api")
+	put(t, src, "chat/1/a.txt", "aaa")
+	put(t, src, "chat/2/b.txt", "bbbb")
+	put(t, src, "chat/2/c.txt", "cc")
+	put(t, src, "avatars/d.png", "dd")
+	put(t, dst, "chat/2/c.txt", "cc")
+
+	stats, err := copyObjects(src, dst, copyOptions{Prefix: "chat/", Workers: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := copyStats{Scanned: 3, Copied: 2, CopiedBytes: 7, Skipped: 1}
+	if *stats != want {
+		t.Errorf("stats = %+v, want %+v", *stats, want)
+	}
+	for key, body := range map[string]string{"chat/1/a.txt": "aaa", "chat/2/b.txt": "bbbb", "avatars/d.png": ""} {
+		if got := content(t, dst, key); got != body {
+			t.Errorf("%s = %q, want %q", key, got, body)
+		}
+	}
+
+	// 2 回目はすべて省く（中断後の再実行）
+	stats, err = copyObjects(src, dst, copyOptions{Prefix: "chat/"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (copyStats{Scanned: 3, Skipped: 3}); *stats != want {
+		t.Errorf("second run stats = %+v, want %+v", *stats, want)
+	}
+}
@@ -0,0 +1,117 @@
+// storage-migrate は添付オブジェクトを別のストレージバックエンドへ移し、
+// メッセージ・通報に保存されている URL とキーを移行先のものに書き換える。
+//
+//	go run ./cmd/storage-migrate -from local -to s3 -dry-run
+//	go run ./cmd/storage-migrate -from local:/data/uploads -to s3:chillow-prod
+//
+// バックエンドは local / s3 で指定し、「:」の後ろで保存先（local はディレクトリ、s3 はバケット）を上書きできる。
+// それ以外の設定（S3 の認証情報・エンドポイント、BACKEND_URL など）と DB 接続は通常の環境変数から読む。
+// 移行先に同じサイズのオブジェクトがあればコピーを省き、書き換え済みの行は変更しないので、中断しても同じコマンドで再開できる。
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"chillow/config"
+	"chillow/db"
+	"chillow/storage"
+)
+
+func main() {
+	from := flag.String("from", "", "移行元のバックエンド（local[:dir] / s3[:bucket]）")
+	to := flag.String("to", "", "移行先のバックエンド（local[:dir] / s3[:bucket]）")
+	prefix := flag.String("prefix", "chat/", "コピーするオブジェクトキーの接頭辞")
+	dryRun := flag.Bool("dry-run", false, "コピー・書き換えをせず、対象の件数だけ表示する")
+	workers := flag.Int("workers", 4, "同時にコピーするオブジェクト数")
+	batchSize := flag.Int("batch", 500, "URL を書き換える行数（1 トランザクションあたり）")
+	sizeOnly := flag.Bool("size-only", false, "移行先に同じサイズのオブジェクトがあれば SHA-256 を比較せずにコピーを省く")
+	skipCopy := flag.Bool("skip-copy", false, "コピーを省いて URL の書き換えだけ行う")
+	flag.Parse()
+
+	config.LoadConfig()
+	src, srcName, err := openBackend(*from)
+	if err != nil {
+		log.Fatalf("❌ 移行元を開けません: %v", err)
+	}
+	dst, dstName, err := openBackend(*to)
+	if err != nil {
+		log.Fatalf("❌ 移行先を開けません: %v", err)
+	}
+	if srcName == dstName {
+		log.Fatalf("❌ 移行元と移行先が同じです: %s", srcName)
+	}
+	log.Printf("ℹ️ migrating %s → %s", srcName, dstName)
+	db.InitDB()
+
+	if !*skipCopy {
+		stats, err := copyObjects(src, dst, copyOptions{
+			Prefix:   *prefix,
+			Workers:  *workers,
+			DryRun:   *dryRun,
+			SizeOnly: *sizeOnly,
+		})
+		if err != nil {
+			log.Fatalf("❌ オブジェクトの列挙に失敗: %v", err)
+		}
+		log.Printf("ℹ️ objects: scanned=%d copied=%d (%d bytes) skipped=%d failed=%d dry_run=%v",
+			stats.Scanned, stats.Copied, stats.CopiedBytes, stats.Skipped, stats.Failed, *dryRun)
+		// コピーできていないオブジェクトを指す URL にしないよう、失敗があれば書き換えない
+		if stats.Failed > 0 {
+			log.Printf("❌ %d objects failed to copy; URLs were not rewritten. Re-run to retry.", stats.Failed)
+			os.Exit(1)
+		}
+	}
+
+	unresolved := 0
+	for _, table := range rewriteTables {
+		stats, err := rewriteLocations(table, src, dst, *batchSize, *dryRun)
+		if err != nil {
+			log.Fatalf("❌ %s の書き換えに失敗: %v", table, err)
+		}
+		log.Printf("ℹ️ %s: scanned=%d rewritten=%d unresolved=%d dry_run=%v",
+			table, stats.Scanned, stats.Rewritten, stats.Unresolved, *dryRun)
+		unresolved += stats.Unresolved
+	}
+	if *dryRun {
+		log.Println("ℹ️ dry run: nothing was changed")
+		return
+	}
+	// 移行元を指したままの行が残っているので、ATTACHMENT_STORAGE を切り替えると表示できなくなる
+	if unresolved > 0 {
+		log.Printf("❌ %d rows still point at unknown locations and were not rewritten. Fix them before switching ATTACHMENT_STORAGE.", unresolved)
+		os.Exit(1)
+	}
+}
+
+// openBackend は "local" "local:/path" "s3" "s3:bucket" 形式の指定からバックエンドを作り、保存先の表示名とともに返す
+func openBackend(spec string) (storage.Manager, string, error) {
+	kind, target, _ := strings.Cut(spec, ":")
+	cfg := *config.Cfg
+	cfg.AttachmentStorage = kind
+	var name string
+	switch kind {
+	case "local":
+		if target != "" {
+			cfg.UploadDir = target
+		}
+		dir, err := filepath.Abs(cfg.UploadDir)
+		if err != nil {
+			return nil, "", err
+		}
+		name = "local:" + dir
+	case "s3":
+		if target != "" {
+			cfg.S3Bucket = target
+		}
+		name = "s3:" + cfg.S3Endpoint + "/" + cfg.S3Bucket
+	default:
+		return nil, "", fmt.Errorf("unknown backend %q (use local[:dir] or s3[:bucket])", spec)
+	}
+	mgr, err := storage.New(&cfg)
+	return mgr, name, err
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"strings"
+
+	"chillow/db"
+	"chillow/storage"
+
+	"gorm.io/gorm"
+)
+
+// 添付の URL（attachment_url）とキー（attachment_obj）を持つテーブル
+var rewriteTables = []string{"messages", "reports"}
+
+type rewriteStats struct {
+	Scanned    int
+	Rewritten  int
+	Unresolved int // キーが分からない（移行元の URL でもない）ため書き換えなかった
+}
+
+type locationRow struct {
+	ID            uint
+	AttachmentURL *string
+	AttachmentObj *string
+}
+
+// rewriteLocations は id 順に batchSize 行ずつ読み、attachment_url を移行先の URL に書き換える。
+// キー（attachment_obj）がない行は移行元の URL から求めて補う。移行先の URL になっている行は変更しない。
+func rewriteLocations(table string, src, dst storage.Manager, batchSize int, dryRun bool) (*rewriteStats, error) {
+	stats := &rewriteStats{}
+	srcBase := publicBase(src)
+	var lastID uint
+	for {
+		var rows []locationRow
+		if err := db.DB.Table(table).
+			Select("id", "attachment_url", "attachment_obj").
+			Where("id > ? AND (attachment_url IS NOT NULL OR attachment_obj IS NOT NULL)", lastID).
+			Order("id ASC").
+			Limit(batchSize).
+			Scan(&rows).Error; err != nil {
+			return stats, err
+		}
+		if len(rows) == 0 {
+			return stats, nil
+		}
+		lastID = rows[len(rows)-1].ID
+
+		type update struct {
+			id       uint
+			url, key string
+		}
+		var updates []update
+		for _, row := range rows {
+			stats.Scanned++
+			url, key, ok := rewriteTarget(row, srcBase, dst)
+			if !ok {
+				stats.Unresolved++
+				continue
+			}
+			if row.AttachmentURL != nil && *row.AttachmentURL == url && row.AttachmentObj != nil && *row.AttachmentObj == key {
+				continue
+			}
+			updates = append(updates, update{id: row.ID, url: url, key: key})
+		}
+		stats.Rewritten += len(updates)
+
+		if !dryRun && len(updates) > 0 {
+			if err := db.DB.Transaction(func(tx *gorm.DB) error {
+				for _, u := range updates {
+					if err := tx.Table(table).Where("id = ?", u.id).Updates(map[string]interface{}{
+						"attachment_url": u.url,
+						"attachment_obj": u.key,
+					}).Error; err != nil {
+						return err
+					}
+				}
+				return nil
+			}); err != nil {
+				return stats, err
+			}
+		}
+
+		if len(rows) < batchSize {
+			return stats, nil
+		}
+	}
+}
+
+// rewriteTarget は行の書き換え先の URL とキーを求める。
+// キーがなく、URL も移行元（srcBase）のものでなければ ok は false
+func rewriteTarget(row locationRow, srcBase string, dst storage.Manager) (url, key string, ok bool) {
+	if row.AttachmentObj != nil {
+		key = *row.AttachmentObj
+	}
+	if key == "" && row.AttachmentURL != nil {
+		key, _ = strings.CutPrefix(stripQuery(*row.AttachmentURL), srcBase)
+		if key == stripQuery(*row.AttachmentURL) {
+			key = ""
+		}
+	}
+	if key == "" {
+		return "", "", false
+	}
+	return dst.PublicURL(key), key, true
+}
+
+// publicBase はキーの前に付く URL（local は <BACKEND_URL>/uploads/、S3 はバケットの URL）
+func publicBase(m storage.Manager) string {
+	return strings.TrimSuffix(m.PublicURL("x"), "x")
+}
+
+func stripQuery(url string) string {
+	url, _, _ = strings.Cut(url, "?")
+	return url
+}
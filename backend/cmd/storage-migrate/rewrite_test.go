@@ -0,0 +1,113 @@
+package main
+
+import (
+	"testing"
+
+	"chillow/config"
+	"chillow/storage"
+)
+
+func newS3(t *testing.T, endpoint string, pathStyle bool) storage.Manager {
+	t.Helper()
+	m, err := storage.New(&config.Config{
+		AttachmentStorage: "s3",
+		S3Bucket:          "chillow",
+		S3Region:          "us-east-1",
+		S3Endpoint:        endpoint,
+		S3AccessKey:       "key",
+		S3SecretKey:       "secret",
+		S3UsePathStyle:    pathStyle,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func TestPublicBase(t *testing.T) {
+	tests := []struct {
+		name string
+		m    storage.Manager
+		want string
+	}{
+		{"local", newLocal(t, "http://api.example.com"), "http://api.example.com/uploads/"},
+		{"local with trailing slash", newLocal(t, "http://api.example.com/"), "http://api.example.com/uploads/"},
+		{"s3 path style", newS3(t, "http://minio:9000", true), "http://minio:9000/chillow/"},
+		{"s3 virtual host", newS3(t, "https://s3.example.com", false), "https://chillow.s3.example.com/"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := publicBase(tt.m); got != tt.want {
+				t.Errorf("publicBase = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStripQuery(t *testing.T) {
+	tests := map[string]string{
+		"http://api/uploads/chat/a.png":                    "http://api/uploads/chat/a.png",
+		"http://api/uploads/chat/a.png?exp=1&sig=x":        "http://api/uploads/chat/a.png",
+		"http://api/uploads/chat/a.png?":                   "http://api/uploads/chat/a.png",
+		"https://minio/chillow/chat/a.png?X-Amz-Expires=1": "https://minio/chillow/chat/a.png",
+	}
+	for in, want := range tests {
+		if got := stripQuery(in); got != want {
+			t.Errorf("stripQuery(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestRewriteTarget(t *testing.T) {
+	src := newLocal(t, "http://api")
+	dst := newS3(t, "http://minio:9000", true)
+	srcBase := publicBase(src)
+	str := func(s string) *string { return &s }
+
+	tests := []struct {
+		name string
+		row  locationRow
+		url  string
+		key  string
+		ok   bool
+	}{
+		{
+			name: "key present",
+			row:  locationRow{AttachmentURL: str("http://api/uploads/chat/1/a.png"), AttachmentObj: str("chat/1/a.png")},
+			url:  "http://minio:9000/chillow/chat/1/a.png", key: "chat/1/a.png", ok: true,
+		},
+		{
+			// キーを優先し、URL が別の場所でもそのキーで書き換える
+			name: "key wins over foreign url",
+			row:  locationRow{AttachmentURL: str("https://cdn.example.com/x.png"), AttachmentObj: str("chat/1/a.png")},
+			url:  "http://minio:9000/chillow/chat/1/a.png", key: "chat/1/a.png", ok: true,
+		},
+		{
+			name: "key derived from source url",
+			row:  locationRow{AttachmentURL: str("http://api/uploads/chat/2/b.pdf")},
+			url:  "http://minio:9000/chillow/chat/2/b.pdf", key: "chat/2/b.pdf", ok: true,
+		},
+		{
+			name: "key derived from signed source url",
+			row:  locationRow{AttachmentURL: str("http://api/uploads/chat/2/b.pdf?exp=123&sig=abc"), AttachmentObj: str("")},
+			url:  "http://minio:9000/chillow/chat/2/b.pdf", key: "chat/2/b.pdf", ok: true,
+		},
+		{
+			name: "already rewritten",
+			row:  locationRow{AttachmentURL: str("http://minio:9000/chillow/chat/3/c.png"), AttachmentObj: str("chat/3/c.png")},
+			url:  "http://minio:9000/chillow/chat/3/c.png", key: "chat/3/c.png", ok: true,
+		},
+		{name: "foreign url without key", row: locationRow{AttachmentURL: str("https://cdn.example.com/chat/x.png")}},
+		{name: "destination url without key", row: locationRow{AttachmentURL: str("http://minio:9000/chillow/chat/x.png")}},
+		{name: "source base only", row: locationRow{AttachmentURL: str("http://api/uploads/")}},
+		{name: "empty key and no url", row: locationRow{AttachmentObj: str("")}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			url, key, ok := rewriteTarget(tt.row, srcBase, dst)
+			if url != tt.url || key != tt.key || ok != tt.ok {
+				t.Errorf("rewriteTarget = (%q, %q, %v), want (%q, %q, %v)", url, key, ok, tt.url, tt.key, tt.ok)
+			}
+		})
+	}
+}
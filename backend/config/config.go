@@ -2,9 +2,14 @@ package config
 
 import (
 	"bufio"
+	"crypto/hkdf"
+	"crypto/sha256"
+	"encoding/hex"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 )
 
 type Config struct {
@@ -32,6 +37,43 @@ type Config struct {
 	S3SecretKey       string
 	S3UsePathStyle    bool
 	AdminEmails       []string
+	SuperadminEmails  []string
+
+	// 添付の直接アップロード・署名 URL
+	StorageSigningSecret    string
+	AttachmentUploadURLTTL  time.Duration
+	AttachmentURLTTL        time.Duration
+	AttachmentClaimTTL      time.Duration // アップロード後、メッセージに使われないまま残せる時間
+	AttachmentSweepInterval time.Duration
+	ImageMaxPixels          int   // 幅×高さの上限（解凍爆弾対策）
+	StorageQuotaBytes       int64 // ユーザーごとの保存容量の既定上限（0 以下は無制限）
+	StorageGCInterval       time.Duration
+	StorageGCGrace          time.Duration // 参照のないオブジェクトを削除するまでの猶予
+	StorageGCDryRun         bool          // 定期実行で削除せずログに出すだけにする
+
+	// 添付のマルウェア検査
+	AttachmentScanner string // none / clamd
+	ClamdAddr         string
+	ClamdTimeout      time.Duration
+
+	// 制裁の段階（違反の回数に応じて次の制裁を提案する）
+	SanctionLadder []string      // kind または kind:期間（例: warning,mute:24h,temp_ban:72h,permanent_ban）
+	StrikeWindow   time.Duration // この期間内の制裁を違反の回数に数える
+
+	Broker              string
+	BrokerChannelPrefix string
+	RedisAddr           string
+	RedisPassword       string
+	RedisDB             int
+
+	ShutdownTimeout time.Duration
+
+	RetentionSweepInterval time.Duration
+	WSReplayLimit          int
+	WSPingInterval         time.Duration
+	WSPongTimeout          time.Duration
+	WSWriteTimeout         time.Duration
+	WSMaxMessageBytes      int
 }
 
 var Cfg *Config // グローバルにアクセス可能な設定
@@ -65,6 +107,45 @@ func LoadConfig() {
 		S3SecretKey:       os.Getenv("S3_SECRET_KEY"),
 		S3UsePathStyle:    parseBool(getEnv("S3_USE_PATH_STYLE", "false")),
 		AdminEmails:       splitAndTrim(os.Getenv("ADMIN_EMAILS")),
+		SuperadminEmails:  splitAndTrim(os.Getenv("SUPERADMIN_EMAILS")),
+
+		StorageSigningSecret:    storageSigningSecret(),
+		AttachmentUploadURLTTL:  parseDuration(os.Getenv("ATTACHMENT_UPLOAD_URL_TTL"), 10*time.Minute),
+		AttachmentURLTTL:        parseDuration(os.Getenv("ATTACHMENT_URL_TTL"), 5*time.Minute),
+		AttachmentClaimTTL:      parseDuration(os.Getenv("ATTACHMENT_CLAIM_TTL"), 24*time.Hour),
+		AttachmentSweepInterval: parseDuration(os.Getenv("ATTACHMENT_SWEEP_INTERVAL"), 10*time.Minute),
+		ImageMaxPixels:          parseInt(os.Getenv("IMAGE_MAX_PIXELS"), 40_000_000),
+		StorageQuotaBytes:       int64(parseInt(os.Getenv("STORAGE_QUOTA_BYTES"), 1<<30)),
+		StorageGCInterval:       parseDuration(os.Getenv("STORAGE_GC_INTERVAL"), 6*time.Hour),
+		StorageGCGrace:          parseDuration(os.Getenv("STORAGE_GC_GRACE"), 48*time.Hour),
+		StorageGCDryRun:         parseBool(getEnv("STORAGE_GC_DRY_RUN", "false")),
+
+		AttachmentScanner: getEnv("ATTACHMENT_SCANNER", "none"),
+		ClamdAddr:         getEnv("CLAMD_ADDR", "localhost:3310"),
+		ClamdTimeout:      parseDuration(os.Getenv("CLAMD_TIMEOUT"), 30*time.Second),
+
+		SanctionLadder: splitAndTrim(getEnv("SANCTION_LADDER", "warning,mute:24h,temp_ban:72h,temp_ban:720h,permanent_ban")),
+		StrikeWindow:   parseDuration(os.Getenv("STRIKE_WINDOW"), 90*24*time.Hour),
+
+		Broker:              getEnv("BROKER", "memory"),
+		BrokerChannelPrefix: getEnv("BROKER_CHANNEL_PREFIX", "chillow"),
+		RedisAddr:           getEnv("REDIS_ADDR", "localhost:6379"),
+		RedisPassword:       os.Getenv("REDIS_PASSWORD"),
+		RedisDB:             parseInt(os.Getenv("REDIS_DB"), 0),
+
+		ShutdownTimeout: parseDuration(os.Getenv("SHUTDOWN_TIMEOUT"), 20*time.Second),
+
+		RetentionSweepInterval: parseDuration(os.Getenv("RETENTION_SWEEP_INTERVAL"), time.Minute),
+		WSReplayLimit:          parseInt(os.Getenv("WS_REPLAY_LIMIT"), 200),
+		WSPingInterval:         parseDuration(os.Getenv("WS_PING_INTERVAL"), 25*time.Second),
+		WSPongTimeout:          parseDuration(os.Getenv("WS_PONG_TIMEOUT"), 60*time.Second),
+		WSWriteTimeout:         parseDuration(os.Getenv("WS_WRITE_TIMEOUT"), 10*time.Second),
+		WSMaxMessageBytes:      parseInt(os.Getenv("WS_MAX_MESSAGE_BYTES"), 32*1024),
+	}
+
+	// ping は pong 待ちより短くないと生存中の接続まで切断してしまう
+	if Cfg.WSPingInterval >= Cfg.WSPongTimeout {
+		Cfg.WSPingInterval = Cfg.WSPongTimeout * 9 / 10
 	}
 }
 
@@ -84,6 +165,45 @@ func parseBool(val string) bool {
 	}
 }
 
+func parseDuration(val string, fallback time.Duration) time.Duration {
+	if val == "" {
+		return fallback
+	}
+	d, err := time.ParseDuration(strings.TrimSpace(val))
+	if err != nil || d <= 0 {
+		return fallback
+	}
+	return d
+}
+
+func parseInt(val string, fallback int) int {
+	if val == "" {
+		return fallback
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(val))
+	if err != nil || n <= 0 {
+		return fallback
+	}
+	return n
+}
+
+// storageSigningSecret は署名付き URL の鍵。未指定なら JWT_SECRET から用途を区別した鍵を導出する
+// （JWT の署名鍵をそのまま使うと、一方の署名をもう一方の検証に通せる余地が生まれるため）
+func storageSigningSecret() string {
+	if v := os.Getenv("STORAGE_SIGNING_SECRET"); v != "" {
+		return v
+	}
+	jwtSecret := os.Getenv("JWT_SECRET")
+	if jwtSecret == "" {
+		return ""
+	}
+	key, err := hkdf.Key(sha256.New, []byte(jwtSecret), nil, "chillow storage signing v1", 32)
+	if err != nil {
+		return ""
+	}
+	return hex.EncodeToString(key)
+}
+
 func splitAndTrim(val string) []string {
 	if val == "" {
 		return nil
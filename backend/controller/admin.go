@@ -2,7 +2,9 @@ package controller
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"strconv"
 	"strings"
@@ -10,6 +12,7 @@ import (
 
 	"chillow/adminstream"
 	"chillow/db"
+	"chillow/errcode"
 	"chillow/model"
 	"chillow/ws"
 
@@ -21,10 +24,14 @@ func AdminHealthHandler(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"message": "admin endpoint reachable"})
 }
 
+func AdminMetricsHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"websocket": ws.Metrics()})
+}
+
 func AdminEventsHandler(c *gin.Context) {
 	flusher, ok := c.Writer.(http.Flusher)
 	if !ok {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "stream not supported"})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "stream not supported", "code": errcode.Internal})
 		return
 	}
 
@@ -45,6 +52,9 @@ func AdminEventsHandler(c *gin.Context) {
 		select {
 		case evt, ok := <-ch:
 			if !ok {
+				// サーバー停止。EventSource に再接続までの待ち時間を伝えて終了
+				_, _ = c.Writer.Write([]byte("retry: 3000\ndata: {\"type\":\"server:restarting\"}\n\n"))
+				flusher.Flush()
 				return
 			}
 			data, err := json.Marshal(evt)
@@ -66,87 +76,82 @@ func AdminEventsHandler(c *gin.Context) {
 	}
 }
 
+// POST /api/admin/users/:id/ban
+// duration_hours があれば temp_ban、なければ permanent_ban の制裁を記録する
 func AdminBanUserHandler(c *gin.Context) {
-	adminID := c.GetUint("user_id")
-	targetID, err := strconv.Atoi(c.Param("id"))
-	if err != nil || targetID <= 0 {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user id"})
-		return
-	}
-
 	var req struct {
 		Reason        string `json:"reason"`
 		DurationHours *int   `json:"duration_hours"`
 	}
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid payload"})
-		return
-	}
-	reason := strings.TrimSpace(req.Reason)
-	if reason == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "ban reason is required"})
-		return
-	}
-
-	var user model.User
-	if err := db.DB.First(&user, targetID).Error; err != nil {
-		if err == gorm.ErrRecordNotFound {
-			c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
-			return
-		}
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load user"})
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid payload", "code": errcode.InvalidPayload})
 		return
 	}
-
-	if user.ID == adminID {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "cannot ban yourself"})
-		return
-	}
-	if user.Role == "admin" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "cannot ban another admin"})
+	user, ok := loadTargetUser(c)
+	if !ok {
 		return
 	}
-
-	if err := applyBan(&user, reason, req.DurationHours); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to ban user"})
+	s := newSanction(c, user, sanctionInput{Kind: banKind(req.DurationHours), Reason: req.Reason, DurationHours: req.DurationHours})
+	if s == nil {
 		return
 	}
-	if err := db.DB.Save(&user).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to ban user"})
+	if err := db.DB.Transaction(func(tx *gorm.DB) error { return recordSanction(c, tx, user, s) }); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to ban user", "code": errcode.Internal})
 		return
 	}
-	ws.DisconnectUser(user.ID, reason)
-	adminstream.Broadcast(adminstream.Event{Type: "user:banned", User: &user})
+	announceSanction(user, s)
 
 	c.JSON(http.StatusOK, gin.H{
 		"message":      "user banned",
 		"banned_until": user.BanExpiresAt,
+		"sanction":     s,
 	})
 }
 
+// POST /api/admin/users/:id/unban
+// 有効な BAN の制裁をすべて解除する（履歴には revoked_at 付きで残り、違反の回数にも数える）
 func AdminUnbanUserHandler(c *gin.Context) {
-	targetID, err := strconv.Atoi(c.Param("id"))
-	if err != nil || targetID <= 0 {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user id"})
+	// 理由は任意（監査ログに残る）。本文なしでも受け付ける
+	var req struct {
+		Reason string `json:"reason"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid payload", "code": errcode.InvalidPayload})
+		return
+	}
+	user, ok := loadTargetUser(c)
+	if !ok {
 		return
 	}
 
-	var user model.User
-	if err := db.DB.First(&user, targetID).Error; err != nil {
-		if err == gorm.ErrRecordNotFound {
-			c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
-			return
-		}
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load user"})
+	// 無期限 BAN の解除は発行と同じ権限が必要
+	ban, err := model.ActiveBan(db.DB, user.ID, time.Now())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to unban user", "code": errcode.Internal})
+		return
+	}
+	if ban != nil && ban.Kind == model.SanctionPermBan && !allowPermanentBan(c, "lifting a permanent ban requires permission") {
 		return
 	}
 
+	reason := strings.TrimSpace(req.Reason)
+	before := user.ModerationState()
 	user.ClearBan()
-	if err := db.DB.Save(&user).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to unban user"})
+	err = db.DB.Transaction(func(tx *gorm.DB) error {
+		if _, err := model.RevokeActiveSanctions(tx, user.ID, c.GetUint("user_id"), reason, time.Now(), model.SanctionTempBan, model.SanctionPermBan); err != nil {
+			return err
+		}
+		if err := model.SaveBanState(tx, user); err != nil {
+			return err
+		}
+		entry := newAuditEntry(c, model.AuditUserUnban, model.AuditTargetUser, user.ID, reason)
+		return model.RecordAudit(tx, entry, before, user.ModerationState())
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to unban user", "code": errcode.Internal})
 		return
 	}
-	adminstream.Broadcast(adminstream.Event{Type: "user:unbanned", User: &user})
+	adminstream.Broadcast(adminstream.Event{Type: "user:unbanned", User: user})
 
 	c.JSON(http.StatusOK, gin.H{"message": "user unbanned"})
 }
@@ -159,85 +164,122 @@ func AdminListReportsHandler(c *gin.Context) {
 	}
 	var reports []model.Report
 	if err := query.Order("created_at ASC").Find(&reports).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load reports"})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load reports", "code": errcode.Internal})
 		return
 	}
+	for i := range reports {
+		reports[i] = reports[i].WithAccessURL()
+	}
 	c.JSON(http.StatusOK, reports)
 }
 
+// GET /api/admin/reports/:id/attachment
+// 通報の証拠として保全した添付を期限付き URL で開く
+func AdminReportAttachmentHandler(c *gin.Context) {
+	reportID, err := strconv.Atoi(c.Param("id"))
+	if err != nil || reportID <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid report id", "code": errcode.InvalidPayload})
+		return
+	}
+	var report model.Report
+	if err := db.DB.First(&report, reportID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "report not found", "code": errcode.NotFound})
+		return
+	}
+	if report.AttachmentObj == nil || *report.AttachmentObj == "" {
+		c.JSON(http.StatusNotFound, gin.H{"error": "attachment not found", "code": errcode.NotFound})
+		return
+	}
+	filename := ""
+	if report.MessageType == model.AttachmentKindFile && report.AttachmentName != nil {
+		filename = *report.AttachmentName
+	}
+	redirectToSignedURL(c, *report.AttachmentObj, filename)
+}
+
+// 通報の処理で選べる制裁（reject は制裁なし）
+var resolveSanctionLabels = map[string]string{"ban": "BAN", "warn": "WARNING", "mute": "MUTE"}
+
 func AdminResolveReportHandler(c *gin.Context) {
 	adminID := c.GetUint("user_id")
 	reportID, err := strconv.Atoi(c.Param("id"))
 	if err != nil || reportID <= 0 {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid report id"})
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid report id", "code": errcode.InvalidPayload})
 		return
 	}
 
 	var body struct {
-		Action        string `json:"action"` // ban or reject
+		Action        string `json:"action"` // ban / warn / mute / reject
 		Note          string `json:"note"`
-		BanReason     string `json:"ban_reason"`
+		Reason        string `json:"reason"`
+		BanReason     string `json:"ban_reason"` // reason の旧名
 		DurationHours *int   `json:"duration_hours"`
 	}
 	if err := c.ShouldBindJSON(&body); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid payload"})
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid payload", "code": errcode.InvalidPayload})
 		return
 	}
 
 	action := strings.ToLower(strings.TrimSpace(body.Action))
-	if action != "ban" && action != "reject" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid action"})
+	label, sanctioning := resolveSanctionLabels[action]
+	if !sanctioning && action != "reject" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid action", "code": errcode.InvalidPayload})
 		return
 	}
 
 	var report model.Report
 	if err := db.DB.First(&report, reportID).Error; err != nil {
 		if err == gorm.ErrRecordNotFound {
-			c.JSON(http.StatusNotFound, gin.H{"error": "report not found"})
+			c.JSON(http.StatusNotFound, gin.H{"error": "report not found", "code": errcode.NotFound})
 			return
 		}
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load report"})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load report", "code": errcode.Internal})
 		return
 	}
 	if report.Status != "pending" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "report already resolved"})
+		c.JSON(http.StatusBadRequest, gin.H{"error": "report already resolved", "code": errcode.NotAllowed})
 		return
 	}
 
 	now := time.Now()
 	resolution := action
 	note := strings.TrimSpace(body.Note)
-	if action == "ban" {
-		banReason := strings.TrimSpace(body.BanReason)
-		if banReason == "" {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "ban_reason is required"})
+	var sanctioned *model.User
+	var sanction *model.Sanction
+	if sanctioning {
+		if !model.RoleHasPermission(c.GetString("user_role"), model.PermUsersBan) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "permission required", "code": errcode.Forbidden, "permission": model.PermUsersBan})
 			return
 		}
 		var user model.User
 		if err := db.DB.First(&user, report.ReportedUserID).Error; err != nil {
 			if err == gorm.ErrRecordNotFound {
-				c.JSON(http.StatusNotFound, gin.H{"error": "reported user not found"})
+				c.JSON(http.StatusNotFound, gin.H{"error": "reported user not found", "code": errcode.NotFound})
 				return
 			}
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load user"})
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load user", "code": errcode.Internal})
 			return
 		}
-		if user.Role == "admin" {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "cannot ban another admin"})
-			return
+		kind := model.SanctionWarning
+		switch action {
+		case "ban":
+			kind = banKind(body.DurationHours)
+		case "mute":
+			kind = model.SanctionMute
 		}
-		if err := applyBan(&user, banReason, body.DurationHours); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to ban user"})
-			return
+		reason := body.Reason
+		if strings.TrimSpace(reason) == "" {
+			reason = body.BanReason
 		}
-		if err := db.DB.Save(&user).Error; err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to ban user"})
+		sanction = newSanction(c, &user, sanctionInput{Kind: kind, Reason: reason, DurationHours: body.DurationHours, ReportID: &report.ID})
+		if sanction == nil {
 			return
 		}
-		ws.DisconnectUser(user.ID, banReason)
-		note = fmt.Sprintf("BAN: %s", banReason)
+		sanctioned = &user
+		note = fmt.Sprintf("%s: %s", label, sanction.Reason)
 	}
 
+	reportBefore := report.State()
 	report.Status = "resolved"
 	report.Resolution = &resolution
 	if note != "" {
@@ -245,36 +287,53 @@ func AdminResolveReportHandler(c *gin.Context) {
 	}
 	report.HandledBy = &adminID
 	report.HandledAt = &now
-	if err := db.DB.Save(&report).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update report"})
+	// 制裁・通報の更新と監査ログはまとめて保存する
+	err = db.DB.Transaction(func(tx *gorm.DB) error {
+		if sanction != nil {
+			if err := recordSanction(c, tx, sanctioned, sanction); err != nil {
+				return err
+			}
+		}
+		if err := tx.Save(&report).Error; err != nil {
+			return err
+		}
+		entry := newAuditEntry(c, model.AuditReportResolve, model.AuditTargetReport, report.ID, note)
+		return model.RecordAudit(tx, entry, reportBefore, report.State())
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update report", "code": errcode.Internal})
 		return
 	}
+	if sanction != nil {
+		announceSanction(sanctioned, sanction)
+	}
 	if err := db.DB.Preload("Reporter").Preload("ReportedUser").Preload("HandledByUser").First(&report, report.ID).Error; err == nil {
-		adminstream.Broadcast(adminstream.Event{Type: "report:resolved", Report: &report})
+		resolved := report.WithAccessURL()
+		adminstream.Broadcast(adminstream.Event{Type: "report:resolved", Report: &resolved})
 	}
 	cleanupAttachmentEvidence(report.MessageID, report.AttachmentObj)
-	c.JSON(http.StatusOK, report)
+	c.JSON(http.StatusOK, report.WithAccessURL())
 }
 
 func AdminListBannedUsersHandler(c *gin.Context) {
+	// is_banned は認証時に合わせ直す写しなので、期限切れの BAN が残らないよう sanctions で絞り込む
+	now := time.Now()
 	var users []model.User
-	if err := db.DB.Where("is_banned = ?", true).Order("banned_at DESC").Find(&users).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch banned users"})
+	if err := model.WhereBanned(db.DB, true, now).Order("banned_at DESC").Find(&users).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch banned users", "code": errcode.Internal})
+		return
+	}
+	if err := model.SyncBanStates(users, now); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch banned users", "code": errcode.Internal})
 		return
 	}
 	c.JSON(http.StatusOK, users)
 }
 
-func applyBan(user *model.User, reason string, durationHours *int) error {
-	now := time.Now()
-	user.IsBanned = true
-	user.BannedAt = &now
-	user.BanReason = &reason
+// banKind は期間の指定があれば temp_ban、なければ permanent_ban
+func banKind(durationHours *int) string {
 	if durationHours != nil && *durationHours > 0 {
-		expires := now.Add(time.Duration(*durationHours) * time.Hour)
-		user.BanExpiresAt = &expires
-	} else {
-		user.BanExpiresAt = nil
+		return model.SanctionTempBan
 	}
-	return nil
+	return model.SanctionPermBan
 }
@@ -0,0 +1,158 @@
+package controller
+
+import (
+	"encoding/csv"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"chillow/db"
+	"chillow/errcode"
+	"chillow/model"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	auditPageSize    = 50
+	auditMaxPageSize = 200
+	auditExportBatch = 500
+)
+
+// newAuditEntry は操作した管理者と接続元を埋めた監査ログを作る
+func newAuditEntry(c *gin.Context, action, targetType string, targetID uint, reason string) *model.AuditLog {
+	return &model.AuditLog{
+		ActorID:    c.GetUint("user_id"),
+		Action:     action,
+		TargetType: targetType,
+		TargetID:   targetID,
+		Reason:     reason,
+		IP:         c.ClientIP(),
+	}
+}
+
+// GET /api/admin/audit?actor_id=&action=&target_type=&target_id=&since=&until=&before_id=&limit=&format=csv
+// 監査ログを新しい順に返す。format=csv なら条件に合うものをすべて CSV で出力する
+func AdminListAuditLogsHandler(c *gin.Context) {
+	filter, err := parseAuditFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "code": errcode.InvalidPayload})
+		return
+	}
+	if c.Query("format") == "csv" {
+		exportAuditLogs(c, filter)
+		return
+	}
+
+	limit := auditPageSize
+	if raw := c.Query("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid limit", "code": errcode.InvalidPayload})
+			return
+		}
+		limit = min(n, auditMaxPageSize)
+	}
+
+	var logs []model.AuditLog
+	if err := filter.Apply(db.DB.Preload("Actor")).Order("id DESC").Limit(limit).Find(&logs).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load audit logs", "code": errcode.Internal})
+		return
+	}
+	// 続きは next_before_id を before_id に指定して取得する
+	var next *uint
+	if len(logs) == limit {
+		next = &logs[len(logs)-1].ID
+	}
+	c.JSON(http.StatusOK, gin.H{"logs": logs, "next_before_id": next})
+}
+
+func parseAuditFilter(c *gin.Context) (model.AuditFilter, error) {
+	var f model.AuditFilter
+	for name, dst := range map[string]*uint{"actor_id": &f.ActorID, "target_id": &f.TargetID, "before_id": &f.BeforeID} {
+		raw := c.Query(name)
+		if raw == "" {
+			continue
+		}
+		n, err := strconv.ParseUint(raw, 10, 64)
+		if err != nil || n == 0 {
+			return f, fmt.Errorf("invalid %s", name)
+		}
+		*dst = uint(n)
+	}
+	f.Action = strings.TrimSpace(c.Query("action"))
+	f.TargetType = strings.TrimSpace(c.Query("target_type"))
+	for name, dst := range map[string]**time.Time{"since": &f.Since, "until": &f.Until} {
+		raw := c.Query(name)
+		if raw == "" {
+			continue
+		}
+		t, err := parseAuditTime(raw)
+		if err != nil {
+			return f, fmt.Errorf("invalid %s (RFC3339 or YYYY-MM-DD)", name)
+		}
+		*dst = &t
+	}
+	return f, nil
+}
+
+func parseAuditTime(raw string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, raw); err == nil {
+		return t, nil
+	}
+	return time.ParseInLocation("2006-01-02", raw, time.Local)
+}
+
+// exportAuditLogs は ID の降順に少しずつ読みながら CSV を書き出す
+func exportAuditLogs(c *gin.Context, filter model.AuditFilter) {
+	filename := fmt.Sprintf("audit-%s.csv", time.Now().Format("20060102-150405"))
+	c.Header("Content-Type", "text/csv; charset=utf-8")
+	c.Header("Content-Disposition", `attachment; filename="`+filename+`"`)
+	c.Status(http.StatusOK)
+
+	w := csv.NewWriter(c.Writer)
+	_ = w.Write([]string{"id", "created_at", "actor_id", "actor_email", "action", "target_type", "target_id", "reason", "ip", "before", "after"})
+	for {
+		var logs []model.AuditLog
+		if err := filter.Apply(db.DB.Preload("Actor")).Order("id DESC").Limit(auditExportBatch).Find(&logs).Error; err != nil {
+			// ヘッダー送信後なので途中で打ち切るしかない
+			log.Printf("❌ failed to export audit logs: %v", err)
+			break
+		}
+		for _, entry := range logs {
+			actorEmail := ""
+			if entry.Actor != nil {
+				actorEmail = entry.Actor.Email
+			}
+			_ = w.Write([]string{
+				strconv.FormatUint(uint64(entry.ID), 10),
+				entry.CreatedAt.Format(time.RFC3339),
+				strconv.FormatUint(uint64(entry.ActorID), 10),
+				actorEmail,
+				entry.Action,
+				entry.TargetType,
+				strconv.FormatUint(uint64(entry.TargetID), 10),
+				csvSafe(entry.Reason),
+				entry.IP,
+				string(entry.Before),
+				string(entry.After),
+			})
+		}
+		w.Flush()
+		if w.Error() != nil || len(logs) < auditExportBatch {
+			break
+		}
+		filter.BeforeID = logs[len(logs)-1].ID
+	}
+}
+
+// csvSafe は表計算ソフトで数式として解釈される先頭文字を無効にする
+func csvSafe(s string) string {
+	if s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
@@ -0,0 +1,153 @@
+package controller
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"chillow/adminstream"
+	"chillow/db"
+	"chillow/errcode"
+	"chillow/model"
+	"chillow/ws"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// GET /api/admin/roles
+// ロールごとの権限と、操作している本人の権限を返す（管理画面の表示切り替え用）
+func AdminListRolesHandler(c *gin.Context) {
+	roles := make([]gin.H, 0, len(model.StaffRoles()))
+	for _, role := range model.StaffRoles() {
+		roles = append(roles, gin.H{"role": role, "permissions": model.RolePermissions(role)})
+	}
+	role := c.GetString("user_role")
+	c.JSON(http.StatusOK, gin.H{
+		"roles":       roles,
+		"role":        role,
+		"permissions": model.RolePermissions(role),
+	})
+}
+
+// GET /api/admin/staff
+func AdminListStaffHandler(c *gin.Context) {
+	var users []model.User
+	if err := db.DB.Where("role IN ?", model.StaffRoles()).Order("id ASC").Find(&users).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load staff", "code": errcode.Internal})
+		return
+	}
+	c.JSON(http.StatusOK, users)
+}
+
+// PUT /api/admin/users/:id/role
+// ロールを付与・変更する（理由必須）
+func AdminGrantRoleHandler(c *gin.Context) {
+	var req struct {
+		Role   string `json:"role"`
+		Reason string `json:"reason"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid payload", "code": errcode.InvalidPayload})
+		return
+	}
+	role := strings.ToLower(strings.TrimSpace(req.Role))
+	if !model.ValidRole(role) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid role", "code": errcode.InvalidPayload})
+		return
+	}
+	changeUserRole(c, role, req.Reason)
+}
+
+// DELETE /api/admin/users/:id/role
+// スタッフのロールを外して user に戻す（理由必須）
+func AdminRevokeRoleHandler(c *gin.Context) {
+	var req struct {
+		Reason string `json:"reason"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid payload", "code": errcode.InvalidPayload})
+		return
+	}
+	changeUserRole(c, model.RoleUser, req.Reason)
+}
+
+func changeUserRole(c *gin.Context, role, reason string) {
+	targetID, err := strconv.Atoi(c.Param("id"))
+	if err != nil || targetID <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user id", "code": errcode.InvalidPayload})
+		return
+	}
+	reason = strings.TrimSpace(reason)
+	if reason == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "reason is required", "code": errcode.InvalidPayload})
+		return
+	}
+	if uint(targetID) == c.GetUint("user_id") {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "cannot change your own role", "code": errcode.NotAllowed})
+		return
+	}
+
+	var user model.User
+	if err := db.DB.First(&user, targetID).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "user not found", "code": errcode.NotFound})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load user", "code": errcode.Internal})
+		return
+	}
+	// 設定で付与したロールは次のログイン・起動時に戻ってしまうので、設定側で変更してもらう
+	if user.RoleSource == model.RoleSourceConfig {
+		c.JSON(http.StatusConflict, gin.H{"error": "role is managed by ADMIN_EMAILS / SUPERADMIN_EMAILS", "code": errcode.Conflict})
+		return
+	}
+	if model.IsStaffRole(role) {
+		ban, err := model.CurrentBan(&user, time.Now())
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load user", "code": errcode.Internal})
+			return
+		}
+		if ban != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "cannot grant a staff role to a banned user", "code": errcode.NotAllowed})
+			return
+		}
+	}
+	if user.Role == role {
+		c.JSON(http.StatusOK, user)
+		return
+	}
+
+	before := gin.H{"role": user.Role, "role_source": user.RoleSource}
+	wasStaff := model.IsStaffRole(user.Role)
+	user.Role = role
+	user.RoleSource = model.RoleSourceManual
+	if role == model.RoleUser {
+		user.RoleSource = ""
+	}
+	action := "granted"
+	if role == model.RoleUser {
+		action = "revoked"
+	}
+	err = db.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&user).Updates(map[string]interface{}{"role": user.Role, "role_source": user.RoleSource}).Error; err != nil {
+			return err
+		}
+		entry := newAuditEntry(c, model.AuditUserRole, model.AuditTargetUser, user.ID, reason)
+		return model.RecordAudit(tx, entry, before, gin.H{"role": user.Role, "role_source": user.RoleSource})
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update role", "code": errcode.Internal})
+		return
+	}
+
+	// スタッフはチャットを使えないので、接続中のチャットから切断する
+	if !wasStaff && model.IsStaffRole(role) {
+		ws.DisconnectUser(user.ID, "role changed")
+	}
+	adminstream.Broadcast(adminstream.Event{Type: "user:role_" + action, User: &user})
+	c.JSON(http.StatusOK, user)
+}
@@ -0,0 +1,281 @@
+package controller
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"chillow/adminstream"
+	"chillow/config"
+	"chillow/db"
+	"chillow/errcode"
+	"chillow/model"
+	"chillow/ws"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type sanctionInput struct {
+	Kind          string
+	Reason        string
+	DurationHours *int
+	ReportID      *uint
+}
+
+// newSanction は入力を確かめて制裁を組み立てる（問題があればレスポンスを書いて nil を返す）
+func newSanction(c *gin.Context, user *model.User, in sanctionInput) *model.Sanction {
+	kind := strings.ToLower(strings.TrimSpace(in.Kind))
+	if !model.ValidSanctionKind(kind) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid sanction kind", "code": errcode.InvalidPayload})
+		return nil
+	}
+	reason := strings.TrimSpace(in.Reason)
+	if reason == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "reason is required", "code": errcode.InvalidPayload})
+		return nil
+	}
+	adminID := c.GetUint("user_id")
+	if user.ID == adminID {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "cannot sanction yourself", "code": errcode.NotAllowed})
+		return nil
+	}
+	if model.IsStaffRole(user.Role) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "cannot sanction staff accounts", "code": errcode.NotAllowed})
+		return nil
+	}
+	if kind == model.SanctionPermBan && !allowPermanentBan(c, "permanent bans require permission, specify duration_hours") {
+		return nil
+	}
+
+	s := &model.Sanction{UserID: user.ID, Kind: kind, IssuedBy: &adminID, Reason: reason, ReportID: in.ReportID}
+	if model.SanctionNeedsDuration(kind) {
+		if in.DurationHours == nil || *in.DurationHours <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "duration_hours is required for " + kind, "code": errcode.InvalidPayload})
+			return nil
+		}
+		expires := time.Now().Add(time.Duration(*in.DurationHours) * time.Hour)
+		s.ExpiresAt = &expires
+	}
+	return s
+}
+
+// allowPermanentBan は無期限 BAN の発行・解除・取り消しの権限を確かめる（なければ 403 を書いて false）
+func allowPermanentBan(c *gin.Context, message string) bool {
+	if model.RoleHasPermission(c.GetString("user_role"), model.PermUsersBanPermanent) {
+		return true
+	}
+	c.JSON(http.StatusForbidden, gin.H{"error": message, "code": errcode.Forbidden, "permission": model.PermUsersBanPermanent})
+	return false
+}
+
+// recordSanction は制裁と監査ログを保存する（tx 内で呼ぶ）。BAN ならユーザーの表示用の項目も更新する
+func recordSanction(c *gin.Context, tx *gorm.DB, user *model.User, s *model.Sanction) error {
+	if err := tx.Create(s).Error; err != nil {
+		return err
+	}
+	if !s.IsBan() {
+		entry := newAuditEntry(c, model.AuditUserSanction, model.AuditTargetUser, user.ID, s.Reason)
+		return model.RecordAudit(tx, entry, nil, s)
+	}
+	before := user.ModerationState()
+	// 既に長い BAN があればそちらが有効なまま
+	ban, err := model.ActiveBan(tx, user.ID, time.Now())
+	if err != nil {
+		return err
+	}
+	user.SyncBan(ban)
+	if err := model.SaveBanState(tx, user); err != nil {
+		return err
+	}
+	entry := newAuditEntry(c, model.AuditUserBan, model.AuditTargetUser, user.ID, s.Reason)
+	return model.RecordAudit(tx, entry, before, user.ModerationState())
+}
+
+// announceSanction は保存した制裁を反映する（BAN なら接続を切る）
+func announceSanction(user *model.User, s *model.Sanction) {
+	if s.IsBan() {
+		ws.DisconnectUser(user.ID, s.Reason)
+		adminstream.Broadcast(adminstream.Event{Type: "user:banned", User: user, Sanction: s})
+		return
+	}
+	adminstream.Broadcast(adminstream.Event{Type: "user:sanctioned", User: user, Sanction: s})
+}
+
+type sanctionSummary struct {
+	Sanctions         []model.Sanction   `json:"sanctions"` // 新しい順（解除・期限切れを含む）
+	Strikes           int64              `json:"strikes"`   // STRIKE_WINDOW 内の制裁の件数（取り消したものを除く）
+	StrikeWindowHours int                `json:"strike_window_hours"`
+	Suggestion        model.SanctionStep `json:"suggestion"` // 次に違反したときの制裁
+}
+
+func loadSanctionSummary(userID uint, limit int) (sanctionSummary, error) {
+	summary := sanctionSummary{StrikeWindowHours: int(config.Cfg.StrikeWindow.Hours())}
+	q := db.DB.Preload("Issuer").Where("user_id = ?", userID).Order("id DESC")
+	if limit > 0 {
+		q = q.Limit(limit)
+	}
+	if err := q.Find(&summary.Sanctions).Error; err != nil {
+		return summary, err
+	}
+	strikes, err := model.CountStrikes(userID, time.Now().Add(-config.Cfg.StrikeWindow))
+	if err != nil {
+		return summary, err
+	}
+	summary.Strikes = strikes
+	summary.Suggestion = model.NextSanction(strikes)
+	return summary, nil
+}
+
+// GET /api/admin/users/:id/sanctions
+// 制裁の履歴と違反の回数、段階に沿った次の制裁の提案を返す
+func AdminListSanctionsHandler(c *gin.Context) {
+	user, ok := loadTargetUser(c)
+	if !ok {
+		return
+	}
+	summary, err := loadSanctionSummary(user.ID, 0)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load sanctions", "code": errcode.Internal})
+		return
+	}
+	c.JSON(http.StatusOK, summary)
+}
+
+// POST /api/admin/users/:id/sanctions
+// { "kind": "mute", "reason": "...", "duration_hours": 24, "report_id": 3 }
+func AdminIssueSanctionHandler(c *gin.Context) {
+	var req struct {
+		Kind          string `json:"kind"`
+		Reason        string `json:"reason"`
+		DurationHours *int   `json:"duration_hours"`
+		ReportID      *uint  `json:"report_id"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid payload", "code": errcode.InvalidPayload})
+		return
+	}
+	user, ok := loadTargetUser(c)
+	if !ok {
+		return
+	}
+	if req.ReportID != nil {
+		var report model.Report
+		if err := db.DB.First(&report, *req.ReportID).Error; err != nil || report.ReportedUserID != user.ID {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "report does not belong to this user", "code": errcode.NotAllowed})
+			return
+		}
+	}
+	s := newSanction(c, user, sanctionInput{Kind: req.Kind, Reason: req.Reason, DurationHours: req.DurationHours, ReportID: req.ReportID})
+	if s == nil {
+		return
+	}
+	if err := db.DB.Transaction(func(tx *gorm.DB) error { return recordSanction(c, tx, user, s) }); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to issue sanction", "code": errcode.Internal})
+		return
+	}
+	announceSanction(user, s)
+	c.JSON(http.StatusOK, gin.H{"sanction": s, "user": user})
+}
+
+// POST /api/admin/sanctions/:id/revoke
+// 誤った制裁を取り消す（理由必須）。取り消した制裁は違反の回数に数えない
+func AdminRevokeSanctionHandler(c *gin.Context) {
+	sanctionID, err := strconv.Atoi(c.Param("id"))
+	if err != nil || sanctionID <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid sanction id", "code": errcode.InvalidPayload})
+		return
+	}
+	var req struct {
+		Reason string `json:"reason"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid payload", "code": errcode.InvalidPayload})
+		return
+	}
+	reason := strings.TrimSpace(req.Reason)
+	if reason == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "reason is required", "code": errcode.InvalidPayload})
+		return
+	}
+
+	var s model.Sanction
+	if err := db.DB.First(&s, sanctionID).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "sanction not found", "code": errcode.NotFound})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load sanction", "code": errcode.Internal})
+		return
+	}
+	if s.Voided {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "sanction already revoked", "code": errcode.NotAllowed})
+		return
+	}
+	if s.Kind == model.SanctionPermBan && !allowPermanentBan(c, "revoking a permanent ban requires permission") {
+		return
+	}
+	var user model.User
+	if err := db.DB.First(&user, s.UserID).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load user", "code": errcode.Internal})
+		return
+	}
+
+	before := s
+	now := time.Now()
+	adminID := c.GetUint("user_id")
+	// BAN 解除で終わっている制裁も、取り消せば回数に数えなくなる
+	if s.RevokedAt == nil {
+		s.RevokedAt = &now
+		s.RevokedBy = &adminID
+	}
+	s.RevokeReason = &reason
+	s.Voided = true
+	err = db.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&s).Select("revoked_at", "revoked_by", "revoke_reason", "voided").Updates(&s).Error; err != nil {
+			return err
+		}
+		if s.IsBan() {
+			ban, err := model.ActiveBan(tx, user.ID, now)
+			if err != nil {
+				return err
+			}
+			user.SyncBan(ban)
+			if err := model.SaveBanState(tx, &user); err != nil {
+				return err
+			}
+		}
+		entry := newAuditEntry(c, model.AuditSanctionRevoke, model.AuditTargetSanction, s.ID, reason)
+		return model.RecordAudit(tx, entry, before, s)
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to revoke sanction", "code": errcode.Internal})
+		return
+	}
+	if s.IsBan() && !user.IsBanned {
+		adminstream.Broadcast(adminstream.Event{Type: "user:unbanned", User: &user, Sanction: &s})
+	} else {
+		adminstream.Broadcast(adminstream.Event{Type: "sanction:revoked", User: &user, Sanction: &s})
+	}
+	c.JSON(http.StatusOK, gin.H{"sanction": s, "user": user})
+}
+
+// loadTargetUser は :id のユーザーを読み込む（なければレスポンスを書いて false）
+func loadTargetUser(c *gin.Context) (*model.User, bool) {
+	targetID, err := strconv.Atoi(c.Param("id"))
+	if err != nil || targetID <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user id", "code": errcode.InvalidPayload})
+		return nil, false
+	}
+	var user model.User
+	if err := db.DB.First(&user, targetID).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "user not found", "code": errcode.NotFound})
+			return nil, false
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load user", "code": errcode.Internal})
+		return nil, false
+	}
+	return &user, true
+}
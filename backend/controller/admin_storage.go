@@ -0,0 +1,145 @@
+package controller
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"chillow/config"
+	"chillow/db"
+	"chillow/errcode"
+	"chillow/model"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultStorageConsumers = 20
+	maxStorageConsumers     = 100
+)
+
+// GET /api/admin/storage/usage?limit=20
+// 保存容量の使用量が多いユーザーを返す
+func AdminStorageUsageHandler(c *gin.Context) {
+	limit := defaultStorageConsumers
+	if raw := c.Query("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid limit", "code": errcode.InvalidPayload})
+			return
+		}
+		limit = min(n, maxStorageConsumers)
+	}
+
+	consumers, err := model.TopStorageConsumers(limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load storage usage", "code": errcode.Internal})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"default_quota_bytes": config.Cfg.StorageQuotaBytes,
+		"users":               consumers,
+	})
+}
+
+// PUT /api/admin/users/:id/storage-quota
+// ユーザーごとの保存容量の上限を変更する（quota_bytes が null なら既定値に戻す）
+func AdminUpdateStorageQuotaHandler(c *gin.Context) {
+	targetID, err := strconv.Atoi(c.Param("id"))
+	if err != nil || targetID <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user id", "code": errcode.InvalidPayload})
+		return
+	}
+
+	var req struct {
+		QuotaBytes *int64 `json:"quota_bytes"`
+		Reason     string `json:"reason"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid payload", "code": errcode.InvalidPayload})
+		return
+	}
+	if req.QuotaBytes != nil && *req.QuotaBytes < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "quota_bytes must not be negative", "code": errcode.InvalidPayload})
+		return
+	}
+
+	var user model.User
+	if err := db.DB.First(&user, targetID).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "user not found", "code": errcode.NotFound})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load user", "code": errcode.Internal})
+		return
+	}
+
+	before := user.ModerationState()
+	err = db.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&user).Update("storage_quota_bytes", req.QuotaBytes).Error; err != nil {
+			return err
+		}
+		user.StorageQuotaBytes = req.QuotaBytes
+		entry := newAuditEntry(c, model.AuditStorageQuota, model.AuditTargetUser, user.ID, strings.TrimSpace(req.Reason))
+		return model.RecordAudit(tx, entry, before, user.ModerationState())
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update quota", "code": errcode.Internal})
+		return
+	}
+
+	usage, err := loadStorageUsage(&user)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load storage usage", "code": errcode.Internal})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"user_id":      user.ID,
+		"used_bytes":   usage.UsedBytes,
+		"quota_bytes":  usage.QuotaBytes,
+		"custom_quota": user.StorageQuotaBytes != nil,
+	})
+}
+
+// POST /api/admin/storage/gc?dry_run=false
+// 参照のないオブジェクトの回収をすぐに実行する（既定は dry-run で、削除対象の確認のみ）
+func AdminStorageGCHandler(c *gin.Context) {
+	dryRun := true
+	if raw := c.Query("dry_run"); raw != "" {
+		v, err := strconv.ParseBool(raw)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid dry_run", "code": errcode.InvalidPayload})
+			return
+		}
+		dryRun = v
+	}
+
+	report, err := collectStorageGarbage(time.Now(), config.Cfg.StorageGCGrace, dryRun)
+	if errors.Is(err, errStorageGCRunning) {
+		c.JSON(http.StatusConflict, gin.H{"error": "storage gc is already running", "code": errcode.Conflict})
+		return
+	}
+	if err != nil {
+		log.Printf("❌ storage gc failed: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to run storage gc", "code": errcode.Internal})
+		return
+	}
+	log.Printf("ℹ️ storage gc triggered by admin %d (dry_run=%v): orphans=%d deleted=%d", c.GetUint("user_id"), dryRun, report.OrphanCount, report.Deleted)
+	// 確認のみ（dry-run）は変更がないので記録しない
+	if !dryRun {
+		summary := gin.H{
+			"scanned":      report.Scanned,
+			"orphan_count": report.OrphanCount,
+			"orphan_bytes": report.OrphanBytes,
+			"deleted":      report.Deleted,
+		}
+		if err := model.RecordAudit(db.DB, newAuditEntry(c, model.AuditStorageGC, model.AuditTargetStorage, 0, ""), nil, summary); err != nil {
+			log.Printf("⚠️ failed to record audit log for storage gc: %v", err)
+		}
+	}
+	c.JSON(http.StatusOK, report)
+}
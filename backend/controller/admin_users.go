@@ -0,0 +1,183 @@
+package controller
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"chillow/db"
+	"chillow/errcode"
+	"chillow/model"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	userPageSize      = 50
+	userMaxPageSize   = 200
+	userDetailRecent  = 20                  // 詳細に載せる通報・制裁・ログインの件数
+	userMessageWindow = 30 * 24 * time.Hour // 直近の送信数を数える期間
+)
+
+// GET /api/admin/users?q=&role=&banned=&created_since=&created_until=&before_id=&limit=
+// ユーザーを新しい順に検索する。total は before_id を除いた条件に合う件数
+func AdminListUsersHandler(c *gin.Context) {
+	filter, err := parseUserFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "code": errcode.InvalidPayload})
+		return
+	}
+	limit := userPageSize
+	if raw := c.Query("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid limit", "code": errcode.InvalidPayload})
+			return
+		}
+		limit = min(n, userMaxPageSize)
+	}
+
+	var users []model.User
+	if err := filter.Apply(db.DB).Order("id DESC").Limit(limit).Find(&users).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load users", "code": errcode.Internal})
+		return
+	}
+	if err := model.SyncBanStates(users, time.Now()); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load users", "code": errcode.Internal})
+		return
+	}
+	countFilter := filter
+	countFilter.BeforeID = 0
+	var total int64
+	if err := countFilter.Apply(db.DB.Model(&model.User{})).Count(&total).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load users", "code": errcode.Internal})
+		return
+	}
+	var next *uint
+	if len(users) == limit {
+		next = &users[len(users)-1].ID
+	}
+	c.JSON(http.StatusOK, gin.H{"users": users, "total": total, "next_before_id": next})
+}
+
+func parseUserFilter(c *gin.Context) (model.UserFilter, error) {
+	f := model.UserFilter{Query: c.Query("q")}
+	if role := strings.ToLower(strings.TrimSpace(c.Query("role"))); role != "" {
+		if !model.ValidRole(role) {
+			return f, fmt.Errorf("invalid role")
+		}
+		f.Role = role
+	}
+	if raw := c.Query("banned"); raw != "" {
+		banned, err := strconv.ParseBool(raw)
+		if err != nil {
+			return f, fmt.Errorf("invalid banned")
+		}
+		f.Banned = &banned
+	}
+	if raw := c.Query("before_id"); raw != "" {
+		n, err := strconv.ParseUint(raw, 10, 64)
+		if err != nil || n == 0 {
+			return f, fmt.Errorf("invalid before_id")
+		}
+		f.BeforeID = uint(n)
+	}
+	for name, dst := range map[string]**time.Time{"created_since": &f.CreatedSince, "created_until": &f.CreatedUntil} {
+		raw := c.Query(name)
+		if raw == "" {
+			continue
+		}
+		t, err := parseAuditTime(raw)
+		if err != nil {
+			return f, fmt.Errorf("invalid %s (RFC3339 or YYYY-MM-DD)", name)
+		}
+		*dst = &t
+	}
+	return f, nil
+}
+
+// GET /api/admin/users/:id
+// アカウントの状態と、通報・制裁の履歴、友達数、メッセージ量、最近のログインをまとめて返す
+func AdminGetUserHandler(c *gin.Context) {
+	user, ok := loadTargetUser(c)
+	if !ok {
+		return
+	}
+	if _, err := model.CurrentBan(user, time.Now()); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load user detail", "code": errcode.Internal})
+		return
+	}
+	detail, err := loadUserDetail(user)
+	if err != nil {
+		log.Printf("❌ failed to load detail of user %d: %v", user.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load user detail", "code": errcode.Internal})
+		return
+	}
+	c.JSON(http.StatusOK, detail)
+}
+
+type userReports struct {
+	ReceivedCount int64          `json:"received_count"` // このユーザーが通報された件数
+	FiledCount    int64          `json:"filed_count"`    // このユーザーが通報した件数
+	Received      []model.Report `json:"received"`
+	Filed         []model.Report `json:"filed"`
+}
+
+type userDetail struct {
+	User        model.User           `json:"user"`
+	Permissions []string             `json:"permissions"`
+	FriendCount int64                `json:"friend_count"`
+	Messages    model.MessageStats   `json:"messages"`
+	Storage     storageUsage         `json:"storage"`
+	Reports     userReports          `json:"reports"`
+	Sanctions   sanctionSummary      `json:"sanctions"`
+	Sessions    []model.LoginSession `json:"sessions"`
+}
+
+func loadUserDetail(user *model.User) (*userDetail, error) {
+	d := &userDetail{User: *user, Permissions: model.RolePermissions(user.Role)}
+	var err error
+	if d.FriendCount, err = model.CountFriends(user.ID); err != nil {
+		return nil, err
+	}
+	if d.Messages, err = model.LoadMessageStats(user.ID, time.Now().Add(-userMessageWindow)); err != nil {
+		return nil, err
+	}
+	if d.Storage, err = loadStorageUsage(user); err != nil {
+		return nil, err
+	}
+
+	for _, side := range []struct {
+		column string
+		count  *int64
+		list   *[]model.Report
+	}{
+		{"reported_user_id", &d.Reports.ReceivedCount, &d.Reports.Received},
+		{"reporter_id", &d.Reports.FiledCount, &d.Reports.Filed},
+	} {
+		if err := db.DB.Model(&model.Report{}).Where(side.column+" = ?", user.ID).Count(side.count).Error; err != nil {
+			return nil, err
+		}
+		var reports []model.Report
+		err := db.DB.Preload("Reporter").Preload("ReportedUser").Preload("HandledByUser").
+			Where(side.column+" = ?", user.ID).Order("id DESC").Limit(userDetailRecent).Find(&reports).Error
+		if err != nil {
+			return nil, err
+		}
+		for i := range reports {
+			reports[i] = reports[i].WithAccessURL()
+		}
+		*side.list = reports
+	}
+
+	if d.Sanctions, err = loadSanctionSummary(user.ID, userDetailRecent); err != nil {
+		return nil, err
+	}
+	if err := db.DB.Where("user_id = ?", user.ID).Order("id DESC").Limit(userDetailRecent).Find(&d.Sessions).Error; err != nil {
+		return nil, err
+	}
+	return d, nil
+}
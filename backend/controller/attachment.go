@@ -0,0 +1,522 @@
+package controller
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"chillow/config"
+	"chillow/db"
+	"chillow/errcode"
+	"chillow/media"
+	"chillow/model"
+	"chillow/storage"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// POST /api/messages/media/presign
+// 添付をストレージへ直接アップロードするための署名付き URL を発行する
+func PresignMessageMediaHandler(c *gin.Context) {
+	userID := c.GetUint("user_id")
+
+	var req struct {
+		Kind        string `json:"kind"`
+		Filename    string `json:"filename"`
+		ContentType string `json:"content_type"`
+		Size        int64  `json:"size"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || req.Filename == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "filename, content_type and size are required", "code": errcode.InvalidPayload})
+		return
+	}
+	kind, ok := parseAttachmentKind(req.Kind)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "unsupported attachment kind", "code": errcode.InvalidPayload})
+		return
+	}
+	if req.Size <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "size must be positive", "code": errcode.InvalidPayload})
+		return
+	}
+	if req.Size > maxAttachmentBytes(kind) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "file is too large", "code": errcode.FileTooLarge})
+		return
+	}
+	if !allowedAttachmentName(kind, req.Filename) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "unsupported file type", "code": errcode.UnsupportedFile})
+		return
+	}
+	if !checkStorageQuota(c, userID, req.Size) {
+		return
+	}
+
+	// 画像は拡張子と Content-Type の一致を求める。それ以外は確認時に中身で判定し直す
+	contentType := strings.ToLower(strings.TrimSpace(req.ContentType))
+	if kind == model.AttachmentKindImage {
+		expected := allowedAttachmentExtensions[strings.ToLower(filepath.Ext(req.Filename))]
+		if contentType != expected {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "unsupported file type", "code": errcode.UnsupportedFile})
+			return
+		}
+	} else if _, _, err := mime.ParseMediaType(contentType); err != nil || len(contentType) > 100 {
+		contentType = "application/octet-stream"
+	}
+
+	key := storage.ChatObjectKey(userID, req.Filename)
+	target, err := storage.Default().PresignUpload(key, contentType, req.Size, config.Cfg.AttachmentUploadURLTTL)
+	if err != nil {
+		log.Printf("❌ failed to presign upload: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to prepare upload", "code": errcode.Internal})
+		return
+	}
+
+	att := model.Attachment{
+		OwnerID:     userID,
+		ObjectKey:   key,
+		Kind:        kind,
+		Filename:    sanitizeFilename(req.Filename),
+		ContentType: contentType,
+		Size:        req.Size,
+		Status:      model.AttachmentPending,
+		ExpiresAt:   &target.ExpiresAt,
+	}
+	if err := db.DB.Create(&att).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to prepare upload", "code": errcode.Internal})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"attachment_id": att.ID,
+		"objectKey":     key,
+		"upload":        target,
+	})
+}
+
+// POST /api/messages/media/preflight
+// アップロード前に SHA-256 を送り、本人が以前に同じ中身をアップロードしていれば送信を省いて添付を発行する
+func PreflightMessageMediaHandler(c *gin.Context) {
+	userID := c.GetUint("user_id")
+
+	var req struct {
+		Kind     string `json:"kind"`
+		Filename string `json:"filename"`
+		SHA256   string `json:"sha256"`
+		Size     int64  `json:"size"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || req.Filename == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "filename, sha256 and size are required", "code": errcode.InvalidPayload})
+		return
+	}
+	kind, ok := parseAttachmentKind(req.Kind)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "unsupported attachment kind", "code": errcode.InvalidPayload})
+		return
+	}
+	hash := strings.ToLower(req.SHA256)
+	if !storage.ValidContentHash(hash) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "sha256 must be a hex digest", "code": errcode.InvalidPayload})
+		return
+	}
+	if req.Size <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "size must be positive", "code": errcode.InvalidPayload})
+		return
+	}
+	if req.Size > maxAttachmentBytes(kind) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "file is too large", "code": errcode.FileTooLarge})
+		return
+	}
+	if !allowedAttachmentName(kind, req.Filename) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "unsupported file type", "code": errcode.UnsupportedFile})
+		return
+	}
+
+	prev, err := model.FindOwnedContent(userID, hash, kind)
+	if err != nil {
+		log.Printf("❌ failed to look up content %s: %v", hash, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to check upload", "code": errcode.Internal})
+		return
+	}
+	if prev == nil {
+		c.JSON(http.StatusOK, gin.H{"found": false})
+		return
+	}
+	if !checkStorageQuota(c, userID, prev.Size) {
+		return
+	}
+
+	expiresAt := time.Now().Add(config.Cfg.AttachmentClaimTTL)
+	att := model.Attachment{
+		OwnerID:     userID,
+		Kind:        kind,
+		Filename:    sanitizeFilename(req.Filename),
+		Status:      model.AttachmentReady,
+		ObjectKey:   prev.ObjectKey,
+		ContentHash: prev.ContentHash,
+		SourceHash:  hash,
+		ExpiresAt:   &expiresAt,
+	}
+	if kind == model.AttachmentKindFile {
+		// ファイルは拡張子ごとに別のオブジェクトにしている
+		att.ObjectKey = storage.ContentObjectKey(prev.ContentHash, kind, strings.ToLower(filepath.Ext(req.Filename)))
+	}
+	err = db.DB.Transaction(func(tx *gorm.DB) error {
+		if err := model.AcquireStoredContent(tx, &att); err != nil {
+			return err
+		}
+		return tx.Create(&att).Error
+	})
+	if errors.Is(err, model.ErrContentGone) {
+		// 確認の間に最後の参照が外れて削除された
+		c.JSON(http.StatusOK, gin.H{"found": false})
+		return
+	}
+	if err != nil {
+		log.Printf("❌ failed to reuse content %s: %v", hash, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to check upload", "code": errcode.Internal})
+		return
+	}
+
+	url, err := storage.Default().SignedURL(att.ObjectKey, config.Cfg.AttachmentURLTTL)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to check upload", "code": errcode.Internal})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"found":         true,
+		"attachment_id": att.ID,
+		"kind":          att.Kind,
+		"url":           url,
+		"objectKey":     att.ObjectKey,
+		"deduplicated":  true,
+	})
+}
+
+// POST /api/messages/media/confirm
+// 直接アップロードの完了をストレージ上で確認し、メッセージから参照できる状態にする
+func ConfirmMessageMediaHandler(c *gin.Context) {
+	userID := c.GetUint("user_id")
+
+	var req struct {
+		AttachmentID uint `json:"attachment_id"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || req.AttachmentID == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "attachment_id is required", "code": errcode.InvalidPayload})
+		return
+	}
+
+	var att model.Attachment
+	if err := db.DB.First(&att, req.AttachmentID).Error; err != nil || att.OwnerID != userID {
+		c.JSON(http.StatusNotFound, gin.H{"error": "attachment not found", "code": errcode.NotFound})
+		return
+	}
+
+	if att.Status != model.AttachmentReady {
+		info, err := storage.Default().Head(att.ObjectKey)
+		if errors.Is(err, storage.ErrNotFound) {
+			c.JSON(http.StatusConflict, gin.H{"error": "upload not found", "code": errcode.UploadIncomplete})
+			return
+		}
+		if err != nil {
+			log.Printf("❌ failed to check uploaded object %s: %v", att.ObjectKey, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to confirm upload", "code": errcode.Internal})
+			return
+		}
+		if info.Size != att.Size {
+			c.JSON(http.StatusConflict, gin.H{"error": "uploaded size does not match", "code": errcode.UploadIncomplete})
+			return
+		}
+		// 発行後に別のアップロードで上限に達している場合がある
+		if !checkStorageQuota(c, userID, att.Size) {
+			discardAttachment(&att)
+			return
+		}
+
+		// 中身を確認（画像は無害化）し、署名付き URL で上書きできない別のキーへ保存し直す
+		data, err := readObject(att.ObjectKey, maxAttachmentBytes(att.Kind))
+		if err != nil {
+			log.Printf("❌ failed to read uploaded object %s: %v", att.ObjectKey, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to confirm upload", "code": errcode.Internal})
+			return
+		}
+		upload, err := inspectUpload(att.Kind, att.Filename, data, config.Cfg.ImageMaxPixels)
+		if err != nil {
+			discardAttachment(&att)
+			respondUploadRejected(c, err)
+			return
+		}
+		// 検査を通るまでは pending のまま（メッセージに使えない）隔離しておく。検査できなかった場合は再度の確認を待つ
+		if err := scanAttachment(userID, att.Kind, att.Filename, bytes.NewReader(data)); err != nil {
+			if errors.Is(err, errInfected) {
+				discardAttachment(&att)
+			}
+			respondScanRejected(c, err)
+			return
+		}
+		// 同じ中身が保存済みなら参照だけ増やす。確認後はメッセージに使われるまでの猶予を与える
+		uploadedKey := att.ObjectKey
+		expiresAt := time.Now().Add(config.Cfg.AttachmentClaimTTL)
+		att.ContentHash = storage.HashContent(upload.Data)
+		att.SourceHash = storage.HashContent(data)
+		att.ObjectKey = storage.ContentObjectKey(att.ContentHash, att.Kind, upload.Ext)
+		att.ContentType = upload.ContentType
+		att.Size = upload.Size
+		att.DurationMs = upload.DurationMs
+		att.Status = model.AttachmentReady
+		att.ExpiresAt = &expiresAt
+		contentKey := att.ObjectKey
+		reused, err := saveContentAttachment(&att, func(key string) error {
+			return storage.Default().Put(key, upload.Data, upload.ContentType)
+		}, func(tx *gorm.DB) error {
+			return tx.Model(&att).Updates(map[string]interface{}{
+				"object_key":   att.ObjectKey,
+				"content_type": att.ContentType,
+				"size":         att.Size,
+				"width":        att.Width,
+				"height":       att.Height,
+				"blurhash":     att.Blurhash,
+				"variants":     att.Variants,
+				"duration_ms":  att.DurationMs,
+				"content_hash": att.ContentHash,
+				"source_hash":  att.SourceHash,
+				"status":       att.Status,
+				"expires_at":   expiresAt,
+			}).Error
+		})
+		if err != nil {
+			log.Printf("❌ failed to store confirmed upload %s: %v", contentKey, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to confirm upload", "code": errcode.Internal})
+			return
+		}
+		if err := storage.Default().Delete(uploadedKey); err != nil {
+			log.Printf("⚠️ failed to delete raw upload %s: %v", uploadedKey, err)
+		}
+
+		if att.Kind == model.AttachmentKindImage && !reused {
+			generateImageVariants(&att, upload.Data)
+		}
+	}
+
+	// /messages/media と同じ形で返す（url はプレビュー用の期限付き URL）
+	url, err := storage.Default().SignedURL(att.ObjectKey, config.Cfg.AttachmentURLTTL)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to confirm upload", "code": errcode.Internal})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"attachment_id": att.ID,
+		"kind":          att.Kind,
+		"url":           url,
+		"objectKey":     att.ObjectKey,
+	})
+}
+
+// PUT /api/uploads?token=...
+// ローカル保存時の署名付きアップロード先（S3 の署名付き PUT と同等）
+func LocalSignedUploadHandler(c *gin.Context) {
+	receiver, ok := storage.Default().(storage.SignedUploadReceiver)
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "not found", "code": errcode.NotFound})
+		return
+	}
+
+	err := receiver.ReceiveSignedUpload(
+		c.Query("token"),
+		c.GetHeader("Content-Type"),
+		c.Request.ContentLength,
+		http.MaxBytesReader(c.Writer, c.Request.Body, maxUploadSizeBytes+1),
+	)
+	switch {
+	case err == nil:
+		c.Status(http.StatusOK)
+	case errors.Is(err, storage.ErrInvalidSignature), errors.Is(err, storage.ErrSignatureExpired):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error(), "code": errcode.Forbidden})
+	case errors.Is(err, storage.ErrUploadMismatch):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "code": errcode.UploadIncomplete})
+	default:
+		log.Printf("❌ failed to store signed upload: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to save file", "code": errcode.Internal})
+	}
+}
+
+// GET /api/messages/attachments/:id
+// 会話の参加者のみ、期限付きの署名付き URL へリダイレクトする
+func MessageAttachmentHandler(c *gin.Context) {
+	userID := c.GetUint("user_id")
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil || id <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id", "code": errcode.InvalidPayload})
+		return
+	}
+
+	var msg model.Message
+	if err := db.DB.First(&msg, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "attachment not found", "code": errcode.NotFound})
+		return
+	}
+	if msg.SenderID != userID && msg.ReceiverID != userID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Not allowed", "code": errcode.Forbidden})
+		return
+	}
+	if msg.IsDeleted || msg.AttachmentObj == nil || *msg.AttachmentObj == "" {
+		c.JSON(http.StatusNotFound, gin.H{"error": "attachment not found", "code": errcode.NotFound})
+		return
+	}
+
+	// ?variant=thumb|medium は生成済みの場合のみ。なければ元画像を返す
+	key := *msg.AttachmentObj
+	if variant := c.Query("variant"); variant != "" && msg.HasVariant(variant) {
+		key = storage.VariantKey(key, variant)
+	}
+	// ファイルは元のファイル名でダウンロードさせる
+	filename := ""
+	if msg.MessageType == model.AttachmentKindFile && msg.AttachmentName != nil {
+		filename = *msg.AttachmentName
+	}
+	redirectToSignedURL(c, key, filename)
+}
+
+// redirectToSignedURL は filename を指定するとダウンロード（Content-Disposition: attachment）として返す
+func redirectToSignedURL(c *gin.Context, objectKey, filename string) {
+	url, err := storage.Default().SignedDownloadURL(objectKey, filename, config.Cfg.AttachmentURLTTL)
+	if err != nil {
+		log.Printf("❌ failed to sign attachment url %s: %v", objectKey, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to open attachment", "code": errcode.Internal})
+		return
+	}
+	// 署名付き URL は利用者ごとに発行するので共有キャッシュさせない
+	c.Header("Cache-Control", "private, max-age=60")
+	c.Redirect(http.StatusFound, url)
+}
+
+// GET /uploads/*filepath?exp=...&sig=...
+// ローカル保存時の署名付きダウンロード（S3 の署名付き GET と同等）
+func ServeUploadHandler(c *gin.Context) {
+	server, ok := storage.Default().(storage.SignedFileServer)
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "not found", "code": errcode.NotFound})
+		return
+	}
+
+	key := strings.TrimPrefix(c.Param("filepath"), "/")
+	path, err := server.OpenSigned(key, c.Query("exp"), c.Query("dl"), c.Query("sig"))
+	switch {
+	case err == nil:
+		c.Header("Cache-Control", "private, max-age=60")
+		if dl := c.Query("dl"); dl != "" {
+			c.Header("Content-Disposition", storage.ContentDisposition(dl))
+		}
+		c.File(path)
+	case errors.Is(err, storage.ErrInvalidSignature), errors.Is(err, storage.ErrSignatureExpired):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error(), "code": errcode.Forbidden})
+	case errors.Is(err, storage.ErrNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "not found", "code": errcode.NotFound})
+	default:
+		log.Printf("❌ failed to open upload %s: %v", key, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to open file", "code": errcode.Internal})
+	}
+}
+
+// readObject はアップロード上限まで読み込む（それ以上は途中で打ち切る）
+func readObject(objectKey string, limit int64) ([]byte, error) {
+	src, err := storage.Default().Get(objectKey)
+	if err != nil {
+		return nil, err
+	}
+	defer src.Close()
+	return io.ReadAll(io.LimitReader(src, limit+1))
+}
+
+// saveContentAttachment は同じ中身が保存済みなら参照を増やすだけにし、なければ put で att.ObjectKey に保存してから登録する。
+// save は同じトランザクションで添付レコードを作成・更新する。保存済みの中身を使った場合は true を返す。
+func saveContentAttachment(att *model.Attachment, put func(key string) error, save func(tx *gorm.DB) error) (bool, error) {
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		if err := model.AcquireStoredContent(tx, att); err != nil {
+			return err
+		}
+		return save(tx)
+	})
+	if err == nil {
+		return true, nil
+	}
+	if !errors.Is(err, model.ErrContentGone) {
+		return false, err
+	}
+
+	// 転送の間は行ロックもトランザクションも持たないよう、先に保存してから短いトランザクションで登録する
+	if err := put(att.ObjectKey); err != nil {
+		return false, err
+	}
+	err = db.DB.Transaction(func(tx *gorm.DB) error {
+		first, err := model.RegisterContent(tx, att)
+		if err != nil {
+			return err
+		}
+		// 保存から登録までの間に、参照 0 になった同じキーのオブジェクトとして削除された場合は保存し直す
+		if first {
+			if _, err := storage.Default().Head(att.ObjectKey); errors.Is(err, storage.ErrNotFound) {
+				if err := put(att.ObjectKey); err != nil {
+					return err
+				}
+			} else if err != nil {
+				return err
+			}
+		}
+		return save(tx)
+	})
+	if err != nil {
+		// 削除できなければ storage gc が回収する
+		model.DiscardUnregisteredContent(att.ObjectKey)
+		return false, err
+	}
+	return false, nil
+}
+
+// discardAttachment は受け付けられなかったアップロードを記録ごと削除する
+func discardAttachment(att *model.Attachment) {
+	if err := storage.Default().Delete(att.ObjectKey); err != nil {
+		log.Printf("⚠️ failed to delete rejected upload %s: %v", att.ObjectKey, err)
+	}
+	if err := db.DB.Delete(att).Error; err != nil {
+		log.Printf("⚠️ failed to delete attachment record %d: %v", att.ID, err)
+	}
+}
+
+// generateImageVariants は縮小版とプレースホルダーを生成して記録する（失敗しても添付自体は使える）
+func generateImageVariants(att *model.Attachment, data []byte) {
+	processed, err := media.ProcessImage(data)
+	if err != nil {
+		log.Printf("⚠️ failed to process image %s: %v", att.ObjectKey, err)
+		return
+	}
+
+	var names []string
+	for _, v := range processed.Variants {
+		if err := storage.Default().Put(storage.VariantKey(att.ObjectKey, v.Name), v.Data, "image/jpeg"); err != nil {
+			log.Printf("⚠️ failed to store %s variant of %s: %v", v.Name, att.ObjectKey, err)
+			continue
+		}
+		names = append(names, v.Name)
+	}
+
+	att.Width = processed.Width
+	att.Height = processed.Height
+	att.Blurhash = processed.Blurhash
+	att.Variants = strings.Join(names, ",")
+	if err := db.DB.Model(att).Updates(map[string]interface{}{
+		"width":    att.Width,
+		"height":   att.Height,
+		"blurhash": att.Blurhash,
+		"variants": att.Variants,
+	}).Error; err != nil {
+		log.Printf("⚠️ failed to record image metadata for %s: %v", att.ObjectKey, err)
+	}
+}
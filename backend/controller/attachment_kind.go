@@ -0,0 +1,172 @@
+package controller
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"unicode"
+
+	"chillow/errcode"
+	"chillow/media"
+	"chillow/model"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	maxFileSizeBytes  = 25 * 1024 * 1024 // 25MB
+	maxAudioSizeBytes = 10 * 1024 * 1024 // 10MB
+	// 直接アップロードの受け口で許容する最大サイズ（種類ごとの上限の最大値）
+	maxUploadSizeBytes = maxFileSizeBytes
+
+	maxFilenameLength = 255
+)
+
+// 音声の拡張子（録音アプリやブラウザの MediaRecorder が出力するもの）
+var allowedAudioExtensions = map[string]bool{
+	".mp3":  true,
+	".m4a":  true,
+	".mp4":  true,
+	".wav":  true,
+	".ogg":  true,
+	".oga":  true,
+	".opus": true,
+	".webm": true,
+}
+
+// 汎用ファイルでも受け付けない拡張子（実行・スクリプト・ブラウザで動作するもの）
+var deniedFileExtensions = map[string]bool{
+	".exe": true, ".com": true, ".bat": true, ".cmd": true, ".scr": true, ".msi": true,
+	".dll": true, ".ps1": true, ".vbs": true, ".js": true, ".jar": true, ".apk": true,
+	".sh": true, ".hta": true, ".lnk": true, ".reg": true, ".html": true, ".htm": true,
+	".svg": true, ".xhtml": true,
+}
+
+// isAttachmentMessageType は添付を伴うメッセージ種別か（本文は任意のキャプション）
+func isAttachmentMessageType(messageType string) bool {
+	switch messageType {
+	case model.AttachmentKindImage, model.AttachmentKindFile, model.AttachmentKindAudio:
+		return true
+	}
+	return false
+}
+
+// parseAttachmentKind はリクエストの kind を解釈する（省略時は画像）
+func parseAttachmentKind(raw string) (string, bool) {
+	switch strings.ToLower(strings.TrimSpace(raw)) {
+	case "", model.AttachmentKindImage:
+		return model.AttachmentKindImage, true
+	case model.AttachmentKindFile:
+		return model.AttachmentKindFile, true
+	case model.AttachmentKindAudio:
+		return model.AttachmentKindAudio, true
+	}
+	return "", false
+}
+
+func maxAttachmentBytes(kind string) int64 {
+	switch kind {
+	case model.AttachmentKindFile:
+		return maxFileSizeBytes
+	case model.AttachmentKindAudio:
+		return maxAudioSizeBytes
+	}
+	return maxAttachmentSizeBytes
+}
+
+// allowedAttachmentName は拡張子で種類ごとの許可・拒否リストを確認する（中身の確認は inspectUpload）
+func allowedAttachmentName(kind, filename string) bool {
+	ext := strings.ToLower(filepath.Ext(filename))
+	switch kind {
+	case model.AttachmentKindImage:
+		_, ok := allowedAttachmentExtensions[ext]
+		return ok
+	case model.AttachmentKindAudio:
+		return allowedAudioExtensions[ext]
+	case model.AttachmentKindFile:
+		return !deniedFileExtensions[ext]
+	}
+	return false
+}
+
+// sanitizeFilename はダウンロード時に使う元のファイル名からパスや制御文字を取り除く
+func sanitizeFilename(name string) string {
+	name = filepath.Base(strings.ReplaceAll(name, "\\", "/"))
+	name = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, name)
+	name = strings.TrimSpace(name)
+	if name == "" || name == "." || name == "/" {
+		return "file"
+	}
+	if runes := []rune(name); len(runes) > maxFilenameLength {
+		ext := []rune(filepath.Ext(name))
+		if len(ext) > 16 {
+			ext = nil
+		}
+		name = string(runes[:maxFilenameLength-len(ext)]) + string(ext)
+	}
+	return name
+}
+
+type inspectedUpload struct {
+	Data        []byte
+	Size        int64  // 保存するバイト数（汎用ファイルは Data を持たずに流す）
+	ContentType string // 中身から判定した MIME
+	Ext         string // 保存時の拡張子
+	DurationMs  int64
+}
+
+// inspectUpload は中身で形式を確認し、画像はメタデータを取り除く
+func inspectUpload(kind, filename string, data []byte, maxPixels int) (*inspectedUpload, error) {
+	switch kind {
+	case model.AttachmentKindImage:
+		img, err := media.SanitizeImage(data, maxPixels)
+		if err != nil {
+			return nil, err
+		}
+		return &inspectedUpload{Data: img.Data, Size: int64(len(img.Data)), ContentType: img.ContentType, Ext: img.Ext}, nil
+	case model.AttachmentKindAudio:
+		audio, err := media.ProbeAudio(data)
+		if err != nil {
+			return nil, err
+		}
+		return &inspectedUpload{Data: data, Size: int64(len(data)), ContentType: audio.ContentType, Ext: audio.Ext, DurationMs: audio.Duration.Milliseconds()}, nil
+	default:
+		contentType, err := media.InspectFile(data)
+		if err != nil {
+			return nil, err
+		}
+		return &inspectedUpload{Data: data, Size: int64(len(data)), ContentType: contentType, Ext: strings.ToLower(filepath.Ext(filename))}, nil
+	}
+}
+
+// inspectFileHeader は汎用ファイルの先頭だけを確認し、src を読み始めの位置に戻す（Data と Size は空のまま）
+func inspectFileHeader(src io.ReadSeeker, filename string) (*inspectedUpload, error) {
+	head := make([]byte, 512)
+	n, err := io.ReadFull(src, head)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	contentType, err := media.InspectFile(head[:n])
+	if err != nil {
+		return nil, err
+	}
+	if _, err := src.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	return &inspectedUpload{ContentType: contentType, Ext: strings.ToLower(filepath.Ext(filename))}, nil
+}
+
+func respondUploadRejected(c *gin.Context, err error) {
+	if errors.Is(err, media.ErrTooManyPixels) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "image dimensions are too large", "code": errcode.ImageTooLarge})
+		return
+	}
+	c.JSON(http.StatusBadRequest, gin.H{"error": "unsupported file type", "code": errcode.UnsupportedFile})
+}
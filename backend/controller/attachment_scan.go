@@ -0,0 +1,77 @@
+package controller
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"chillow/adminstream"
+	"chillow/db"
+	"chillow/errcode"
+	"chillow/model"
+	"chillow/scan"
+
+	"github.com/gin-gonic/gin"
+)
+
+// errInfected はウイルス検査で検出されたことを表す
+var errInfected = errors.New("infected upload")
+
+// scanAttachment は保存前の中身をウイルス検査する。検出した場合は管理画面へ通知して errInfected を返す。
+// 検査できなかった場合（scan.ErrUnavailable）も受け付けない。
+func scanAttachment(userID uint, kind, filename string, r io.Reader) error {
+	if !scan.Enabled() {
+		return nil
+	}
+	hasher := sha256.New()
+	counter := &countingWriter{}
+	res, err := scan.Default().Scan(io.TeeReader(r, io.MultiWriter(hasher, counter)))
+	if err != nil {
+		log.Printf("❌ failed to scan upload from user %d: %v", userID, err)
+		return err
+	}
+	if !res.Infected {
+		return nil
+	}
+
+	detection := adminstream.Detection{
+		UserID:     userID,
+		Kind:       kind,
+		Filename:   sanitizeFilename(filename),
+		Size:       counter.n,
+		SHA256:     hex.EncodeToString(hasher.Sum(nil)),
+		Signature:  res.Signature,
+		Scanner:    scan.Default().Name(),
+		DetectedAt: time.Now(),
+	}
+	log.Printf("⚠️ rejected infected upload from user %d: %s (%s, sha256=%s)", userID, res.Signature, detection.Filename, detection.SHA256)
+	evt := adminstream.Event{Type: "attachment:infected", Detection: &detection}
+	var user model.User
+	if err := db.DB.First(&user, userID).Error; err == nil {
+		evt.User = &user
+	}
+	adminstream.Broadcast(evt)
+	return errInfected
+}
+
+func respondScanRejected(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, errInfected):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "file was rejected by virus scan", "code": errcode.MalwareDetected})
+	case errors.Is(err, scan.ErrUnavailable):
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "virus scan is unavailable, try again later", "code": errcode.ScanUnavailable})
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "failed to read file", "code": errcode.InvalidPayload})
+	}
+}
+
+type countingWriter struct{ n int64 }
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.n += int64(len(p))
+	return len(p), nil
+}
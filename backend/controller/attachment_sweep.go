@@ -0,0 +1,57 @@
+package controller
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"chillow/model"
+)
+
+const attachmentSweepBatchSize = 200
+
+// RunAttachmentSweeper はメッセージに使われないまま期限を過ぎた添付を定期的に削除する（ctx 終了で停止）
+func RunAttachmentSweeper(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if n, err := sweepUnclaimedAttachments(time.Now()); err != nil {
+				log.Printf("⚠️ attachment sweep failed: %v", err)
+			} else if n > 0 {
+				log.Printf("🧹 attachment sweep removed %d unused uploads", n)
+			}
+		}
+	}
+}
+
+func sweepUnclaimedAttachments(now time.Time) (int, error) {
+	total := 0
+	for {
+		expired, err := model.ExpiredAttachments(now, attachmentSweepBatchSize)
+		if err != nil {
+			return total, err
+		}
+		if len(expired) == 0 {
+			return total, nil
+		}
+
+		for _, att := range expired {
+			// 先に記録を消し、削除と同時にメッセージから使われた場合はスキップする
+			released, err := model.ReleaseUnusedAttachment(&att)
+			if err != nil {
+				return total, err
+			}
+			if released {
+				total++
+			}
+		}
+
+		if len(expired) < attachmentSweepBatchSize {
+			return total, nil
+		}
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"net/http"
 
 	"chillow/config"
+	"chillow/errcode"
 	"chillow/model"
 	authsvc "chillow/service/auth"
 
@@ -19,7 +20,7 @@ func GoogleLoginHandler(c *gin.Context) {
 		IDToken string `json:"id_token"`
 	}
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "無効なリクエストです"})
+		c.JSON(http.StatusBadRequest, gin.H{"error": "無効なリクエストです", "code": errcode.InvalidPayload})
 		return
 	}
 
@@ -27,7 +28,7 @@ func GoogleLoginHandler(c *gin.Context) {
 	payload, err := idtoken.Validate(context.Background(), req.IDToken, config.Cfg.GoogleClientID)
 	if err != nil {
 		log.Printf("❌ IDトークン検証失敗: %v", err)
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "無効なトークンIDです"})
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "無効なトークンIDです", "code": errcode.Unauthorized})
 		return
 	}
 
@@ -39,7 +40,7 @@ func GoogleLoginHandler(c *gin.Context) {
 	// ユーザーを検索または作成
 	user, err := model.FindOrCreateUserByEmail(email, name, picture)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "DB処理に失敗しました"})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "DB処理に失敗しました", "code": errcode.Internal})
 		return
 	}
 
@@ -48,11 +49,16 @@ func GoogleLoginHandler(c *gin.Context) {
 	// アクセストークン発行＆Cookieに設定
 	token, expiresAt, err := authsvc.GenerateAccessToken(user)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "トークン生成に失敗しました"})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "トークン生成に失敗しました", "code": errcode.Internal})
 		return
 	}
 	authsvc.SetAuthCookie(c, token, expiresAt)
 
+	// ログイン履歴（失敗してもログインは続ける）
+	if err := model.RecordLoginSession(user.ID, c.ClientIP(), c.Request.UserAgent()); err != nil {
+		log.Printf("⚠️ failed to record login session for user %d: %v", user.ID, err)
+	}
+
 	c.JSON(http.StatusOK, gin.H{"user": user})
 }
 
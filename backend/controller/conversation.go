@@ -0,0 +1,135 @@
+package controller
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"chillow/db"
+	"chillow/errcode"
+	"chillow/model"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// 選択可能な消えるメッセージの保持期間（秒）と表示ラベル
+var retentionOptions = map[int64]string{
+	0:      "オフ",
+	3600:   "1時間",
+	86400:  "1日",
+	604800: "1週間",
+}
+
+// GET /api/conversations/:friend_id/retention
+func GetConversationRetentionHandler(c *gin.Context) {
+	userID := c.GetUint("user_id")
+	friendID, ok := parseConversationFriend(c, userID)
+	if !ok {
+		return
+	}
+
+	setting, err := model.FindConversationSetting(userID, friendID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load conversation setting", "code": errcode.Internal})
+		return
+	}
+	c.JSON(http.StatusOK, setting)
+}
+
+// PUT /api/conversations/:friend_id/retention
+// どちらの参加者でも変更可能。変更内容はシステムメッセージとして会話に通知する。
+func UpdateConversationRetentionHandler(c *gin.Context) {
+	userID := c.GetUint("user_id")
+	friendID, ok := parseConversationFriend(c, userID)
+	if !ok {
+		return
+	}
+
+	var body struct {
+		RetentionSeconds *int64 `json:"retention_seconds"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil || body.RetentionSeconds == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "retention_seconds is required", "code": errcode.InvalidPayload})
+		return
+	}
+	label, allowed := retentionOptions[*body.RetentionSeconds]
+	if !allowed {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "unsupported retention_seconds", "code": errcode.InvalidPayload})
+		return
+	}
+
+	current, err := model.FindConversationSetting(userID, friendID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load conversation setting", "code": errcode.Internal})
+		return
+	}
+	if current.ID != 0 && current.RetentionSeconds == *body.RetentionSeconds {
+		c.JSON(http.StatusOK, current)
+		return
+	}
+
+	now := time.Now()
+	setting := model.ConversationSetting{
+		UserLowID:        current.UserLowID,
+		UserHighID:       current.UserHighID,
+		RetentionSeconds: *body.RetentionSeconds,
+		UpdatedBy:        userID,
+	}
+	content := fmt.Sprintf("消えるメッセージを「%s」に設定しました", label)
+	if setting.RetentionSeconds == 0 {
+		content = "消えるメッセージをオフにしました"
+	}
+	announcement := model.Message{
+		SenderID:    userID,
+		ReceiverID:  friendID,
+		Content:     content,
+		MessageType: "system",
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+
+	if err := db.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "user_low_id"}, {Name: "user_high_id"}},
+			DoUpdates: clause.AssignmentColumns([]string{"retention_seconds", "updated_by", "updated_at"}),
+		}).Create(&setting).Error; err != nil {
+			return err
+		}
+		return tx.Create(&announcement).Error
+	}); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update conversation setting", "code": errcode.Internal})
+		return
+	}
+
+	recordReadReceipts(userID, []uint{announcement.ID})
+	broadcastMessageEvent("message:new", announcement)
+
+	setting, err = model.FindConversationSetting(userID, friendID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load conversation setting", "code": errcode.Internal})
+		return
+	}
+	c.JSON(http.StatusOK, setting)
+}
+
+func parseConversationFriend(c *gin.Context, userID uint) (uint, bool) {
+	id, err := strconv.Atoi(c.Param("friend_id"))
+	if err != nil || id <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid friend id", "code": errcode.InvalidPayload})
+		return 0, false
+	}
+	friendID := uint(id)
+	isFriend, err := model.AreFriends(userID, friendID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to check friendship", "code": errcode.Internal})
+		return 0, false
+	}
+	if !isFriend {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Not allowed", "code": errcode.Forbidden})
+		return 0, false
+	}
+	return friendID, true
+}
@@ -9,6 +9,7 @@ import (
 	"time"
 
 	"chillow/db"
+	"chillow/errcode"
 	"chillow/model"
 	"chillow/storage"
 	"chillow/ws"
@@ -27,12 +28,19 @@ type FriendRow struct {
 	LastMessageContent    *string    `json:"last_message_content"`
 	LastMessageType       *string    `json:"last_message_type"`
 	LastMessageAttachment *string    `json:"last_message_attachment_url"`
+	LastMessageVariants   *string    `json:"-"`
+	LastMessageName       *string    `json:"last_message_attachment_name"`
+	LastMessageDurationMs *int64     `json:"last_message_attachment_duration_ms"`
 	LastMessageAt         *time.Time `json:"last_message_at"`
 	LastMessageEditedAt   *time.Time `json:"last_message_edited_at"`
 	LastMessageIsDeleted  *bool      `json:"last_message_is_deleted"`
 	LastMessageIsOwn      *bool      `json:"last_message_is_own"`
 	LastMessageSenderID   *uint      `json:"last_message_sender_id"`
 	UnreadCount           int64      `json:"unread_count"`
+	IsOnline              bool       `json:"is_online"` // online または away
+	Presence              string     `json:"presence"`  // online / away / offline
+	LastSeenAt            *time.Time `json:"last_seen_at"`
+	HidePresence          bool       `json:"-"`
 }
 
 // POST /api/friend-requests
@@ -43,13 +51,13 @@ func SendFriendRequestHandler(c *gin.Context) {
 		ReceiverID uint `json:"receiver_id"`
 	}
 	if err := c.ShouldBindJSON(&body); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body", "code": errcode.InvalidPayload})
 		return
 	}
 
 	// 自分自身へは不可
 	if requesterID == body.ReceiverID {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Cannot send request to yourself"})
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Cannot send request to yourself", "code": errcode.NotAllowed})
 		return
 	}
 
@@ -57,41 +65,41 @@ func SendFriendRequestHandler(c *gin.Context) {
 	var receiver model.User
 	if err := db.DB.First(&receiver, body.ReceiverID).Error; err != nil {
 		if err == gorm.ErrRecordNotFound {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Receiver not found"})
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Receiver not found", "code": errcode.NotFound})
 			return
 		}
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error (find receiver)"})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error (find receiver)", "code": errcode.Internal})
 		return
 	}
-	if receiver.Role == "admin" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Cannot send request to this user"})
+	if model.IsStaffRole(receiver.Role) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Cannot send request to this user", "code": errcode.NotAllowed})
 		return
 	}
 
 	// すでにフレンドか（accepted 相当は friends で判定）
 	isFriend, err := model.AreFriends(requesterID, body.ReceiverID)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error (check friend)"})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error (check friend)", "code": errcode.Internal})
 		return
 	}
 	if isFriend {
-		c.JSON(http.StatusConflict, gin.H{"error": "Already friends"})
+		c.JSON(http.StatusConflict, gin.H{"error": "Already friends", "code": errcode.Conflict})
 		return
 	}
 
 	// 双方向どちらかに pending があれば新規申請不可
 	if ok, err := model.PendingRequestExists(requesterID, body.ReceiverID); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error (check pending)"})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error (check pending)", "code": errcode.Internal})
 		return
 	} else if ok {
-		c.JSON(http.StatusConflict, gin.H{"error": "Request already sent"})
+		c.JSON(http.StatusConflict, gin.H{"error": "Request already sent", "code": errcode.Conflict})
 		return
 	}
 	if ok, err := model.PendingRequestExists(body.ReceiverID, requesterID); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error (check reverse pending)"})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error (check reverse pending)", "code": errcode.Internal})
 		return
 	} else if ok {
-		c.JSON(http.StatusConflict, gin.H{"error": "Incoming request already exists"})
+		c.JSON(http.StatusConflict, gin.H{"error": "Incoming request already exists", "code": errcode.Conflict})
 		return
 	}
 
@@ -105,7 +113,7 @@ func SendFriendRequestHandler(c *gin.Context) {
 			requesterID, body.ReceiverID, body.ReceiverID, requesterID,
 		).
 		Delete(&model.FriendRequest{}).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Cleanup accepted requests failed"})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Cleanup accepted requests failed", "code": errcode.Internal})
 		return
 	}
 
@@ -121,7 +129,7 @@ func SendFriendRequestHandler(c *gin.Context) {
 	if err == nil && last.Status == "declined" {
 		last.Status = "pending"
 		if err := db.DB.Save(&last).Error; err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revive request"})
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revive request", "code": errcode.Internal})
 			return
 		}
 
@@ -136,7 +144,7 @@ func SendFriendRequestHandler(c *gin.Context) {
 		return
 	} else if err != nil && err != gorm.ErrRecordNotFound {
 		// 想定外のDBエラー
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error (find last request)"})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error (find last request)", "code": errcode.Internal})
 		return
 	}
 
@@ -147,7 +155,7 @@ func SendFriendRequestHandler(c *gin.Context) {
 		Status:      "pending",
 	}
 	if err := db.DB.Create(&request).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create request"})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create request", "code": errcode.Internal})
 		return
 	}
 
@@ -165,7 +173,7 @@ func GetFriendRequestsHandler(c *gin.Context) {
 		Preload("Requester").
 		Order("created_at DESC").
 		Find(&requests).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch requests"})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch requests", "code": errcode.Internal})
 		return
 	}
 	c.JSON(http.StatusOK, requests)
@@ -180,16 +188,16 @@ func RespondToFriendRequestHandler(c *gin.Context) {
 	var req model.FriendRequest
 	if err := db.DB.First(&req, id).Error; err != nil {
 		if err == gorm.ErrRecordNotFound {
-			c.JSON(http.StatusNotFound, gin.H{"error": "Request not found"})
+			c.JSON(http.StatusNotFound, gin.H{"error": "Request not found", "code": errcode.NotFound})
 			return
 		}
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error (find request)"})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error (find request)", "code": errcode.Internal})
 		return
 	}
 
 	// 認可：受信者のみ操作可能
 	if req.ReceiverID != userID {
-		c.JSON(http.StatusForbidden, gin.H{"error": "Not allowed"})
+		c.JSON(http.StatusForbidden, gin.H{"error": "Not allowed", "code": errcode.Forbidden})
 		return
 	}
 
@@ -197,7 +205,7 @@ func RespondToFriendRequestHandler(c *gin.Context) {
 		Status string `json:"status"`
 	}
 	if err := c.ShouldBindJSON(&body); err != nil || (body.Status != "accepted" && body.Status != "declined") {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid status"})
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid status", "code": errcode.InvalidPayload})
 		return
 	}
 
@@ -240,7 +248,7 @@ func RespondToFriendRequestHandler(c *gin.Context) {
 
 			return nil
 		}); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Update failed"})
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Update failed", "code": errcode.Internal})
 			return
 		}
 		c.JSON(http.StatusOK, gin.H{"ok": true})
@@ -250,7 +258,7 @@ func RespondToFriendRequestHandler(c *gin.Context) {
 	// 拒否
 	req.Status = "declined"
 	if err := db.DB.Save(&req).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Update failed"})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Update failed", "code": errcode.Internal})
 		return
 	}
 	c.JSON(http.StatusOK, req)
@@ -270,6 +278,9 @@ func GetFriendsHandler(c *gin.Context) {
 			m.content,
 			m.message_type,
 			m.attachment_url,
+			m.attachment_variants,
+			m.attachment_name,
+			m.attachment_duration_ms,
 			m.created_at,
 			m.edited_at,
 			m.is_deleted,
@@ -291,10 +302,15 @@ func GetFriendsHandler(c *gin.Context) {
 			friends.friend_id,
 			users.nickname  AS friend_nickname,
 			users.avatar_url AS friend_avatar_url,
+			users.last_seen_at AS last_seen_at,
+			users.hide_presence AS hide_presence,
 			last_msg.id AS last_message_id,
 			last_msg.content AS last_message_content,
 			last_msg.message_type AS last_message_type,
 			last_msg.attachment_url AS last_message_attachment_url,
+			last_msg.attachment_variants AS last_message_variants,
+			last_msg.attachment_name AS last_message_name,
+			last_msg.attachment_duration_ms AS last_message_duration_ms,
 			last_msg.created_at AS last_message_at,
 			last_msg.edited_at AS last_message_edited_at,
 			last_msg.is_deleted AS last_message_is_deleted,
@@ -314,10 +330,31 @@ func GetFriendsHandler(c *gin.Context) {
 		Where("friends.user_id = ?", userID).
 		Order("last_message_at IS NULL, last_message_at DESC, users.nickname ASC").
 		Scan(&out).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get friends"})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get friends", "code": errcode.Internal})
 		return
 	}
 
+	// 添付は参加者確認付きの URL に差し替える。
+	// オンライン状態は Hub（全ノード合算）から取得。非公開の相手は常にオフライン扱い
+	for i := range out {
+		if out[i].LastMessageAttachment != nil && out[i].LastMessageID != nil {
+			// プレビューは縮小版があればそちらを使う
+			preview := model.Message{ID: *out[i].LastMessageID, AttachmentVariants: out[i].LastMessageVariants}
+			url := model.AttachmentAccessURL(preview.ID)
+			if preview.HasVariant(storage.VariantThumb) {
+				url = model.AttachmentVariantURL(preview.ID, storage.VariantThumb)
+			}
+			out[i].LastMessageAttachment = &url
+		}
+		if out[i].HidePresence {
+			out[i].Presence = ws.StatusOffline
+			out[i].LastSeenAt = nil
+			continue
+		}
+		out[i].Presence = hub.UserStatus(out[i].FriendID)
+		out[i].IsOnline = out[i].Presence != ws.StatusOffline
+	}
+
 	log.Printf("📦 Friends result count=%d", len(out))
 	c.JSON(http.StatusOK, out)
 }
@@ -326,13 +363,13 @@ func GetFriendsHandler(c *gin.Context) {
 func DeleteFriendHandler(c *gin.Context) {
 	id, err := strconv.Atoi(c.Param("id"))
 	if err != nil || id <= 0 {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid friend id"})
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid friend id", "code": errcode.InvalidPayload})
 		return
 	}
 	userID := c.GetUint("user_id")
 	friendID := uint(id)
 
-	var attachmentKeys []string
+	attachmentKeys := make(map[uint]string) // メッセージID → 添付のキー
 
 	err = db.DB.Transaction(func(tx *gorm.DB) error {
 		res1 := tx.Where("user_id = ? AND friend_id = ?", userID, friendID).Delete(&model.Friend{})
@@ -378,7 +415,7 @@ func DeleteFriendHandler(c *gin.Context) {
 					if hasPendingReports(msg.ID) {
 						log.Printf("ℹ️ preserve attachment for message %d due to pending reports", msg.ID)
 					} else {
-						attachmentKeys = append(attachmentKeys, *msg.AttachmentObj)
+						attachmentKeys[msg.ID] = *msg.AttachmentObj
 					}
 				}
 			}
@@ -395,22 +432,28 @@ func DeleteFriendHandler(c *gin.Context) {
 	})
 
 	if errors.Is(err, gorm.ErrRecordNotFound) {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Friend relation not found"})
+		c.JSON(http.StatusNotFound, gin.H{"error": "Friend relation not found", "code": errcode.NotFound})
 		return
 	}
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete friend"})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete friend", "code": errcode.Internal})
 		return
 	}
 
 	// 添付ファイルのクリーンアップはトランザクション外で実施
-	for _, key := range attachmentKeys {
-		if err := storage.Default().Delete(key); err != nil {
+	for messageID, key := range attachmentKeys {
+		if err := model.ReleaseMessageAttachment(messageID, key); err != nil {
 			log.Printf("⚠️ failed to delete attachment %s: %v", key, err)
 		}
 	}
 
 	roomID := ws.BuildRoomID(userID, friendID)
+	// 履歴を物理削除したので、これ以前の seq からの resume は再同期させる
+	if seq, err := model.NextRoomSeq(roomID); err != nil {
+		log.Printf("⚠️ failed to allocate room seq: %v", err)
+	} else if err := model.RaiseRoomFloor(roomID, seq); err != nil {
+		log.Printf("⚠️ failed to raise room floor: %v", err)
+	}
 	broadcastRoomRevoked(roomID)
 
 	c.JSON(http.StatusOK, gin.H{"message": "Friend deleted and conversation purged"})
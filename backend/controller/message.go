@@ -1,20 +1,24 @@
 package controller
 
 import (
-	"encoding/json"
+	"bytes"
+	"errors"
+	"io"
 	"log"
 	"net/http"
-	"path/filepath"
 	"strconv"
 	"strings"
 	"time"
 
+	"chillow/config"
 	"chillow/db"
+	"chillow/errcode"
 	"chillow/model"
 	"chillow/storage"
 	"chillow/ws"
 
 	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
 	"gorm.io/gorm/clause"
 )
 
@@ -23,19 +27,20 @@ const (
 	maxAttachmentSizeBytes = 8 * 1024 * 1024 // 8MB
 )
 
-var allowedAttachmentExtensions = map[string]struct{}{
-	".jpg":  {},
-	".jpeg": {},
-	".png":  {},
-	".gif":  {},
-	".webp": {},
+// 拡張子ごとの Content-Type（直接アップロードでは署名条件に使う）
+var allowedAttachmentExtensions = map[string]string{
+	".jpg":  "image/jpeg",
+	".jpeg": "image/jpeg",
+	".png":  "image/png",
+	".gif":  "image/gif",
+	".webp": "image/webp",
 }
 
 func GetMessagesHandler(c *gin.Context) {
 	userID := c.GetUint("user_id")
 	friendID, err := strconv.Atoi(c.Param("friend_id"))
 	if err != nil || friendID <= 0 {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid friend id"})
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid friend id", "code": errcode.InvalidPayload})
 		return
 	}
 
@@ -44,7 +49,7 @@ func GetMessagesHandler(c *gin.Context) {
 		"(sender_id = ? AND receiver_id = ?) OR (sender_id = ? AND receiver_id = ?)",
 		userID, friendID, friendID, userID,
 	).Order("created_at asc").Find(&messages).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch messages"})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch messages", "code": errcode.Internal})
 		return
 	}
 
@@ -60,18 +65,26 @@ func GetMessagesHandler(c *gin.Context) {
 		if err := db.DB.Model(&model.Message{}).
 			Where("receiver_id = ? AND sender_id = ? AND is_read = ?", userID, friendID, false).
 			Update("is_read", true).Error; err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to mark as read"})
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to mark as read", "code": errcode.Internal})
 			return
 		}
 		ids := make([]uint, 0, len(toMark))
+		read := make([]model.Message, 0, len(toMark))
 		for _, msg := range toMark {
 			msg.IsRead = true
 			ids = append(ids, msg.ID)
-			broadcastMessageEvent("message:read", *msg)
+			read = append(read, *msg)
+		}
+		// まとめて既読にした分は 1 つの通番で通知する
+		if hub != nil {
+			ws.BroadcastMessages(hub, "message:read", read)
 		}
 		recordReadReceipts(userID, ids)
 	}
 
+	for i := range messages {
+		messages[i] = messages[i].WithAccessURL()
+	}
 	c.JSON(http.StatusOK, messages)
 }
 
@@ -79,19 +92,25 @@ func PostMessageHandler(c *gin.Context) {
 	senderID := c.GetUint("user_id")
 
 	var req struct {
-		ReceiverID       uint    `json:"receiver_id"`
-		Content          string  `json:"content"`
-		MessageType      string  `json:"message_type"`
-		AttachmentURL    *string `json:"attachment_url"`
-		AttachmentObject *string `json:"attachment_object"`
+		ReceiverID   uint   `json:"receiver_id"`
+		ClientMsgID  string `json:"client_msg_id"`
+		Content      string `json:"content"`
+		MessageType  string `json:"message_type"`
+		AttachmentID *uint  `json:"attachment_id"` // /messages/media で取得した添付ID
 	}
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request", "code": errcode.InvalidPayload})
 		return
 	}
 
 	if req.ReceiverID == 0 {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "receiver_id is required"})
+		c.JSON(http.StatusBadRequest, gin.H{"error": "receiver_id is required", "code": errcode.InvalidPayload})
+		return
+	}
+
+	clientMsgID := strings.TrimSpace(req.ClientMsgID)
+	if len(clientMsgID) > model.MaxClientMsgIDLength {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "client_msg_id is too long", "code": errcode.InvalidClientMsgID})
 		return
 	}
 
@@ -104,134 +123,284 @@ func PostMessageHandler(c *gin.Context) {
 	switch messageType {
 	case "text":
 		if trimmedContent == "" {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "content is required"})
+			c.JSON(http.StatusBadRequest, gin.H{"error": "content is required", "code": errcode.ContentRequired})
 			return
 		}
 	case "sticker":
 		if trimmedContent == "" {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "sticker payload is required"})
+			c.JSON(http.StatusBadRequest, gin.H{"error": "sticker payload is required", "code": errcode.ContentRequired})
 			return
 		}
-	case "image":
-		if req.AttachmentURL == nil || strings.TrimSpace(*req.AttachmentURL) == "" {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "attachment_url is required for images"})
+	case "image", "file", "audio":
+		if req.AttachmentID == nil || *req.AttachmentID == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "attachment_id is required for " + messageType, "code": errcode.AttachmentRequired})
 			return
 		}
 	default:
-		c.JSON(http.StatusBadRequest, gin.H{"error": "unsupported message_type"})
+		c.JSON(http.StatusBadRequest, gin.H{"error": "unsupported message_type", "code": errcode.UnsupportedType})
 		return
 	}
 
 	if len([]rune(trimmedContent)) > maxMessageLength {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "content is too long"})
+		c.JSON(http.StatusBadRequest, gin.H{"error": "content is too long", "code": errcode.ContentTooLong})
 		return
 	}
 
-	var normalizedAttachment *string
-	if req.AttachmentURL != nil {
-		url := strings.TrimSpace(*req.AttachmentURL)
-		if url != "" {
-			normalizedAttachment = &url
-		}
+	// 再送（同じ client_msg_id）は新規作成せず元のメッセージを返す（送信済みの添付は使用済みなので、添付の確認より先に）
+	if existing, err := model.FindMessageByClientID(senderID, clientMsgID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send message", "code": errcode.Internal})
+		return
+	} else if existing != nil {
+		respondDuplicateMessage(c, *existing, req.ReceiverID)
+		return
 	}
-	var normalizedObject *string
-	if req.AttachmentObject != nil {
-		obj := strings.TrimSpace(*req.AttachmentObject)
-		if obj != "" {
-			normalizedObject = &obj
+
+	// 制裁（mute）中は送信できない（mute 前に届いた分の再送は上で返す）
+	mute, err := model.ActiveMute(senderID, time.Now())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send message", "code": errcode.Internal})
+		return
+	}
+	if mute != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": "account is muted", "code": errcode.AccountMuted, "muted_until": mute.ExpiresAt})
+		return
+	}
+
+	// 添付はクライアントの指定した URL ではなく、本人がアップロードした記録から解決する（種類も一致が必要）
+	var att *model.Attachment
+	if req.AttachmentID != nil && *req.AttachmentID != 0 {
+		found, err := model.FindUsableAttachment(senderID, *req.AttachmentID, messageType)
+		if err != nil {
+			if errors.Is(err, model.ErrAttachmentUnavailable) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "attachment is not available", "code": errcode.AttachmentUnusable})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send message", "code": errcode.Internal})
+			return
 		}
+		att = found
 	}
 
 	now := time.Now()
+	expiresAt, err := model.MessageExpiry(senderID, req.ReceiverID, now)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send message", "code": errcode.Internal})
+		return
+	}
 	msg := model.Message{
-		SenderID:      senderID,
-		ReceiverID:    req.ReceiverID,
-		Content:       trimmedContent,
-		MessageType:   messageType,
-		AttachmentURL: normalizedAttachment,
-		AttachmentObj: normalizedObject,
-		CreatedAt:     now,
-		UpdatedAt:     now,
+		SenderID:    senderID,
+		ReceiverID:  req.ReceiverID,
+		Content:     trimmedContent,
+		MessageType: messageType,
+		ExpiresAt:   expiresAt,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+	if clientMsgID != "" {
+		msg.ClientMsgID = &clientMsgID
+	}
+
+	if att != nil {
+		msg.SetAttachment(att, storage.Default().PublicURL(att.ObjectKey))
 	}
 
-	if err := db.DB.Create(&msg).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send message"})
+	if err := model.CreateMessageWithAttachment(&msg, att); err != nil {
+		// 同時再送でユニーク制約に当たった場合は先に保存された方を返す
+		if existing, lookupErr := model.FindMessageByClientID(senderID, clientMsgID); lookupErr == nil && existing != nil {
+			respondDuplicateMessage(c, *existing, req.ReceiverID)
+			return
+		}
+		if errors.Is(err, model.ErrAttachmentUnavailable) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "attachment is not available", "code": errcode.AttachmentUnusable})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send message", "code": errcode.Internal})
 		return
 	}
 	recordReadReceipts(senderID, []uint{msg.ID})
-	c.JSON(http.StatusOK, msg)
+	c.JSON(http.StatusOK, msg.WithAccessURL())
+}
+
+func respondDuplicateMessage(c *gin.Context, existing model.Message, receiverID uint) {
+	if existing.ReceiverID != receiverID {
+		c.JSON(http.StatusConflict, gin.H{"error": "client_msg_id already used", "code": errcode.ClientMsgIDInUse})
+		return
+	}
+	c.JSON(http.StatusOK, existing.WithAccessURL())
 }
 
 func UploadMessageMediaHandler(c *gin.Context) {
 	userID := c.GetUint("user_id")
 	file, err := c.FormFile("file")
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "file is required"})
+		c.JSON(http.StatusBadRequest, gin.H{"error": "file is required", "code": errcode.InvalidPayload})
 		return
 	}
 
-	if file.Size > maxAttachmentSizeBytes {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "file is too large"})
+	kind, ok := parseAttachmentKind(c.PostForm("kind"))
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "unsupported attachment kind", "code": errcode.InvalidPayload})
+		return
+	}
+	limit := maxAttachmentBytes(kind)
+	if file.Size > limit {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "file is too large", "code": errcode.FileTooLarge})
+		return
+	}
+	if !allowedAttachmentName(kind, file.Filename) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "unsupported file type", "code": errcode.UnsupportedFile})
+		return
+	}
+	if !checkStorageQuota(c, userID, file.Size) {
 		return
 	}
 
-	ext := strings.ToLower(filepath.Ext(file.Filename))
-	if _, ok := allowedAttachmentExtensions[ext]; !ok {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "unsupported file type"})
+	src, err := file.Open()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "file is required", "code": errcode.InvalidPayload})
 		return
 	}
+	defer src.Close()
 
-	url, objectKey, err := storage.Default().SaveChatMedia(userID, file)
+	var upload *inspectedUpload
+	var sourceHash, contentHash string
+	var put func(key string) error
+	if kind == model.AttachmentKindFile {
+		// 汎用ファイルは加工しないので、先頭だけ確認して全体をメモリに載せずに保存先へ流す
+		upload, err = inspectFileHeader(src, file.Filename)
+		if err != nil {
+			respondUploadRejected(c, err)
+			return
+		}
+		hash, size, err := storage.HashReader(src)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "failed to read file", "code": errcode.InvalidPayload})
+			return
+		}
+		if _, err := src.Seek(0, io.SeekStart); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "failed to read file", "code": errcode.InvalidPayload})
+			return
+		}
+		if err := scanAttachment(userID, kind, file.Filename, src); err != nil {
+			respondScanRejected(c, err)
+			return
+		}
+		sourceHash, contentHash = hash, hash
+		put = func(key string) error {
+			if _, err := src.Seek(0, io.SeekStart); err != nil {
+				return err
+			}
+			return storage.Default().PutStream(key, src, size, upload.ContentType)
+		}
+		upload.Size = size
+	} else {
+		data, readErr := io.ReadAll(io.LimitReader(src, limit+1))
+		if readErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "failed to read file", "code": errcode.InvalidPayload})
+			return
+		}
+		if int64(len(data)) > limit {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "file is too large", "code": errcode.FileTooLarge})
+			return
+		}
+		// 拡張子ではなく中身で形式を判定し、画像は位置情報などのメタデータを取り除いてから保存する
+		upload, err = inspectUpload(kind, file.Filename, data, config.Cfg.ImageMaxPixels)
+		if err != nil {
+			respondUploadRejected(c, err)
+			return
+		}
+		// 検査は無害化前の中身に対して行う
+		if err := scanAttachment(userID, kind, file.Filename, bytes.NewReader(data)); err != nil {
+			respondScanRejected(c, err)
+			return
+		}
+		sourceHash, contentHash = storage.HashContent(data), storage.HashContent(upload.Data)
+		put = func(key string) error {
+			return storage.Default().Put(key, upload.Data, upload.ContentType)
+		}
+	}
+
+	// 検査を通るまでは保存先に置かない。同じ中身が保存済みなら保存を省いて参照だけ増やす。メッセージからは添付ID で参照し、使われないまま期限を過ぎたものは削除される
+	expiresAt := time.Now().Add(config.Cfg.AttachmentClaimTTL)
+	att := model.Attachment{
+		OwnerID:     userID,
+		ObjectKey:   storage.ContentObjectKey(contentHash, kind, upload.Ext),
+		Kind:        kind,
+		Filename:    sanitizeFilename(file.Filename),
+		ContentType: upload.ContentType,
+		Size:        upload.Size,
+		Status:      model.AttachmentReady,
+		DurationMs:  upload.DurationMs,
+		ContentHash: contentHash,
+		SourceHash:  sourceHash,
+		ExpiresAt:   &expiresAt,
+	}
+	reused, err := saveContentAttachment(&att, put, func(tx *gorm.DB) error {
+		return tx.Create(&att).Error
+	})
 	if err != nil {
 		log.Printf("❌ failed to store media: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to save file"})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to save file", "code": errcode.Internal})
 		return
 	}
-	resp := gin.H{"url": url}
-	if objectKey != "" {
-		resp["objectKey"] = objectKey
+
+	if kind == model.AttachmentKindImage && !reused {
+		generateImageVariants(&att, upload.Data)
+	}
+
+	// 保存先の URL は非公開なので、プレビュー用に期限付き URL を返す
+	url, err := storage.Default().SignedURL(att.ObjectKey, config.Cfg.AttachmentURLTTL)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to save file", "code": errcode.Internal})
+		return
 	}
-	c.JSON(http.StatusOK, resp)
+	c.JSON(http.StatusOK, gin.H{
+		"attachment_id": att.ID,
+		"kind":          kind,
+		"url":           url,
+		"objectKey":     att.ObjectKey,
+		"deduplicated":  reused,
+	})
 }
 
 func MarkMessageAsReadHandler(c *gin.Context) {
 	userID := c.GetUint("user_id")
 	id, err := strconv.Atoi(c.Param("id"))
 	if err != nil || id <= 0 {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id", "code": errcode.InvalidPayload})
 		return
 	}
 
 	var msg model.Message
 	if err := db.DB.First(&msg, id).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Message not found"})
+		c.JSON(http.StatusNotFound, gin.H{"error": "Message not found", "code": errcode.NotFound})
 		return
 	}
 
 	if msg.ReceiverID != userID {
-		c.JSON(http.StatusForbidden, gin.H{"error": "Not allowed"})
+		c.JSON(http.StatusForbidden, gin.H{"error": "Not allowed", "code": errcode.Forbidden})
 		return
 	}
 
 	if msg.IsRead {
-		c.JSON(http.StatusOK, msg)
+		c.JSON(http.StatusOK, msg.WithAccessURL())
 		return
 	}
 
 	msg.IsRead = true
 	if err := db.DB.Save(&msg).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to mark as read"})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to mark as read", "code": errcode.Internal})
 		return
 	}
 	broadcastMessageEvent("message:read", msg)
-	c.JSON(http.StatusOK, msg)
+	c.JSON(http.StatusOK, msg.WithAccessURL())
 }
 
 func UpdateMessageHandler(c *gin.Context) {
 	userID := c.GetUint("user_id")
 	id, err := strconv.Atoi(c.Param("id"))
 	if err != nil || id <= 0 {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id", "code": errcode.InvalidPayload})
 		return
 	}
 
@@ -239,33 +408,44 @@ func UpdateMessageHandler(c *gin.Context) {
 		Content string `json:"content"`
 	}
 	if err := c.ShouldBindJSON(&body); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid payload"})
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid payload", "code": errcode.InvalidPayload})
 		return
 	}
 
 	var msg model.Message
 	if err := db.DB.First(&msg, id).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Message not found"})
+		c.JSON(http.StatusNotFound, gin.H{"error": "Message not found", "code": errcode.NotFound})
 		return
 	}
 
-	if msg.SenderID != userID {
-		c.JSON(http.StatusForbidden, gin.H{"error": "Not allowed"})
+	if msg.SenderID != userID || msg.MessageType == "system" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Not allowed", "code": errcode.Forbidden})
 		return
 	}
 	if msg.IsDeleted {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Cannot edit deleted message"})
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Cannot edit deleted message", "code": errcode.MessageDeleted})
+		return
+	}
+	// 制裁（mute）中は編集でも新しい内容を送れない
+	mute, err := model.ActiveMute(userID, time.Now())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update message", "code": errcode.Internal})
+		return
+	}
+	if mute != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": "account is muted", "code": errcode.AccountMuted, "muted_until": mute.ExpiresAt})
 		return
 	}
 
 	trimmed := strings.TrimSpace(body.Content)
-	if msg.MessageType != "image" {
+	// 添付付きメッセージは本文（キャプション）を空にできる
+	if !isAttachmentMessageType(msg.MessageType) {
 		if trimmed == "" {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "content is required"})
+			c.JSON(http.StatusBadRequest, gin.H{"error": "content is required", "code": errcode.ContentRequired})
 			return
 		}
 		if len([]rune(trimmed)) > maxMessageLength {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "content is too long"})
+			c.JSON(http.StatusBadRequest, gin.H{"error": "content is too long", "code": errcode.ContentTooLong})
 			return
 		}
 	}
@@ -275,32 +455,32 @@ func UpdateMessageHandler(c *gin.Context) {
 	msg.EditedAt = &now
 	msg.UpdatedAt = now
 	if err := db.DB.Save(&msg).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update message"})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update message", "code": errcode.Internal})
 		return
 	}
-	c.JSON(http.StatusOK, msg)
+	c.JSON(http.StatusOK, msg.WithAccessURL())
 }
 
 func DeleteMessageHandler(c *gin.Context) {
 	userID := c.GetUint("user_id")
 	id, err := strconv.Atoi(c.Param("id"))
 	if err != nil || id <= 0 {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id", "code": errcode.InvalidPayload})
 		return
 	}
 
 	var msg model.Message
 	if err := db.DB.First(&msg, id).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Message not found"})
+		c.JSON(http.StatusNotFound, gin.H{"error": "Message not found", "code": errcode.NotFound})
 		return
 	}
 
-	if msg.SenderID != userID {
-		c.JSON(http.StatusForbidden, gin.H{"error": "Not allowed"})
+	if msg.SenderID != userID || msg.MessageType == "system" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Not allowed", "code": errcode.Forbidden})
 		return
 	}
 	if msg.IsDeleted {
-		c.JSON(http.StatusOK, msg)
+		c.JSON(http.StatusOK, msg.WithAccessURL())
 		return
 	}
 
@@ -311,39 +491,27 @@ func DeleteMessageHandler(c *gin.Context) {
 		if hasPendingReports(msg.ID) {
 			log.Printf("ℹ️ preserve attachment for message %d due to pending reports", msg.ID)
 		} else {
-			if err := storage.Default().Delete(*msg.AttachmentObj); err != nil {
+			if err := model.ReleaseMessageAttachment(msg.ID, *msg.AttachmentObj); err != nil {
 				log.Printf("⚠️ failed to delete attachment: %v", err)
 			} else {
-				msg.AttachmentURL = nil
-				msg.AttachmentObj = nil
+				msg.ClearAttachment()
 			}
 		}
 	}
 	msg.Content = ""
 	msg.UpdatedAt = now
 	if err := db.DB.Save(&msg).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete message"})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete message", "code": errcode.Internal})
 		return
 	}
-	c.JSON(http.StatusOK, msg)
+	c.JSON(http.StatusOK, msg.WithAccessURL())
 }
 
 func broadcastMessageEvent(eventType string, msg model.Message) {
 	if hub == nil {
 		return
 	}
-	roomID := ws.BuildRoomID(msg.SenderID, msg.ReceiverID)
-	event := ws.MessageEvent{
-		Type:    eventType,
-		RoomID:  roomID,
-		Message: ws.BuildMessageDTO(roomID, msg),
-	}
-	bytes, err := json.Marshal(event)
-	if err != nil {
-		log.Printf("⚠️ failed to marshal %s event: %v", eventType, err)
-		return
-	}
-	hub.Broadcast(roomID, bytes)
+	ws.BroadcastMessage(hub, eventType, msg)
 }
 
 func recordReadReceipts(userID uint, messageIDs []uint) {
@@ -8,6 +8,7 @@ import (
 
 	"chillow/adminstream"
 	"chillow/db"
+	"chillow/errcode"
 	"chillow/model"
 
 	"github.com/gin-gonic/gin"
@@ -18,7 +19,7 @@ func ReportMessageHandler(c *gin.Context) {
 	userID := c.GetUint("user_id")
 	messageID, err := strconv.Atoi(c.Param("id"))
 	if err != nil || messageID <= 0 {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid message id"})
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid message id", "code": errcode.InvalidPayload})
 		return
 	}
 
@@ -26,41 +27,45 @@ func ReportMessageHandler(c *gin.Context) {
 		Reason string `json:"reason"`
 	}
 	if err := c.ShouldBindJSON(&body); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body", "code": errcode.InvalidPayload})
 		return
 	}
 	reason := strings.TrimSpace(body.Reason)
 	if reason == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "理由を入力してください"})
+		c.JSON(http.StatusBadRequest, gin.H{"error": "理由を入力してください", "code": errcode.InvalidPayload})
 		return
 	}
 
 	var msg model.Message
 	if err := db.DB.First(&msg, messageID).Error; err != nil {
 		if err == gorm.ErrRecordNotFound {
-			c.JSON(http.StatusNotFound, gin.H{"error": "Message not found"})
+			c.JSON(http.StatusNotFound, gin.H{"error": "Message not found", "code": errcode.NotFound})
 			return
 		}
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load message"})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load message", "code": errcode.Internal})
 		return
 	}
 
+	if msg.MessageType == "system" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "システムメッセージは通報できません", "code": errcode.NotAllowed})
+		return
+	}
 	if msg.SenderID == userID {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "自分のメッセージは通報できません"})
+		c.JSON(http.StatusBadRequest, gin.H{"error": "自分のメッセージは通報できません", "code": errcode.NotAllowed})
 		return
 	}
 	if msg.ReceiverID != userID {
-		c.JSON(http.StatusForbidden, gin.H{"error": "このメッセージを通報する権限がありません"})
+		c.JSON(http.StatusForbidden, gin.H{"error": "このメッセージを通報する権限がありません", "code": errcode.Forbidden})
 		return
 	}
 
 	var existing model.Report
 	if err := db.DB.Where("message_id = ? AND reporter_id = ? AND status = ?", msg.ID, userID, "pending").
 		First(&existing).Error; err == nil {
-		c.JSON(http.StatusConflict, gin.H{"error": "既に通報済みです"})
+		c.JSON(http.StatusConflict, gin.H{"error": "既に通報済みです", "code": errcode.Conflict})
 		return
 	} else if err != nil && err != gorm.ErrRecordNotFound {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to check reports"})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to check reports", "code": errcode.Internal})
 		return
 	}
 
@@ -72,19 +77,28 @@ func ReportMessageHandler(c *gin.Context) {
 		MessageType:    msg.MessageType,
 		AttachmentURL:  msg.AttachmentURL,
 		AttachmentObj:  msg.AttachmentObj,
+		AttachmentName: msg.AttachmentName,
+		AttachmentSize: msg.AttachmentSize,
 		Reason:         reason,
 		Status:         "pending",
 	}
 	if err := db.DB.Create(&report).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create report"})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create report", "code": errcode.Internal})
 		return
 	}
 
 	if err := db.DB.Preload("Reporter").Preload("ReportedUser").First(&report, report.ID).Error; err != nil {
 		log.Printf("⚠️ failed to preload report: %v", err)
 	} else {
-		adminstream.Broadcast(adminstream.Event{Type: "report:new", Report: &report})
+		created := report.WithAccessURL()
+		adminstream.Broadcast(adminstream.Event{Type: "report:new", Report: &created})
 	}
 
-	c.JSON(http.StatusCreated, report)
+	// 通報者には自分が参加している会話の添付 URL を返す
+	resp := report
+	if msg.AttachmentObj != nil && *msg.AttachmentObj != "" {
+		url := model.AttachmentAccessURL(msg.ID)
+		resp.AttachmentURL = &url
+	}
+	c.JSON(http.StatusCreated, resp)
 }
@@ -1,51 +1,81 @@
 package controller
 
 import (
+	"errors"
 	"log"
 
 	"chillow/db"
 	"chillow/model"
-	"chillow/storage"
+
+	"gorm.io/gorm"
 )
 
 func hasPendingReports(messageID uint) bool {
-	if messageID == 0 {
-		return false
-	}
-	var count int64
-	if err := db.DB.Model(&model.Report{}).Where("message_id = ? AND status = ?", messageID, "pending").Count(&count).Error; err != nil {
+	pending, err := model.HasPendingReports(messageID)
+	if err != nil {
 		log.Printf("⚠️ failed to count reports: %v", err)
 		return false
 	}
-	return count > 0
+	return pending
 }
 
+// cleanupAttachmentEvidence は通報の処理後、保全していた添付を削除する。
+// メッセージが表示中なら添付もまだ使われているので残す。
 func cleanupAttachmentEvidence(messageID uint, attachmentObj *string) {
 	if messageID == 0 || hasPendingReports(messageID) {
 		return
 	}
-	key := ""
-	messageDeleted := false
-	var msg model.Message
-	if err := db.DB.Select("id", "is_deleted", "attachment_object").First(&msg, messageID).Error; err == nil {
-		messageDeleted = msg.IsDeleted
-		if msg.AttachmentObj != nil && *msg.AttachmentObj != "" {
-			key = *msg.AttachmentObj
+	var keys []string
+	addKey := func(key *string) {
+		if key == nil || *key == "" {
+			return
+		}
+		for _, k := range keys {
+			if k == *key {
+				return
+			}
 		}
+		keys = append(keys, *key)
 	}
-	if attachmentObj != nil && *attachmentObj != "" {
-		key = *attachmentObj
+
+	messageExists := true
+	var msg model.Message
+	err := db.DB.Select("id", "is_deleted", "attachment_obj").First(&msg, messageID).Error
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		// フレンド削除や消えるメッセージで物理削除済み
+		messageExists = false
+	case err != nil:
+		log.Printf("⚠️ failed to load reported message %d: %v", messageID, err)
+		return
+	case !msg.IsDeleted:
+		return
+	default:
+		addKey(msg.AttachmentObj)
 	}
-	if key == "" {
+	addKey(attachmentObj)
+	if len(keys) == 0 {
 		return
 	}
-	if err := storage.Default().Delete(key); err != nil {
-		log.Printf("⚠️ failed to delete attachment evidence: %v", err)
+
+	for _, key := range keys {
+		if err := model.ReleaseMessageAttachment(messageID, key); err != nil {
+			// 残ったものは定期的な回収（storage gc）で削除される
+			log.Printf("⚠️ failed to delete attachment evidence %s: %v", key, err)
+			return
+		}
 	}
-	if messageDeleted {
+	if messageExists {
 		if err := db.DB.Model(&model.Message{}).Where("id = ?", messageID).Updates(map[string]interface{}{
-			"attachment_url":    nil,
-			"attachment_object": nil,
+			"attachment_url":         nil,
+			"attachment_obj":         nil,
+			"attachment_width":       nil,
+			"attachment_height":      nil,
+			"attachment_blurhash":    nil,
+			"attachment_variants":    nil,
+			"attachment_name":        nil,
+			"attachment_size":        nil,
+			"attachment_duration_ms": nil,
 		}).Error; err != nil {
 			log.Printf("⚠️ failed to clear attachment columns: %v", err)
 		}
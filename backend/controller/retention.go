@@ -0,0 +1,97 @@
+package controller
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"chillow/db"
+	"chillow/model"
+	"chillow/ws"
+
+	"gorm.io/gorm"
+)
+
+const retentionSweepBatchSize = 200
+
+// RunRetentionSweeper は期限切れメッセージを定期的に物理削除する（ctx 終了で停止）
+func RunRetentionSweeper(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if n, err := sweepExpiredMessages(time.Now()); err != nil {
+				log.Printf("⚠️ retention sweep failed: %v", err)
+			} else if n > 0 {
+				log.Printf("🧹 retention sweep removed %d messages", n)
+			}
+		}
+	}
+}
+
+// 通報が処理待ちのメッセージは保全し、それ以外をバッチ単位で削除する
+func sweepExpiredMessages(now time.Time) (int, error) {
+	total := 0
+	for {
+		var expired []model.Message
+		if err := db.DB.
+			Where("expires_at IS NOT NULL AND expires_at <= ?", now).
+			Where("id NOT IN (?)", model.PendingReportMessageIDs()).
+			Order("id ASC").
+			Limit(retentionSweepBatchSize).
+			Find(&expired).Error; err != nil {
+			return total, err
+		}
+		if len(expired) == 0 {
+			return total, nil
+		}
+
+		ids := make([]uint, 0, len(expired))
+		attachmentKeys := make(map[uint]string)
+		for _, msg := range expired {
+			ids = append(ids, msg.ID)
+			if msg.AttachmentObj != nil && *msg.AttachmentObj != "" {
+				attachmentKeys[msg.ID] = *msg.AttachmentObj
+			}
+		}
+
+		if err := db.DB.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Where("message_id IN ?", ids).Delete(&model.MessageRead{}).Error; err != nil {
+				return err
+			}
+			return tx.Where("id IN ?", ids).Delete(&model.Message{}).Error
+		}); err != nil {
+			return total, err
+		}
+
+		// 添付ファイルのクリーンアップはトランザクション外で実施
+		for messageID, key := range attachmentKeys {
+			if err := model.ReleaseMessageAttachment(messageID, key); err != nil {
+				log.Printf("⚠️ failed to delete expired attachment %s: %v", key, err)
+			}
+		}
+		broadcastMessagesExpired(expired)
+
+		total += len(expired)
+		if len(expired) < retentionSweepBatchSize {
+			return total, nil
+		}
+	}
+}
+
+func broadcastMessagesExpired(messages []model.Message) {
+	if hub == nil {
+		return
+	}
+	byRoom := make(map[string][]uint)
+	for _, msg := range messages {
+		roomID := ws.BuildRoomID(msg.SenderID, msg.ReceiverID)
+		byRoom[roomID] = append(byRoom[roomID], msg.ID)
+	}
+	for roomID, ids := range byRoom {
+		ws.BroadcastMessagesExpired(hub, roomID, ids)
+	}
+}
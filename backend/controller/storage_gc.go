@@ -0,0 +1,176 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"chillow/model"
+	"chillow/storage"
+)
+
+const (
+	storageGCPrefix = "chat/"
+	// レポートに載せる削除対象の件数（件数・容量の合計は全件分）
+	storageGCSampleSize = 200
+)
+
+var (
+	errStorageGCRunning = errors.New("storage gc is already running")
+	storageGCMu         sync.Mutex
+)
+
+type orphanObject struct {
+	Key          string    `json:"key"`
+	Size         int64     `json:"size"`
+	LastModified time.Time `json:"last_modified"`
+}
+
+type storageGCReport struct {
+	DryRun        bool           `json:"dry_run"`
+	StartedAt     time.Time      `json:"started_at"`
+	FinishedAt    time.Time      `json:"finished_at"`
+	Grace         string         `json:"grace"`
+	Scanned       int            `json:"scanned"`
+	ScannedBytes  int64          `json:"scanned_bytes"`
+	Referenced    int            `json:"referenced"`
+	InGrace       int            `json:"in_grace"` // 参照はないが猶予期間内のもの
+	OrphanCount   int            `json:"orphan_count"`
+	OrphanBytes   int64          `json:"orphan_bytes"`
+	Orphans       []orphanObject `json:"orphans"` // 先頭 storageGCSampleSize 件
+	Deleted       int            `json:"deleted"`
+	DeleteFailed  int            `json:"delete_failed"`
+	LedgerAdded   int            `json:"ledger_added"`   // 台帳になかった参照中のオブジェクト（使用量に計上）
+	LedgerRemoved int            `json:"ledger_removed"` // ストレージに存在しない台帳の行
+}
+
+// RunStorageGC はどこからも参照されていないオブジェクトを定期的に削除する（ctx 終了で停止）
+func RunStorageGC(ctx context.Context, interval, grace time.Duration, dryRun bool) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			report, err := collectStorageGarbage(time.Now(), grace, dryRun)
+			if errors.Is(err, errStorageGCRunning) {
+				continue
+			}
+			if err != nil {
+				log.Printf("⚠️ storage gc failed: %v", err)
+				continue
+			}
+			if report.OrphanCount > 0 || report.LedgerAdded > 0 || report.LedgerRemoved > 0 {
+				log.Printf("🧹 storage gc (dry_run=%v): scanned=%d orphans=%d (%d bytes) deleted=%d failed=%d ledger +%d/-%d",
+					report.DryRun, report.Scanned, report.OrphanCount, report.OrphanBytes,
+					report.Deleted, report.DeleteFailed, report.LedgerAdded, report.LedgerRemoved)
+			}
+		}
+	}
+}
+
+// collectStorageGarbage は chat/ 配下を列挙してメッセージ・通報・未使用アップロードの参照と突き合わせ、
+// 参照のないまま grace を過ぎたオブジェクトを削除する。あわせて使用量の台帳をストレージの実態に合わせる。
+// dryRun では何も変更せず、削除・修正する予定の内容だけを返す。
+func collectStorageGarbage(now time.Time, grace time.Duration, dryRun bool) (*storageGCReport, error) {
+	if !storageGCMu.TryLock() {
+		return nil, errStorageGCRunning
+	}
+	defer storageGCMu.Unlock()
+
+	report := &storageGCReport{DryRun: dryRun, StartedAt: now, Grace: grace.String(), Orphans: []orphanObject{}}
+	cutoff := now.Add(-grace)
+
+	// 参照は列挙より先に読む（後から参照されるのは猶予期間内の新しいオブジェクトだけ）
+	refs, err := model.ReferencedObjectKeys()
+	if err != nil {
+		return nil, err
+	}
+	referenced := make(map[string]struct{}, len(refs)*3)
+	for key := range refs {
+		referenced[key] = struct{}{}
+		for _, v := range storage.VariantKeys(key) {
+			referenced[v] = struct{}{}
+		}
+	}
+	ledger, err := model.StoredObjectTimes(now)
+	if err != nil {
+		return nil, err
+	}
+
+	var orphans []orphanObject
+	var unrecorded []storage.ObjectEntry
+	seen := make(map[string]struct{})
+	err = storage.Default().List(storageGCPrefix, func(obj storage.ObjectEntry) error {
+		seen[obj.Key] = struct{}{}
+		report.Scanned++
+		report.ScannedBytes += obj.Size
+		if _, ok := referenced[obj.Key]; ok {
+			report.Referenced++
+			if _, ok := ledger[obj.Key]; !ok {
+				if _, owned := storage.ObjectOwner(obj.Key); owned {
+					unrecorded = append(unrecorded, obj)
+				}
+			}
+			return nil
+		}
+		if obj.LastModified.After(cutoff) {
+			report.InGrace++
+			return nil
+		}
+		orphans = append(orphans, orphanObject{Key: obj.Key, Size: obj.Size, LastModified: obj.LastModified})
+		return nil
+	})
+	// 列挙が途中で失敗した場合は、見えなかったオブジェクトを誤って扱わないよう何も変更しない
+	if err != nil {
+		return nil, err
+	}
+
+	report.OrphanCount = len(orphans)
+	for i, o := range orphans {
+		report.OrphanBytes += o.Size
+		if i < storageGCSampleSize {
+			report.Orphans = append(report.Orphans, o)
+		}
+	}
+	report.LedgerAdded = len(unrecorded)
+	var missing []string
+	for key, recordedAt := range ledger {
+		if _, ok := seen[key]; !ok && recordedAt.Before(cutoff) {
+			missing = append(missing, key)
+		}
+	}
+	report.LedgerRemoved = len(missing)
+
+	if dryRun {
+		report.FinishedAt = time.Now()
+		return report, nil
+	}
+
+	// 削除は台帳の更新も兼ねる（Manager.Delete が使用量を差し引く）
+	for _, o := range orphans {
+		if err := storage.Default().Delete(o.Key); err != nil {
+			log.Printf("⚠️ failed to delete orphaned object %s: %v", o.Key, err)
+			report.DeleteFailed++
+			continue
+		}
+		report.Deleted++
+	}
+	recorder := model.StorageUsageRecorder{}
+	for _, obj := range unrecorded {
+		ownerID, _ := storage.ObjectOwner(obj.Key)
+		if err := recorder.ObjectStored(ownerID, obj.Key, obj.Size); err != nil {
+			log.Printf("⚠️ failed to record storage usage for %s: %v", obj.Key, err)
+		}
+	}
+	for _, key := range missing {
+		if err := recorder.ObjectDeleted(key); err != nil {
+			log.Printf("⚠️ failed to drop stale usage record %s: %v", key, err)
+		}
+	}
+	report.FinishedAt = time.Now()
+	return report, nil
+}
@@ -0,0 +1,53 @@
+package controller
+
+import (
+	"log"
+	"net/http"
+
+	"chillow/db"
+	"chillow/errcode"
+	"chillow/model"
+
+	"github.com/gin-gonic/gin"
+)
+
+type storageUsage struct {
+	UsedBytes  int64 `json:"used_bytes"`
+	QuotaBytes int64 `json:"quota_bytes"` // 0 以下は無制限
+}
+
+func loadStorageUsage(user *model.User) (storageUsage, error) {
+	used, err := model.StorageUsed(user.ID)
+	if err != nil {
+		return storageUsage{}, err
+	}
+	return storageUsage{UsedBytes: used, QuotaBytes: user.StorageQuota()}, nil
+}
+
+// checkStorageQuota は size バイトを追加で保存できるか確認する（できなければレスポンスを書いて false）
+func checkStorageQuota(c *gin.Context, userID uint, size int64) bool {
+	var user model.User
+	if err := db.DB.Select("id", "storage_quota_bytes").First(&user, userID).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to check storage quota", "code": errcode.Internal})
+		return false
+	}
+	if user.StorageQuota() <= 0 {
+		return true
+	}
+	usage, err := loadStorageUsage(&user)
+	if err != nil {
+		log.Printf("❌ failed to load storage usage for user %d: %v", userID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to check storage quota", "code": errcode.Internal})
+		return false
+	}
+	if usage.UsedBytes+size > usage.QuotaBytes {
+		c.JSON(http.StatusForbidden, gin.H{
+			"error":       "storage quota exceeded",
+			"code":        errcode.QuotaExceeded,
+			"used_bytes":  usage.UsedBytes,
+			"quota_bytes": usage.QuotaBytes,
+		})
+		return false
+	}
+	return true
+}
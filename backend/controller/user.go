@@ -2,6 +2,7 @@ package controller
 
 import (
 	"chillow/db"
+	"chillow/errcode"
 	"chillow/model"
 	"net/http"
 	"strings"
@@ -12,48 +13,81 @@ import (
 func GetUserHandler(c *gin.Context) {
 	userIDRaw, exists := c.Get("user_id")
 	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "認証情報がありません"})
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "認証情報がありません", "code": errcode.Unauthorized})
 		return
 	}
 	userID := userIDRaw.(uint)
 
 	var user model.User
 	if err := db.DB.First(&user, userID).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "ユーザーが見つかりません"})
+		c.JSON(http.StatusNotFound, gin.H{"error": "ユーザーが見つかりません", "code": errcode.NotFound})
 		return
 	}
 
-	c.JSON(http.StatusOK, user)
+	// 添付の保存容量（使用量と上限）をあわせて返す
+	usage, err := loadStorageUsage(&user)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "使用量の取得に失敗しました", "code": errcode.Internal})
+		return
+	}
+	c.JSON(http.StatusOK, struct {
+		model.User
+		Storage     storageUsage `json:"storage"`
+		Permissions []string     `json:"permissions"` // ロールに含まれる運営の権限（user は空）
+	}{user, usage, model.RolePermissions(user.Role)})
 }
 
 func PatchUserHandler(c *gin.Context) {
 	userIDRaw, exists := c.Get("user_id")
 	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "認証情報がありません"})
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "認証情報がありません", "code": errcode.Unauthorized})
 		return
 	}
 	userID := userIDRaw.(uint)
 
 	var req struct {
-		Nickname string `json:"nickname"`
+		Nickname     *string `json:"nickname"`
+		HidePresence *bool   `json:"hide_presence"`
 	}
-	if err := c.ShouldBindJSON(&req); err != nil || req.Nickname == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "無効なリクエストです"})
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "無効なリクエストです", "code": errcode.InvalidPayload})
 		return
 	}
 
-	if err := db.DB.Model(&model.User{}).Where("id = ?", userID).Update("nickname", req.Nickname).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "更新に失敗しました"})
+	updates := map[string]interface{}{}
+	if req.Nickname != nil {
+		nickname := strings.TrimSpace(*req.Nickname)
+		if nickname == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "無効なリクエストです", "code": errcode.InvalidPayload})
+			return
+		}
+		updates["nickname"] = nickname
+	}
+	if req.HidePresence != nil {
+		updates["hide_presence"] = *req.HidePresence
+	}
+	if len(updates) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "無効なリクエストです", "code": errcode.InvalidPayload})
 		return
 	}
 
+	if err := db.DB.Model(&model.User{}).Where("id = ?", userID).Updates(updates).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "更新に失敗しました", "code": errcode.Internal})
+		return
+	}
+
+	// 公開設定を変えたら友達側の表示も更新する
+	if req.HidePresence != nil {
+		hub.RefreshPresence(userID, *req.HidePresence)
+	}
+
 	c.Status(http.StatusNoContent)
 }
 
 func SearchUserByCodeHandler(c *gin.Context) {
 	code := strings.TrimSpace(c.Query("code"))
 	if code == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "フレンドコードが指定されていません"})
+		c.JSON(http.StatusBadRequest, gin.H{"error": "フレンドコードが指定されていません", "code": errcode.InvalidPayload})
 		return
 	}
 	code = strings.ToUpper(code)
@@ -62,15 +96,15 @@ func SearchUserByCodeHandler(c *gin.Context) {
 
 	var user model.User
 	if err := db.DB.Where("friend_code = ?", code).First(&user).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "ユーザーが見つかりません"})
+		c.JSON(http.StatusNotFound, gin.H{"error": "ユーザーが見つかりません", "code": errcode.NotFound})
 		return
 	}
 	if user.ID == currentUserID {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "自分自身は検索できません"})
+		c.JSON(http.StatusBadRequest, gin.H{"error": "自分自身は検索できません", "code": errcode.NotAllowed})
 		return
 	}
-	if strings.EqualFold(user.Role, "admin") {
-		c.JSON(http.StatusForbidden, gin.H{"error": "このユーザーにはフレンド申請できません"})
+	if model.IsStaffRole(user.Role) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "このユーザーにはフレンド申請できません", "code": errcode.Forbidden})
 		return
 	}
 
@@ -1,10 +1,12 @@
 package controller
 
 import (
+	"context"
 	"log"
 	"net/http"
 	"time"
 
+	"chillow/broker"
 	"chillow/config"
 	"chillow/db"
 	"chillow/model"
@@ -18,6 +20,8 @@ import (
 
 // WebSocketのUpgrade設定
 var upgrader = websocket.Upgrader{
+	// プロトコルバージョンはサブプロトコルで交渉（未指定は v1）
+	Subprotocols: ws.Subprotocols,
 	CheckOrigin: func(r *http.Request) bool {
 		origin := r.Header.Get("Origin")
 		return origin == config.Cfg.FrontendURL // フロントURLを許可
@@ -27,11 +31,26 @@ var upgrader = websocket.Upgrader{
 // Hub（全クライアント共有）
 var hub = ws.NewHub()
 
-func init() {
+// StartRealtime はブローカーを接続して Hub のイベントループを開始する
+func StartRealtime(b broker.Broker) error {
+	if err := hub.Attach(b); err != nil {
+		return err
+	}
 	go hub.Run() // goroutineでHubのイベントループ開始
+	return nil
+}
+
+// StopRealtime は WebSocket 接続を切断して Hub を停止する（シャットダウン時。DB を閉じる前に呼ぶ）
+func StopRealtime(ctx context.Context) {
+	hub.Shutdown(ctx)
 }
 
 func WSHandler(c *gin.Context) {
+	if hub.Draining() {
+		c.String(http.StatusServiceUnavailable, "server restarting")
+		return
+	}
+
 	token, err := c.Cookie(authsvc.AccessTokenCookieName)
 	if err != nil {
 		c.String(http.StatusUnauthorized, "missing token")
@@ -54,16 +73,17 @@ func WSHandler(c *gin.Context) {
 		c.String(http.StatusInternalServerError, "failed to load user")
 		return
 	}
-	if user.ShouldLiftBan(time.Now()) {
-		user.ClearBan()
-		_ = db.DB.Save(&user).Error
+	ban, err := model.CurrentBan(&user, time.Now())
+	if err != nil {
+		c.String(http.StatusInternalServerError, "failed to load user")
+		return
 	}
-	if user.IsBanned {
+	if ban != nil {
 		c.String(http.StatusForbidden, "account suspended")
 		return
 	}
-	if user.Role == "admin" {
-		c.String(http.StatusForbidden, "admin accounts cannot join chat")
+	if model.IsStaffRole(user.Role) {
+		c.String(http.StatusForbidden, "staff accounts cannot join chat")
 		return
 	}
 
@@ -75,5 +95,6 @@ func WSHandler(c *gin.Context) {
 	}
 
 	client := ws.NewClient(claims.UserID, conn, hub)
+	client.SetHidePresence(user.HidePresence)
 	client.Start() // readLoop, writeLoop 起動
 }
@@ -26,3 +26,12 @@ func InitDB() {
 	log.Println("✅ GORMでDB接続に成功しました")
 }
 
+
+// Close はコネクションプールを閉じる（シャットダウン時）
+func Close() error {
+	sqlDB, err := DB.DB()
+	if err != nil {
+		return err
+	}
+	return sqlDB.Close()
+}
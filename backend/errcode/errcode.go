@@ -0,0 +1,33 @@
+// Package errcode は REST と WebSocket で共通のエラーコードを定義する。
+// 値はクライアントが分岐に使うため、一度公開したら変更しないこと。
+package errcode
+
+const (
+	InvalidPayload     = "invalid_payload"
+	Unauthorized       = "unauthorized"
+	Forbidden          = "forbidden"
+	AccountSuspended   = "account_suspended"
+	AccountMuted       = "account_muted" // 制裁（mute）中はメッセージを送れない
+	NotFound           = "not_found"
+	UnauthorizedRoom   = "unauthorized_room"
+	RoomRevoked        = "room_revoked"
+	ContentRequired    = "content_required"
+	ContentTooLong     = "content_too_long"
+	UnsupportedType    = "unsupported_message_type"
+	AttachmentRequired = "attachment_required"
+	InvalidClientMsgID = "invalid_client_msg_id"
+	ClientMsgIDInUse   = "client_msg_id_in_use"
+	MessageDeleted     = "message_deleted"
+	FileTooLarge       = "file_too_large"
+	UnsupportedFile    = "unsupported_file_type"
+	ImageTooLarge      = "image_too_large"        // 画素数が上限を超える画像
+	AttachmentUnusable = "attachment_unavailable" // 未確認・他人の添付
+	UploadIncomplete   = "upload_incomplete"      // 直接アップロードが見つからない・サイズ不一致
+	QuotaExceeded      = "storage_quota_exceeded" // 保存容量の上限を超える
+	MalwareDetected    = "malware_detected"       // ウイルス検査で検出された添付
+	ScanUnavailable    = "scan_unavailable"       // ウイルス検査ができず添付を受け付けられない
+	NotAllowed         = "operation_not_allowed"  // 自分自身・スタッフへの操作など、対象や状態の都合で受け付けない
+	Conflict           = "conflict"               // 既に存在する・実行中など、現在の状態と重なる
+	UnknownEvent       = "unknown_event"
+	Internal           = "internal_error"
+)
@@ -7,6 +7,7 @@ require (
 	github.com/gin-gonic/gin v1.10.1
 	github.com/golang-jwt/jwt/v5 v5.2.2
 	github.com/gorilla/websocket v1.5.3
+	golang.org/x/image v0.24.0
 	google.golang.org/api v0.236.0
 	gorm.io/driver/mysql v1.6.0
 	gorm.io/gorm v1.30.0
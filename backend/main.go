@@ -1,12 +1,23 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
 
+	"chillow/adminstream"
+	"chillow/broker"
 	"chillow/config"
+	"chillow/controller"
 	"chillow/db"
 	"chillow/model"
 	"chillow/router"
+	"chillow/scan"
 	"chillow/storage"
 )
 
@@ -21,6 +32,30 @@ func main() {
 		log.Fatalf("❌ ストレージ初期化失敗: %v", err)
 	}
 
+	// 添付のウイルス検査（既定は検査なし）
+	if err := scan.Init(config.Cfg); err != nil {
+		log.Fatalf("❌ ウイルス検査の初期化失敗: %v", err)
+	}
+
+	// 違反の回数に応じた制裁の段階
+	if err := model.LoadSanctionLadder(config.Cfg.SanctionLadder); err != nil {
+		log.Fatalf("❌ SANCTION_LADDER が不正です: %v", err)
+	}
+
+	// 保存・削除したオブジェクトをユーザーごとの使用量として記録する
+	storage.SetUsageRecorder(model.StorageUsageRecorder{})
+
+	// ノード間配信（WebSocket / 管理イベント）
+	if err := broker.Init(config.Cfg); err != nil {
+		log.Fatalf("❌ ブローカー初期化失敗: %v", err)
+	}
+	if err := adminstream.Init(broker.Default()); err != nil {
+		log.Fatalf("❌ 管理イベント購読失敗: %v", err)
+	}
+	if err := controller.StartRealtime(broker.Default()); err != nil {
+		log.Fatalf("❌ WebSocket Hub 起動失敗: %v", err)
+	}
+
 	// スキーマを最新化（ユーザー／友達／申請／メッセージ）
 	if err := db.DB.AutoMigrate(
 		&model.User{},
@@ -29,15 +64,104 @@ func main() {
 		&model.Message{},
 		&model.MessageRead{},
 		&model.Report{},
+		&model.ConversationSetting{},
+		&model.RoomSequence{},
+		&model.Attachment{},
+		&model.StoredObject{},
+		&model.ContentObject{},
+		&model.AuditLog{},
+		&model.LoginSession{},
+		&model.Sanction{},
 	); err != nil {
 		log.Fatalf("❌ AutoMigrate失敗: %v", err)
 	}
+	// ADMIN_EMAILS / SUPERADMIN_EMAILS の変更を既存アカウントにも反映する
+	if err := model.SyncConfiguredRoles(); err != nil {
+		log.Fatalf("❌ ロールの同期失敗: %v", err)
+	}
+	// 制裁の記録を導入する前の BAN を sanctions に移す
+	if err := model.MigrateLegacyBans(); err != nil {
+		log.Fatalf("❌ BAN の移行失敗: %v", err)
+	}
+
+	// シグナル受信で停止処理へ
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// バックグラウンドジョブ（停止時は jobsCtx をキャンセルして終了を待つ）
+	jobsCtx, cancelJobs := context.WithCancel(context.Background())
+	var jobs sync.WaitGroup
+
+	// 消えるメッセージの定期削除
+	jobs.Add(1)
+	go func() {
+		defer jobs.Done()
+		controller.RunRetentionSweeper(jobsCtx, config.Cfg.RetentionSweepInterval)
+	}()
+
+	// 使われなかったアップロードの定期削除
+	jobs.Add(1)
+	go func() {
+		defer jobs.Done()
+		controller.RunAttachmentSweeper(jobsCtx, config.Cfg.AttachmentSweepInterval)
+	}()
+
+	// どこからも参照されていないオブジェクトの回収
+	jobs.Add(1)
+	go func() {
+		defer jobs.Done()
+		controller.RunStorageGC(jobsCtx, config.Cfg.StorageGCInterval, config.Cfg.StorageGCGrace, config.Cfg.StorageGCDryRun)
+	}()
 
 	// ルーターの初期化
 	r := router.SetupRouter()
 
 	// サーバー起動（開発環境はHTTPで十分）
-	if err := r.Run(":8080"); err != nil {
+	srv := &http.Server{Addr: ":8080", Handler: r}
+	serverErr := make(chan error, 1)
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			serverErr <- err
+		}
+	}()
+
+	select {
+	case err := <-serverErr:
 		log.Fatalf("❌ サーバー起動失敗: %v", err)
+	case <-ctx.Done():
+	}
+	stop()
+	log.Println("ℹ️ シャットダウンを開始します")
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), config.Cfg.ShutdownTimeout)
+	defer cancel()
+
+	// WebSocket と SSE は Shutdown の待機対象にならない／終わらないため先に閉じる
+	controller.StopRealtime(shutdownCtx)
+	adminstream.Shutdown()
+
+	// 処理中のリクエストを待つ
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		log.Printf("⚠️ HTTP サーバーの停止がタイムアウトしました: %v", err)
+	}
+
+	cancelJobs()
+	jobsDone := make(chan struct{})
+	go func() {
+		jobs.Wait()
+		close(jobsDone)
+	}()
+	select {
+	case <-jobsDone:
+	case <-shutdownCtx.Done():
+		log.Println("⚠️ バックグラウンドジョブの停止を待たずに終了します")
+	}
+
+	if err := broker.Default().Close(); err != nil {
+		log.Printf("⚠️ ブローカー切断失敗: %v", err)
+	}
+	if err := db.Close(); err != nil {
+		log.Printf("⚠️ DB切断失敗: %v", err)
 	}
+	log.Println("ℹ️ シャットダウン完了")
 }
@@ -0,0 +1,323 @@
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math"
+	"time"
+)
+
+var ErrUnsupportedAudio = errors.New("unsupported audio content")
+
+// 受け付ける音声形式（中身から判定）
+type AudioInfo struct {
+	ContentType string
+	Ext         string
+	Duration    time.Duration // 読み取れなかった場合は 0
+}
+
+// ProbeAudio はマジックバイトから音声の形式を判定し、再生時間を読み取る（時間は取れる範囲で）
+func ProbeAudio(data []byte) (*AudioInfo, error) {
+	switch {
+	case len(data) >= 12 && string(data[:4]) == "RIFF" && string(data[8:12]) == "WAVE":
+		return &AudioInfo{ContentType: "audio/wav", Ext: ".wav", Duration: wavDuration(data)}, nil
+	case bytes.HasPrefix(data, []byte("OggS")):
+		return &AudioInfo{ContentType: "audio/ogg", Ext: ".ogg", Duration: oggDuration(data)}, nil
+	case len(data) >= 8 && string(data[4:8]) == "ftyp":
+		return &AudioInfo{ContentType: "audio/mp4", Ext: ".m4a", Duration: mp4Duration(data)}, nil
+	case bytes.HasPrefix(data, []byte{0x1A, 0x45, 0xDF, 0xA3}):
+		return &AudioInfo{ContentType: "audio/webm", Ext: ".webm", Duration: webmDuration(data)}, nil
+	case bytes.HasPrefix(data, []byte("ID3")) || (len(data) >= 2 && data[0] == 0xFF && data[1]&0xE0 == 0xE0):
+		return &AudioInfo{ContentType: "audio/mpeg", Ext: ".mp3", Duration: mp3Duration(data)}, nil
+	}
+	return nil, ErrUnsupportedAudio
+}
+
+func seconds(v float64) time.Duration {
+	if v <= 0 || math.IsNaN(v) || math.IsInf(v, 0) {
+		return 0
+	}
+	return time.Duration(v * float64(time.Second))
+}
+
+// ---- WAV: data チャンクの長さ ÷ fmt チャンクの byteRate ----
+
+func wavDuration(data []byte) time.Duration {
+	var byteRate, dataSize uint32
+	for i := 12; i+8 <= len(data); {
+		id := string(data[i : i+4])
+		size := binary.LittleEndian.Uint32(data[i+4:])
+		switch id {
+		case "fmt ":
+			if i+20 <= len(data) {
+				byteRate = binary.LittleEndian.Uint32(data[i+16:])
+			}
+		case "data":
+			dataSize = size
+		}
+		next := i + 8 + int(size) + int(size%2)
+		if next <= i || id == "data" {
+			break
+		}
+		i = next
+	}
+	if byteRate == 0 {
+		return 0
+	}
+	return seconds(float64(dataSize) / float64(byteRate))
+}
+
+// ---- Ogg（Opus / Vorbis）: 最終ページの granule position ÷ サンプルレート ----
+
+func oggDuration(data []byte) time.Duration {
+	// 最初のページの最初のパケットがコーデックのヘッダー
+	if len(data) < 27 {
+		return 0
+	}
+	headerStart := 27 + int(data[26])
+	if headerStart > len(data) {
+		return 0
+	}
+	head := data[headerStart:]
+	var rate float64
+	var preSkip int64
+	switch {
+	case bytes.HasPrefix(head, []byte("OpusHead")) && len(head) >= 12:
+		rate = 48000 // Opus の granule は常に 48kHz
+		preSkip = int64(binary.LittleEndian.Uint16(head[10:]))
+	case bytes.HasPrefix(head, []byte("\x01vorbis")) && len(head) >= 16:
+		rate = float64(binary.LittleEndian.Uint32(head[12:]))
+	default:
+		return 0
+	}
+
+	last := bytes.LastIndex(data, []byte("OggS"))
+	if last < 0 || last+14 > len(data) || rate == 0 {
+		return 0
+	}
+	granule := int64(binary.LittleEndian.Uint64(data[last+6:]))
+	return seconds(float64(granule-preSkip) / rate)
+}
+
+// ---- MP4 / M4A: moov > mvhd の duration ÷ timescale ----
+
+func mp4Duration(data []byte) time.Duration {
+	moov := findBox(data, "moov")
+	if moov == nil {
+		return 0
+	}
+	mvhd := findBox(moov, "mvhd")
+	if len(mvhd) < 4 {
+		return 0
+	}
+	if mvhd[0] == 1 {
+		if len(mvhd) < 32 {
+			return 0
+		}
+		timescale := binary.BigEndian.Uint32(mvhd[20:])
+		duration := binary.BigEndian.Uint64(mvhd[24:])
+		if timescale == 0 {
+			return 0
+		}
+		return seconds(float64(duration) / float64(timescale))
+	}
+	if len(mvhd) < 20 {
+		return 0
+	}
+	timescale := binary.BigEndian.Uint32(mvhd[12:])
+	duration := binary.BigEndian.Uint32(mvhd[16:])
+	if timescale == 0 {
+		return 0
+	}
+	return seconds(float64(duration) / float64(timescale))
+}
+
+// findBox は同じ階層から指定の種類のボックスを探し、中身を返す
+func findBox(data []byte, boxType string) []byte {
+	for i := 0; i+8 <= len(data); {
+		size := uint64(binary.BigEndian.Uint32(data[i:]))
+		header := 8
+		switch size {
+		case 0:
+			size = uint64(len(data) - i)
+		case 1:
+			if i+16 > len(data) {
+				return nil
+			}
+			size = binary.BigEndian.Uint64(data[i+8:])
+			header = 16
+		}
+		// 64bit のサイズは足し算であふれるので、残りの長さと比べる
+		if size < uint64(header) || size > uint64(len(data)-i) {
+			return nil
+		}
+		end := i + int(size)
+		if string(data[i+4:i+8]) == boxType {
+			return data[i+header : end]
+		}
+		i = end
+	}
+	return nil
+}
+
+// ---- WebM: Segment > Info の Duration × TimecodeScale ----
+// MediaRecorder の出力には Duration がないことが多く、その場合は 0
+
+const (
+	ebmlSegment       = 0x18538067
+	ebmlInfo          = 0x1549A966
+	ebmlCluster       = 0x1F43B675
+	ebmlTimecodeScale = 0x2AD7B1
+	ebmlDuration      = 0x4489
+)
+
+func webmDuration(data []byte) time.Duration {
+	// EBML ヘッダーを読み飛ばす
+	_, size, n := readEBMLElement(data)
+	if n == 0 || n+size > len(data) {
+		return 0
+	}
+	rest := data[n+size:]
+
+	id, size, n := readEBMLElement(rest)
+	if id != ebmlSegment || n == 0 {
+		return 0
+	}
+	segment := rest[n:]
+	if size >= 0 && size < len(segment) {
+		segment = segment[:size]
+	}
+
+	for i := 0; i < len(segment); {
+		id, size, n := readEBMLElement(segment[i:])
+		if n == 0 || id == ebmlCluster || size < 0 || i+n+size > len(segment) {
+			return 0
+		}
+		if id != ebmlInfo {
+			i += n + size
+			continue
+		}
+		info := segment[i+n : i+n+size]
+		scale := 1_000_000.0 // 既定の TimecodeScale（ナノ秒）
+		duration := 0.0
+		for j := 0; j < len(info); {
+			cid, csize, cn := readEBMLElement(info[j:])
+			if cn == 0 || csize < 0 || j+cn+csize > len(info) {
+				break
+			}
+			body := info[j+cn : j+cn+csize]
+			switch cid {
+			case ebmlTimecodeScale:
+				var v uint64
+				for _, b := range body {
+					v = v<<8 | uint64(b)
+				}
+				scale = float64(v)
+			case ebmlDuration:
+				switch len(body) {
+				case 4:
+					duration = float64(math.Float32frombits(binary.BigEndian.Uint32(body)))
+				case 8:
+					duration = math.Float64frombits(binary.BigEndian.Uint64(body))
+				}
+			}
+			j += cn + csize
+		}
+		return time.Duration(duration * scale)
+	}
+	return 0
+}
+
+// readEBMLElement は要素 ID とサイズ（不明なら -1）、ヘッダーの長さを返す。読めなければ長さ 0
+func readEBMLElement(data []byte) (id uint32, size int, n int) {
+	idLen := vintLength(data)
+	if idLen == 0 || idLen > 4 || idLen >= len(data) {
+		return 0, 0, 0
+	}
+	for _, b := range data[:idLen] {
+		id = id<<8 | uint32(b)
+	}
+	sizeLen := vintLength(data[idLen:])
+	if sizeLen == 0 || idLen+sizeLen > len(data) {
+		return 0, 0, 0
+	}
+	raw := uint64(data[idLen] & (0xFF >> sizeLen))
+	allOnes := raw == uint64(0xFF>>sizeLen)
+	for _, b := range data[idLen+1 : idLen+sizeLen] {
+		raw = raw<<8 | uint64(b)
+		allOnes = allOnes && b == 0xFF
+	}
+	if allOnes {
+		return id, -1, idLen + sizeLen
+	}
+	if raw > uint64(math.MaxInt32) {
+		return 0, 0, 0
+	}
+	return id, int(raw), idLen + sizeLen
+}
+
+func vintLength(data []byte) int {
+	if len(data) == 0 || data[0] == 0 {
+		return 0
+	}
+	for i := 0; i < 8; i++ {
+		if data[0]&(0x80>>i) != 0 {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+// ---- MP3: Layer III のフレームを数える（VBR でも正確） ----
+
+var (
+	mp3BitratesV1 = [15]int{0, 32, 40, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320}
+	mp3BitratesV2 = [15]int{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160}
+	mp3Rates      = map[byte][3]int{
+		3: {44100, 48000, 32000}, // MPEG1
+		2: {22050, 24000, 16000}, // MPEG2
+		0: {11025, 12000, 8000},  // MPEG2.5
+	}
+)
+
+func mp3Duration(data []byte) time.Duration {
+	i := 0
+	if bytes.HasPrefix(data, []byte("ID3")) && len(data) >= 10 {
+		// ID3v2 のサイズは 7bit ずつの syncsafe 整数
+		size := int(data[6])<<21 | int(data[7])<<14 | int(data[8])<<7 | int(data[9])
+		i = 10 + size
+		if data[5]&0x10 != 0 {
+			i += 10
+		}
+	}
+
+	var total float64
+	for i+4 <= len(data) {
+		h := data[i : i+4]
+		if h[0] != 0xFF || h[1]&0xE0 != 0xE0 {
+			break
+		}
+		version := (h[1] >> 3) & 0x03
+		layer := (h[1] >> 1) & 0x03
+		bitrateIdx := h[2] >> 4
+		rateIdx := (h[2] >> 2) & 0x03
+		padding := int((h[2] >> 1) & 0x01)
+		rates, ok := mp3Rates[version]
+		if !ok || layer != 1 || bitrateIdx == 0 || bitrateIdx == 15 || rateIdx == 3 {
+			break
+		}
+		rate := rates[rateIdx]
+		var length, samples int
+		if version == 3 {
+			length = 144000*mp3BitratesV1[bitrateIdx]/rate + padding
+			samples = 1152
+		} else {
+			length = 72000*mp3BitratesV2[bitrateIdx]/rate + padding
+			samples = 576
+		}
+		total += float64(samples) / float64(rate)
+		i += length
+	}
+	return seconds(total)
+}
@@ -0,0 +1,119 @@
+package media
+
+import (
+	"encoding/binary"
+	"math"
+	"testing"
+	"time"
+)
+
+func le16(v uint16) []byte { return binary.LittleEndian.AppendUint16(nil, v) }
+func le32(v uint32) []byte { return binary.LittleEndian.AppendUint32(nil, v) }
+func be32(v uint32) []byte { return binary.BigEndian.AppendUint32(nil, v) }
+
+func join(parts ...[]byte) []byte {
+	var out []byte
+	for _, p := range parts {
+		out = append(out, p...)
+	}
+	return out
+}
+
+// 16bit モノラル 8kHz、data チャンク 2 秒分（中身は省略）
+func sampleWAV() []byte {
+	return join([]byte("RIFF"), le32(36), []byte("WAVEfmt "), le32(16),
+		le16(1), le16(1), le32(8000), le32(16000), le16(2), le16(16),
+		[]byte("data"), le32(32000))
+}
+
+// ftyp + moov > mvhd（version 0、timescale 1000、duration 3000）
+func sampleMP4() []byte {
+	mvhd := join(be32(28), []byte("mvhd"), make([]byte, 12), be32(1000), be32(3000))
+	return join(be32(16), []byte("ftypM4A "), make([]byte, 4), be32(uint32(8+len(mvhd))), []byte("moov"), mvhd)
+}
+
+// OpusHead のページと、granule position が 3 秒 + pre-skip の最終ページ
+func sampleOgg() []byte {
+	page := func(granule uint64, packet []byte) []byte {
+		return join([]byte("OggS"), []byte{0, 0}, binary.LittleEndian.AppendUint64(nil, granule),
+			make([]byte, 12), []byte{1, byte(len(packet))}, packet)
+	}
+	head := join([]byte("OpusHead"), []byte{1, 1}, le16(312), le32(48000), []byte{0, 0, 0})
+	return join(page(0, head), page(48000*3+312, []byte{0}))
+}
+
+// EBML ヘッダー + サイズ不明の Segment > Info（TimecodeScale 1ms、Duration 2500）
+func sampleWebM() []byte {
+	info := join([]byte{0x2A, 0xD7, 0xB1, 0x83, 0x0F, 0x42, 0x40},
+		[]byte{0x44, 0x89, 0x84}, binary.BigEndian.AppendUint32(nil, math.Float32bits(2500)))
+	return join([]byte{0x1A, 0x45, 0xDF, 0xA3, 0x80},
+		[]byte{0x18, 0x53, 0x80, 0x67, 0x01, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF},
+		[]byte{0x15, 0x49, 0xA9, 0x66, byte(0x80 | len(info))}, info)
+}
+
+func TestProbeAudioDuration(t *testing.T) {
+	tests := []struct {
+		name        string
+		data        []byte
+		contentType string
+		duration    time.Duration
+	}{
+		{"wav", sampleWAV(), "audio/wav", 2 * time.Second},
+		{"mp4", sampleMP4(), "audio/mp4", 3 * time.Second},
+		{"ogg", sampleOgg(), "audio/ogg", 3 * time.Second},
+		{"webm", sampleWebM(), "audio/webm", 2500 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info, err := ProbeAudio(tt.data)
+			if err != nil {
+				t.Fatalf("ProbeAudio: %v", err)
+			}
+			if info.ContentType != tt.contentType || info.Duration != tt.duration {
+				t.Errorf("got %s %v, want %s %v", info.ContentType, info.Duration, tt.contentType, tt.duration)
+			}
+		})
+	}
+}
+
+// 途中で切れたヘッダーや壊れたサイズでも panic せず、時間 0 で返す
+func TestProbeAudioTruncated(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"wav fmt chunk cut", join([]byte("RIFF????WAVEfmt "), make([]byte, 12))},
+		{"wav huge chunk", join([]byte("RIFF"), le32(0), []byte("WAVEJUNK"), le32(math.MaxUint32), []byte("fmt "))},
+		{"mp4 64bit size overflow", join(be32(16), []byte("ftypM4A "), make([]byte, 4),
+			be32(1), []byte("moov"), binary.BigEndian.AppendUint64(nil, math.MaxInt64-4))},
+		{"mp4 64bit size above MaxInt", join(be32(16), []byte("ftypM4A "), make([]byte, 4),
+			be32(1), []byte("moov"), binary.BigEndian.AppendUint64(nil, math.MaxUint64))},
+		{"mp4 mvhd cut", join(be32(16), []byte("ftypM4A "), make([]byte, 4), be32(20), []byte("moov"), be32(12), []byte("mvhd"), []byte{1, 0, 0, 0})},
+		{"ogg segment table beyond end", join([]byte("OggS"), make([]byte, 22), []byte{255})},
+		{"ogg last page cut", join(sampleOgg(), []byte("OggS\x00\x00"))},
+		{"webm element size beyond end", []byte{0x1A, 0x45, 0xDF, 0xA3, 0x08, 0x7F, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF}},
+		{"webm info size beyond end", join([]byte{0x1A, 0x45, 0xDF, 0xA3, 0x80, 0x18, 0x53, 0x80, 0x67, 0xFF, 0x15, 0x49, 0xA9, 0x66, 0x10, 0x2A})},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info, err := ProbeAudio(tt.data)
+			if err != nil {
+				t.Fatalf("ProbeAudio: %v", err)
+			}
+			if info.Duration != 0 {
+				t.Errorf("duration = %v, want 0", info.Duration)
+			}
+		})
+	}
+
+	// 正しいファイルをどこで切っても panic しない
+	for name, data := range map[string][]byte{"wav": sampleWAV(), "mp4": sampleMP4(), "ogg": sampleOgg(), "webm": sampleWebM()} {
+		t.Run(name+" prefixes", func(t *testing.T) {
+			for n := range len(data) {
+				if info, err := ProbeAudio(data[:n]); err == nil && info.Duration != 0 && n < len(data)/2 {
+					t.Errorf("%d bytes: duration %v from a cut header", n, info.Duration)
+				}
+			}
+		})
+	}
+}
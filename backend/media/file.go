@@ -0,0 +1,31 @@
+package media
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+)
+
+var ErrBlockedFile = errors.New("file type is not allowed")
+
+// 実行ファイルやブラウザで開くと動作するものは中身で弾く（拡張子を変えても通さない）
+var blockedSignatures = [][]byte{
+	[]byte("MZ"),               // Windows 実行ファイル
+	[]byte("\x7fELF"),          // Linux 実行ファイル
+	[]byte("\xcf\xfa\xed\xfe"), // Mach-O
+	[]byte("\xca\xfe\xba\xbe"), // Mach-O (fat) / Java class
+}
+
+// InspectFile は汎用ファイルの中身を確認し、保存時の Content-Type を返す
+func InspectFile(data []byte) (string, error) {
+	for _, sig := range blockedSignatures {
+		if bytes.HasPrefix(data, sig) {
+			return "", ErrBlockedFile
+		}
+	}
+	contentType := DetectContentType(data)
+	if strings.HasPrefix(contentType, "text/html") {
+		return "", ErrBlockedFile
+	}
+	return contentType, nil
+}
@@ -0,0 +1,86 @@
+package media
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	stddraw "image/draw"
+	_ "image/gif"
+	"image/jpeg"
+	_ "image/png"
+
+	"chillow/storage"
+
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+const (
+	// 長辺の最大ピクセル数。元画像がこれ以下ならそのバリアントは作らない（元画像を使う）
+	thumbMaxSide   = 320
+	mediumMaxSide  = 1280
+	variantQuality = 80
+
+	blurhashMaxSide = 32
+	blurhashX       = 4
+	blurhashY       = 3
+)
+
+type Variant struct {
+	Name string
+	Data []byte // JPEG
+}
+
+type ProcessedImage struct {
+	Width    int
+	Height   int
+	Blurhash string
+	Variants []Variant
+}
+
+// ProcessImage は画像の寸法を読み取り、縮小版とプレースホルダーを生成する。
+// 画素数の上限確認は SanitizeImage で済ませたデータを渡すこと。
+func ProcessImage(data []byte) (*ProcessedImage, error) {
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	bounds := src.Bounds()
+	out := &ProcessedImage{Width: bounds.Dx(), Height: bounds.Dy()}
+
+	for _, v := range []struct {
+		name    string
+		maxSide int
+	}{
+		{storage.VariantThumb, thumbMaxSide},
+		{storage.VariantMedium, mediumMaxSide},
+	} {
+		if max(out.Width, out.Height) <= v.maxSide {
+			continue
+		}
+		var buf bytes.Buffer
+		if err := jpeg.Encode(&buf, resize(src, v.maxSide, draw.CatmullRom), &jpeg.Options{Quality: variantQuality}); err != nil {
+			return nil, err
+		}
+		out.Variants = append(out.Variants, Variant{Name: v.name, Data: buf.Bytes()})
+	}
+
+	out.Blurhash = Blurhash(resize(src, blurhashMaxSide, draw.ApproxBiLinear), blurhashX, blurhashY)
+	return out, nil
+}
+
+// resize は長辺を maxSide に収めた画像を返す。透過部分は白で塗る（JPEG で黒くならないように）
+func resize(src image.Image, maxSide int, scaler draw.Scaler) image.Image {
+	b := src.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w >= h && w > maxSide {
+		w, h = maxSide, max(1, h*maxSide/b.Dx())
+	} else if h > w && h > maxSide {
+		w, h = max(1, w*maxSide/b.Dy()), maxSide
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	stddraw.Draw(dst, dst.Bounds(), image.NewUniform(color.White), image.Point{}, stddraw.Src)
+	scaler.Scale(dst, dst.Bounds(), src, b, draw.Over, nil)
+	return dst
+}
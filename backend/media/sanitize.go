@@ -0,0 +1,298 @@
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/jpeg"
+	"net/http"
+)
+
+var (
+	ErrUnsupportedImage = errors.New("unsupported image content")
+	ErrTooManyPixels    = errors.New("image has too many pixels")
+	errMalformed        = errors.New("malformed image")
+)
+
+// 受け付ける画像形式（中身から判定した MIME → 保存時の拡張子）
+var imageExtensions = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/gif":  ".gif",
+	"image/webp": ".webp",
+}
+
+// 向きを反映して再エンコードするときの画質
+const reencodeQuality = 90
+
+// hardMaxPixels は設定に関わらず超えない画素数の上限（maxPixels が 0 以下・大きすぎる場合もこれで止める）
+const hardMaxPixels = 100_000_000
+
+type SanitizedImage struct {
+	Data        []byte
+	ContentType string // 中身から判定した MIME
+	Ext         string
+}
+
+// DetectContentType は先頭のマジックバイトから MIME を判定する（拡張子や申告値は使わない）
+func DetectContentType(data []byte) string {
+	return http.DetectContentType(data)
+}
+
+// SanitizeImage は中身から形式を判定し、寸法を確認してから位置情報などのメタデータを取り除く。
+// 画質を保つため、向きの補正が必要な JPEG 以外は再エンコードせずチャンク単位で削除する。
+// maxPixels は hardMaxPixels を超えられない。
+func SanitizeImage(data []byte, maxPixels int) (*SanitizedImage, error) {
+	if maxPixels <= 0 || maxPixels > hardMaxPixels {
+		maxPixels = hardMaxPixels
+	}
+	contentType := DetectContentType(data)
+	ext, ok := imageExtensions[contentType]
+	if !ok {
+		return nil, ErrUnsupportedImage
+	}
+	// 展開前にヘッダーの寸法だけで判定する（解凍爆弾対策）
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrUnsupportedImage
+	}
+	if cfg.Width <= 0 || cfg.Height <= 0 || int64(cfg.Width)*int64(cfg.Height) > int64(maxPixels) {
+		return nil, ErrTooManyPixels
+	}
+
+	var clean []byte
+	switch contentType {
+	case "image/jpeg":
+		clean, err = sanitizeJPEG(data)
+	case "image/png":
+		clean, err = stripPNG(data)
+	case "image/webp":
+		clean, err = stripWebP(data)
+	default:
+		// GIF は EXIF を持たない
+		clean = data
+	}
+	if err != nil {
+		return nil, ErrUnsupportedImage
+	}
+	return &SanitizedImage{Data: clean, ContentType: contentType, Ext: ext}, nil
+}
+
+// ---- JPEG ----
+
+// sanitizeJPEG は APP1（EXIF/XMP）・APP3〜13・APP15・COM を削除する。
+// APP0（JFIF）・APP2（ICC）・APP14（Adobe）は色の再現に必要なので残す。
+func sanitizeJPEG(data []byte) ([]byte, error) {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return nil, errMalformed
+	}
+	out := bytes.NewBuffer(make([]byte, 0, len(data)))
+	out.Write(data[:2])
+	orientation := 1
+
+	i := 2
+	for i+2 <= len(data) {
+		if data[i] != 0xFF {
+			return nil, errMalformed
+		}
+		marker := data[i+1]
+		switch {
+		case marker == 0xFF: // 詰め物
+			i++
+			continue
+		case marker == 0xDA: // SOS 以降は画像データ
+			out.Write(data[i:])
+			i = len(data)
+			continue
+		case marker == 0xD9 || marker == 0x01 || (marker >= 0xD0 && marker <= 0xD7): // 長さを持たないマーカー
+			out.Write(data[i : i+2])
+			i += 2
+			continue
+		}
+		if i+4 > len(data) {
+			return nil, errMalformed
+		}
+		end := i + 2 + int(binary.BigEndian.Uint16(data[i+2:]))
+		if end < i+4 || end > len(data) {
+			return nil, errMalformed
+		}
+		segment := data[i:end]
+		switch {
+		case marker == 0xE1:
+			if o := exifOrientation(segment[4:]); o > 0 {
+				orientation = o
+			}
+		case marker == 0xFE, marker == 0xEF, marker >= 0xE3 && marker <= 0xED:
+		default:
+			out.Write(segment)
+		}
+		i = end
+	}
+
+	if orientation <= 1 || orientation > 8 {
+		return out.Bytes(), nil
+	}
+	// 向きは EXIF と一緒に消えるので、画素を回転して保存し直す
+	img, err := jpeg.Decode(bytes.NewReader(out.Bytes()))
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, applyOrientation(img, orientation), &jpeg.Options{Quality: reencodeQuality}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// exifOrientation は APP1 の中身から Orientation（0x0112）を読む。見つからなければ 0
+func exifOrientation(payload []byte) int {
+	if len(payload) < 14 || string(payload[:6]) != "Exif\x00\x00" {
+		return 0
+	}
+	tiff := payload[6:]
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 0
+	}
+	offset := int(order.Uint32(tiff[4:8]))
+	if offset < 8 || offset+2 > len(tiff) {
+		return 0
+	}
+	count := int(order.Uint16(tiff[offset:]))
+	for n := 0; n < count; n++ {
+		entry := offset + 2 + n*12
+		if entry+12 > len(tiff) {
+			return 0
+		}
+		if order.Uint16(tiff[entry:]) == 0x0112 {
+			return int(order.Uint16(tiff[entry+8:]))
+		}
+	}
+	return 0
+}
+
+// applyOrientation は EXIF の Orientation（2〜8）に従って画素を並べ替える
+func applyOrientation(src image.Image, orientation int) image.Image {
+	b := src.Bounds()
+	w, h := b.Dx(), b.Dy()
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			dx, dy := x, y
+			switch orientation {
+			case 2:
+				dx, dy = w-1-x, y
+			case 3:
+				dx, dy = w-1-x, h-1-y
+			case 4:
+				dx, dy = x, h-1-y
+			case 5:
+				dx, dy = y, x
+			case 6:
+				dx, dy = h-1-y, x
+			case 7:
+				dx, dy = h-1-y, w-1-x
+			case 8:
+				dx, dy = y, w-1-x
+			}
+			dst.Set(dx, dy, src.At(b.Min.X+x, b.Min.Y+y))
+		}
+	}
+	return dst
+}
+
+// ---- PNG ----
+
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+var pngMetadataChunks = map[string]bool{
+	"eXIf": true,
+	"tEXt": true,
+	"zTXt": true,
+	"iTXt": true,
+	"tIME": true,
+}
+
+func stripPNG(data []byte) ([]byte, error) {
+	if !bytes.HasPrefix(data, pngSignature) {
+		return nil, errMalformed
+	}
+	out := bytes.NewBuffer(make([]byte, 0, len(data)))
+	out.Write(pngSignature)
+	for i := len(pngSignature); i < len(data); {
+		if i+8 > len(data) {
+			return nil, errMalformed
+		}
+		length := int(binary.BigEndian.Uint32(data[i:]))
+		chunkType := string(data[i+4 : i+8])
+		end := i + 12 + length // 長さ・種類・データ・CRC
+		if length < 0 || end > len(data) {
+			return nil, errMalformed
+		}
+		if !pngMetadataChunks[chunkType] {
+			out.Write(data[i:end])
+		}
+		i = end
+		if chunkType == "IEND" {
+			break
+		}
+	}
+	return out.Bytes(), nil
+}
+
+// ---- WebP ----
+
+const (
+	webpFlagXMP  = 0x04
+	webpFlagEXIF = 0x08
+)
+
+func stripWebP(data []byte) ([]byte, error) {
+	if len(data) < 12 || string(data[:4]) != "RIFF" || string(data[8:12]) != "WEBP" {
+		return nil, errMalformed
+	}
+	var body bytes.Buffer
+	for i := 12; i < len(data); {
+		if i+8 > len(data) {
+			return nil, errMalformed
+		}
+		fourcc := string(data[i : i+4])
+		size := int(binary.LittleEndian.Uint32(data[i+4:]))
+		end := i + 8 + size + size%2 // チャンクは偶数長に詰められる
+		if end > len(data) {
+			if i+8+size != len(data) {
+				return nil, errMalformed
+			}
+			end = len(data)
+		}
+		switch fourcc {
+		case "EXIF", "XMP ":
+		case "VP8X":
+			chunk := append([]byte(nil), data[i:end]...)
+			if len(chunk) > 8 {
+				chunk[8] &^= webpFlagEXIF | webpFlagXMP
+			}
+			body.Write(chunk)
+		default:
+			body.Write(data[i:end])
+		}
+		i = end
+	}
+
+	out := bytes.NewBuffer(make([]byte, 0, 12+body.Len()))
+	out.WriteString("RIFF")
+	_ = binary.Write(out, binary.LittleEndian, uint32(4+body.Len()))
+	out.WriteString("WEBP")
+	out.Write(body.Bytes())
+	return out.Bytes(), nil
+}
@@ -0,0 +1,229 @@
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"testing"
+
+	"golang.org/x/image/webp"
+)
+
+func be16(v uint16) []byte { return binary.BigEndian.AppendUint16(nil, v) }
+
+// twoColorImage は左半分が赤、右半分が青の画像（JPEG の劣化があっても向きを確かめられる大きさ）
+func twoColorImage(w, h int) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			c := color.RGBA{255, 0, 0, 255}
+			if x >= w/2 {
+				c = color.RGBA{0, 0, 255, 255}
+			}
+			img.Set(x, y, c)
+		}
+	}
+	return img
+}
+
+func encodeJPEG(t *testing.T, img image.Image) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 95}); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func encodePNG(t *testing.T, img image.Image) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// exifAPP1 は Orientation と、測地系（GPSMapDatum = "WGS-84"）を持つ GPS IFD を含む APP1 セグメント（リトルエンディアン）
+func exifAPP1(orientation uint16) []byte {
+	ifd0 := join(le16(2),
+		le16(0x0112), le16(3), le32(1), le16(orientation), le16(0), // Orientation
+		le16(0x8825), le16(4), le32(1), le32(38), // GPS IFD へのオフセット
+		le32(0))
+	gps := join(le16(1), le16(0x0012), le16(2), le32(8), le32(56), le32(0), []byte("WGS-84\x00\x00"))
+	payload := join([]byte("Exif\x00\x00II"), le16(42), le32(8), ifd0, gps)
+	return join([]byte{0xFF, 0xE1}, be16(uint16(len(payload)+2)), payload)
+}
+
+func jpegSegment(marker byte, payload []byte) []byte {
+	return join([]byte{0xFF, marker}, be16(uint16(len(payload)+2)), payload)
+}
+
+// insertAfterSOI は JPEG の SOI の直後にセグメントを挟む
+func insertAfterSOI(data []byte, segments ...[]byte) []byte {
+	return join(append(append([][]byte{data[:2]}, segments...), data[2:])...)
+}
+
+func pngChunk(chunkType string, payload []byte) []byte {
+	body := join([]byte(chunkType), payload)
+	return join(be32(uint32(len(payload))), body, be32(crc32.ChecksumIEEE(body)))
+}
+
+// insertAfterIHDR は PNG の IHDR の直後にチャンクを挟む
+func insertAfterIHDR(data []byte, chunks ...[]byte) []byte {
+	at := len(pngSignature) + 8 + 13 + 4
+	return join(append(append([][]byte{data[:at]}, chunks...), data[at:])...)
+}
+
+// 1x1 の可逆圧縮 WebP（VP8L）のビットストリーム
+var vp8l1x1 = []byte{0x2F, 0x00, 0x00, 0x00, 0x10, 0x07, 0x10, 0x11, 0x11, 0x88, 0x88, 0xFE, 0x07, 0x00}
+
+func riffChunk(fourcc string, payload []byte) []byte {
+	out := join([]byte(fourcc), le32(uint32(len(payload))), payload)
+	if len(payload)%2 == 1 {
+		out = append(out, 0)
+	}
+	return out
+}
+
+func webpFile(chunks ...[]byte) []byte {
+	body := join(chunks...)
+	return join([]byte("RIFF"), le32(uint32(4+len(body))), []byte("WEBP"), body)
+}
+
+// vp8x は 1x1 のキャンバスと flags を持つ VP8X チャンク
+func vp8x(flags byte) []byte {
+	return riffChunk("VP8X", []byte{flags, 0, 0, 0, 0, 0, 0, 0, 0, 0})
+}
+
+// isRed は JPEG の劣化を許して赤に近いかどうか
+func isRed(c color.Color) bool {
+	r, g, b, _ := c.RGBA()
+	return r > 0xC000 && g < 0x4000 && b < 0x4000
+}
+
+func isBlue(c color.Color) bool {
+	r, g, b, _ := c.RGBA()
+	return r < 0x4000 && g < 0x4000 && b > 0xC000
+}
+
+func TestSanitizeImage(t *testing.T) {
+	plainJPEG := encodeJPEG(t, twoColorImage(32, 16))
+	icc := jpegSegment(0xE2, []byte("ICC_PROFILE\x00\x01\x01dummy"))
+	plainPNG := encodePNG(t, twoColorImage(4, 4))
+	cleanWebP := webpFile(vp8x(0), riffChunk("VP8L", vp8l1x1))
+
+	// SOF0 の直後で切った JPEG（寸法は読めるがセグメントが途中で終わる）
+	sof := bytes.Index(plainJPEG, []byte{0xFF, 0xC0})
+	truncatedJPEG := plainJPEG[:sof+2+int(binary.BigEndian.Uint16(plainJPEG[sof+2:]))+6]
+
+	tests := []struct {
+		name        string
+		data        []byte
+		maxPixels   int // 0 なら hardMaxPixels
+		err         error
+		contentType string
+		want        []byte // nil なら check で確かめる
+		check       func(t *testing.T, out []byte)
+	}{
+		{
+			name:        "jpeg gps removed without re-encoding",
+			data:        insertAfterSOI(plainJPEG, exifAPP1(1), jpegSegment(0xFE, []byte("comment"))),
+			contentType: "image/jpeg",
+			want:        plainJPEG,
+		},
+		{
+			name:        "jpeg icc kept",
+			data:        insertAfterSOI(plainJPEG, icc, exifAPP1(1)),
+			contentType: "image/jpeg",
+			want:        insertAfterSOI(plainJPEG, icc),
+		},
+		{
+			name:        "jpeg orientation 6 rotated",
+			data:        insertAfterSOI(plainJPEG, exifAPP1(6)),
+			contentType: "image/jpeg",
+			check: func(t *testing.T, out []byte) {
+				if bytes.Contains(out, []byte("WGS-84")) || bytes.Contains(out, []byte("Exif\x00\x00")) {
+					t.Error("EXIF left in output")
+				}
+				img, err := jpeg.Decode(bytes.NewReader(out))
+				if err != nil {
+					t.Fatal(err)
+				}
+				// 時計回りに 90 度回すと、左の赤が上、右の青が下になる
+				if b := img.Bounds(); b.Dx() != 16 || b.Dy() != 32 {
+					t.Fatalf("size = %dx%d, want 16x32", b.Dx(), b.Dy())
+				}
+				if !isRed(img.At(8, 4)) || !isBlue(img.At(8, 28)) {
+					t.Errorf("top = %v, bottom = %v; want red on top and blue at the bottom", img.At(8, 4), img.At(8, 28))
+				}
+			},
+		},
+		{
+			name:        "png exif and text removed",
+			data:        insertAfterIHDR(plainPNG, pngChunk("eXIf", exifAPP1(1)[10:]), pngChunk("tEXt", []byte("Comment\x00taken at home"))),
+			contentType: "image/png",
+			want:        plainPNG,
+		},
+		{
+			name:        "webp exif and xmp removed",
+			data:        webpFile(vp8x(webpFlagEXIF|webpFlagXMP), riffChunk("VP8L", vp8l1x1), riffChunk("EXIF", exifAPP1(1)[10:]), riffChunk("XMP ", []byte("<x:xmpmeta/>"))),
+			contentType: "image/webp",
+			want:        cleanWebP,
+			check: func(t *testing.T, out []byte) {
+				if _, err := webp.Decode(bytes.NewReader(out)); err != nil {
+					t.Errorf("output does not decode: %v", err)
+				}
+			},
+		},
+		{
+			name:        "webp odd-sized last chunk without padding",
+			data:        webpFile(vp8x(webpFlagXMP), riffChunk("VP8L", vp8l1x1), []byte("XMP "), le32(3), []byte("<x>")),
+			contentType: "image/webp",
+			want:        cleanWebP,
+		},
+		{name: "too many pixels", data: plainJPEG, maxPixels: 32*16 - 1, err: ErrTooManyPixels},
+		{
+			// 上限が 0 以下でも hardMaxPixels で止める（IHDR だけなので展開はしない）
+			name:      "hard ceiling without a limit",
+			data:      join(pngSignature, pngChunk("IHDR", join(be32(20000), be32(20000), []byte{8, 2, 0, 0, 0}))),
+			maxPixels: 0,
+			err:       ErrTooManyPixels,
+		},
+		{name: "not an image", data: []byte("hello, world"), err: ErrUnsupportedImage},
+		{name: "jpeg truncated", data: truncatedJPEG, err: ErrUnsupportedImage},
+		{name: "jpeg segment overruns", data: insertAfterSOI(plainJPEG[:sof], []byte{0xFF, 0xE1, 0xFF, 0xFF}), err: ErrUnsupportedImage},
+		{name: "png truncated after IHDR", data: plainPNG[:len(pngSignature)+25+6], err: ErrUnsupportedImage},
+		{name: "png chunk overruns", data: insertAfterIHDR(plainPNG, join(be32(1<<20), []byte("tEXt"))), err: ErrUnsupportedImage},
+		{name: "webp chunk overruns", data: webpFile(vp8x(0), riffChunk("VP8L", vp8l1x1), []byte("EXIF"), le32(1000), []byte("xx")), err: ErrUnsupportedImage},
+		{name: "webp truncated header", data: webpFile(vp8x(0), riffChunk("VP8L", vp8l1x1), []byte("EX")), err: ErrUnsupportedImage},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := SanitizeImage(tt.data, tt.maxPixels)
+			if tt.err != nil {
+				if !errors.Is(err, tt.err) {
+					t.Fatalf("err = %v, want %v", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("SanitizeImage: %v", err)
+			}
+			if got.ContentType != tt.contentType || got.Ext != imageExtensions[tt.contentType] {
+				t.Errorf("content type = %q, ext = %q", got.ContentType, got.Ext)
+			}
+			if tt.want != nil && !bytes.Equal(got.Data, tt.want) {
+				t.Errorf("output differs from the image without metadata (%d bytes, want %d)", len(got.Data), len(tt.want))
+			}
+			if tt.check != nil {
+				tt.check(t, got.Data)
+			}
+		})
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"time"
 
 	"chillow/db"
+	"chillow/errcode"
 	"chillow/model"
 	authsvc "chillow/service/auth"
 
@@ -23,33 +24,34 @@ func AuthMiddleware() gin.HandlerFunc {
 		}
 
 		if tokenStr == "" {
-			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "認証情報がありません"})
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "認証情報がありません", "code": errcode.Unauthorized})
 			return
 		}
 
 		claims, err := authsvc.ParseAccessToken(tokenStr)
 		if err != nil {
-			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "無効なトークンです"})
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "無効なトークンです", "code": errcode.Unauthorized})
 			return
 		}
 
 		var user model.User
 		if err := db.DB.First(&user, claims.UserID).Error; err != nil {
 			if err == gorm.ErrRecordNotFound {
-				c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "ユーザー情報が存在しません"})
+				c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "ユーザー情報が存在しません", "code": errcode.Unauthorized})
 				return
 			}
-			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "ユーザー情報を取得できません"})
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "ユーザー情報を取得できません", "code": errcode.Internal})
 			return
 		}
 
-		if user.ShouldLiftBan(time.Now()) {
-			user.ClearBan()
-			_ = db.DB.Save(&user).Error
+		// BAN は sanctions を正とする（期限切れ・解除済みなら表示用の項目も戻る）
+		ban, err := model.CurrentBan(&user, time.Now())
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "ユーザー情報を取得できません", "code": errcode.Internal})
+			return
 		}
-
-		if user.IsBanned {
-			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "アカウントは利用停止中です"})
+		if ban != nil {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "アカウントは利用停止中です", "code": errcode.AccountSuspended, "banned_until": ban.ExpiresAt})
 			return
 		}
 
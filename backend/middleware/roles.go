@@ -3,6 +3,9 @@ package middleware
 import (
 	"net/http"
 
+	"chillow/errcode"
+	"chillow/model"
+
 	"github.com/gin-gonic/gin"
 )
 
@@ -15,12 +18,12 @@ func RequireRoles(roles ...string) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		roleVal, exists := c.Get("user_role")
 		if !exists {
-			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "権限がありません"})
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "権限がありません", "code": errcode.Forbidden})
 			return
 		}
 		role, _ := roleVal.(string)
 		if _, ok := allowed[role]; !ok {
-			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "権限がありません"})
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "権限がありません", "code": errcode.Forbidden})
 			return
 		}
 		c.Next()
@@ -35,14 +38,28 @@ func ForbidRoles(roles ...string) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		roleVal, exists := c.Get("user_role")
 		if !exists {
-			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "権限がありません"})
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "権限がありません", "code": errcode.Forbidden})
 			return
 		}
 		role, _ := roleVal.(string)
 		if _, ok := blocked[role]; ok {
-			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "権限がありません"})
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "権限がありません", "code": errcode.Forbidden})
 			return
 		}
 		c.Next()
 	}
 }
+
+// RequirePermission はロールに指定した権限がすべて含まれる場合のみ通す
+func RequirePermission(perms ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		role := c.GetString("user_role")
+		for _, perm := range perms {
+			if !model.RoleHasPermission(role, perm) {
+				c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "権限がありません", "code": errcode.Forbidden, "permission": perm})
+				return
+			}
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,107 @@
+package model
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"chillow/db"
+
+	"gorm.io/gorm"
+)
+
+// 添付の状態
+const (
+	AttachmentPending = "pending" // 署名付き URL を発行済み、アップロード未確認
+	AttachmentReady   = "ready"   // ストレージ上の存在を確認済み
+)
+
+// 添付の種類（メッセージの message_type と同じ値）
+const (
+	AttachmentKindImage = "image"
+	AttachmentKindFile  = "file"
+	AttachmentKindAudio = "audio"
+)
+
+var ErrAttachmentUnavailable = errors.New("attachment is not available")
+
+type Attachment struct {
+	ID          uint       `json:"id" gorm:"primaryKey"`
+	OwnerID     uint       `json:"owner_id" gorm:"index"`
+	ObjectKey   string     `json:"object_key" gorm:"type:varchar(255);index"` // 内容アドレスのキーは同じ中身の添付で共有する
+	Kind        string     `json:"kind" gorm:"type:varchar(20);default:'image'"`
+	Filename    string     `json:"filename" gorm:"type:varchar(255)"` // アップロード時の元のファイル名
+	ContentType string     `json:"content_type" gorm:"type:varchar(100)"`
+	Size        int64      `json:"size"`
+	Status      string     `json:"status" gorm:"type:varchar(20);index"`
+	MessageID   *uint      `json:"message_id,omitempty" gorm:"index"` // 使用したメッセージ（未使用なら nil）
+	Width       int        `json:"width"`
+	Height      int        `json:"height"`
+	Blurhash    string     `json:"blurhash" gorm:"type:varchar(64)"`
+	Variants    string     `json:"variants" gorm:"type:varchar(64)"`                    // 生成済みのバリアント名（カンマ区切り）
+	DurationMs  int64      `json:"duration_ms"`                                         // 音声の再生時間（読み取れなければ 0）
+	ContentHash string     `json:"-" gorm:"type:varchar(64);not null;default:'';index"` // 保存した中身の SHA-256（ContentObject への参照。空なら内容アドレス導入前の添付）
+	SourceHash  string     `json:"-" gorm:"type:varchar(64);not null;default:'';index"` // アップロードされた中身の SHA-256（画像は無害化前）
+	ExpiresAt   *time.Time `json:"expires_at,omitempty" gorm:"index"`                   // 未使用のまま残せる期限（使用後は nil）
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+}
+
+func hasVariant(variants, name string) bool {
+	for _, v := range strings.Split(variants, ",") {
+		if v == name {
+			return true
+		}
+	}
+	return false
+}
+
+// FindUsableAttachment はメッセージに添付できる（本人がアップロード済みで未使用、種類が一致する）添付を返す
+func FindUsableAttachment(ownerID, attachmentID uint, kind string) (*Attachment, error) {
+	var att Attachment
+	err := db.DB.Where("id = ? AND owner_id = ?", attachmentID, ownerID).First(&att).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrAttachmentUnavailable
+	}
+	if err != nil {
+		return nil, err
+	}
+	if att.Status != AttachmentReady || att.MessageID != nil || att.Kind != kind {
+		return nil, ErrAttachmentUnavailable
+	}
+	return &att, nil
+}
+
+// CreateMessageWithAttachment はメッセージの保存と添付の使用済み化を同じトランザクションで行う。
+// 同じ添付が同時に使われた場合は後から来た方が ErrAttachmentUnavailable になる。
+func CreateMessageWithAttachment(msg *Message, att *Attachment) error {
+	if att == nil {
+		return db.DB.Create(msg).Error
+	}
+	return db.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(msg).Error; err != nil {
+			return err
+		}
+		res := tx.Model(&Attachment{}).
+			Where("id = ? AND owner_id = ? AND status = ? AND message_id IS NULL", att.ID, msg.SenderID, AttachmentReady).
+			Updates(map[string]interface{}{"message_id": msg.ID, "expires_at": nil})
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return ErrAttachmentUnavailable
+		}
+		return nil
+	})
+}
+
+// ExpiredAttachments は未使用のまま期限を過ぎた添付を返す
+func ExpiredAttachments(now time.Time, limit int) ([]Attachment, error) {
+	var atts []Attachment
+	err := db.DB.
+		Where("message_id IS NULL AND expires_at IS NOT NULL AND expires_at <= ?", now).
+		Order("id ASC").
+		Limit(limit).
+		Find(&atts).Error
+	return atts, err
+}
@@ -0,0 +1,143 @@
+package model
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// 監査ログの操作
+const (
+	AuditUserBan        = "user.ban"
+	AuditUserUnban      = "user.unban"
+	AuditReportResolve  = "report.resolve"
+	AuditStorageQuota   = "user.storage_quota"
+	AuditStorageGC      = "storage.gc"
+	AuditUserRole       = "user.role"
+	AuditUserSanction   = "user.sanction"   // warning / mute（BAN は user.ban）
+	AuditSanctionRevoke = "sanction.revoke" // 誤った制裁の取り消し
+)
+
+// 監査ログの対象
+const (
+	AuditTargetUser     = "user"
+	AuditTargetReport   = "report"
+	AuditTargetStorage  = "storage"
+	AuditTargetSanction = "sanction"
+)
+
+var ErrAuditLogImmutable = errors.New("audit log is append-only")
+
+// AuditLog は管理者による変更の記録。追記のみで、更新・削除はしない
+type AuditLog struct {
+	ID         uint            `json:"id" gorm:"primaryKey"`
+	ActorID    uint            `json:"actor_id" gorm:"index"`
+	Action     string          `json:"action" gorm:"type:varchar(50);index"`
+	TargetType string          `json:"target_type" gorm:"type:varchar(20);index:idx_audit_logs_target"`
+	TargetID   uint            `json:"target_id" gorm:"index:idx_audit_logs_target"`
+	Before     json.RawMessage `json:"before" gorm:"type:json"` // 変更前の状態（作成・実行のみの操作は null）
+	After      json.RawMessage `json:"after" gorm:"type:json"`
+	Reason     string          `json:"reason" gorm:"type:text"`
+	IP         string          `json:"ip" gorm:"type:varchar(45)"`
+	CreatedAt  time.Time       `json:"created_at" gorm:"index"`
+	Actor      *User           `json:"actor,omitempty" gorm:"foreignKey:ActorID"`
+}
+
+func (AuditLog) BeforeUpdate(*gorm.DB) error { return ErrAuditLogImmutable }
+
+func (AuditLog) BeforeDelete(*gorm.DB) error { return ErrAuditLogImmutable }
+
+// RecordAudit は変更と同じトランザクションで監査ログを追加する（before / after は JSON にして保存する）
+func RecordAudit(tx *gorm.DB, entry *AuditLog, before, after interface{}) error {
+	var err error
+	if entry.Before, err = auditSnapshot(before); err != nil {
+		return err
+	}
+	if entry.After, err = auditSnapshot(after); err != nil {
+		return err
+	}
+	return tx.Create(entry).Error
+}
+
+func auditSnapshot(v interface{}) (json.RawMessage, error) {
+	if v == nil {
+		return nil, nil
+	}
+	return json.Marshal(v)
+}
+
+// UserModerationState は監査ログに残すユーザーの管理項目
+type UserModerationState struct {
+	IsBanned          bool       `json:"is_banned"`
+	BannedAt          *time.Time `json:"banned_at"`
+	BanReason         *string    `json:"ban_reason"`
+	BanExpiresAt      *time.Time `json:"ban_expires_at"`
+	StorageQuotaBytes *int64     `json:"storage_quota_bytes"`
+}
+
+func (u User) ModerationState() UserModerationState {
+	return UserModerationState{
+		IsBanned:          u.IsBanned,
+		BannedAt:          u.BannedAt,
+		BanReason:         u.BanReason,
+		BanExpiresAt:      u.BanExpiresAt,
+		StorageQuotaBytes: u.StorageQuotaBytes,
+	}
+}
+
+// ReportState は監査ログに残す通報の処理状況
+type ReportState struct {
+	Status         string     `json:"status"`
+	Resolution     *string    `json:"resolution"`
+	ResolutionNote *string    `json:"resolution_note"`
+	HandledBy      *uint      `json:"handled_by"`
+	HandledAt      *time.Time `json:"handled_at"`
+}
+
+func (r Report) State() ReportState {
+	return ReportState{
+		Status:         r.Status,
+		Resolution:     r.Resolution,
+		ResolutionNote: r.ResolutionNote,
+		HandledBy:      r.HandledBy,
+		HandledAt:      r.HandledAt,
+	}
+}
+
+// AuditFilter は監査ログの検索条件（ゼロ値の項目は絞り込まない）
+type AuditFilter struct {
+	ActorID    uint
+	Action     string
+	TargetType string
+	TargetID   uint
+	Since      *time.Time
+	Until      *time.Time
+	BeforeID   uint // この ID より古いものだけ（ページング）
+}
+
+func (f AuditFilter) Apply(q *gorm.DB) *gorm.DB {
+	if f.ActorID != 0 {
+		q = q.Where("actor_id = ?", f.ActorID)
+	}
+	if f.Action != "" {
+		q = q.Where("action = ?", f.Action)
+	}
+	if f.TargetType != "" {
+		q = q.Where("target_type = ?", f.TargetType)
+	}
+	if f.TargetID != 0 {
+		q = q.Where("target_id = ?", f.TargetID)
+	}
+	if f.Since != nil {
+		q = q.Where("created_at >= ?", *f.Since)
+	}
+	if f.Until != nil {
+		q = q.Where("created_at < ?", *f.Until)
+	}
+	if f.BeforeID != 0 {
+		q = q.Where("id < ?", f.BeforeID)
+	}
+	return q
+}
@@ -0,0 +1,223 @@
+package model
+
+import (
+	"errors"
+	"log"
+	"time"
+
+	"chillow/db"
+	"chillow/storage"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ContentObject は内容アドレスで保存したオブジェクト（同じ中身・種類・形式は 1 つだけ保存し、添付レコードから参照を数える）
+type ContentObject struct {
+	ObjectKey   string    `json:"object_key" gorm:"primaryKey;type:varchar(255)"` // storage.ContentObjectKey（中身・種類・拡張子から決まる）
+	Hash        string    `json:"hash" gorm:"type:char(64);index"`
+	Kind        string    `json:"kind" gorm:"type:varchar(20);not null;default:''"`
+	ContentType string    `json:"content_type" gorm:"type:varchar(100)"`
+	Size        int64     `json:"size"`
+	RefCount    int       `json:"ref_count"` // この内容を指す添付レコードの数（0 になったらコミット後に削除する）
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+var ErrContentGone = errors.New("content object is no longer stored")
+
+// AcquireStoredContent は att.ObjectKey の保存済みの内容への参照を 1 つ増やし、att に中身の情報を写す。
+// 内容がない（最後の参照が外れて削除された）場合は ErrContentGone を返すので、RegisterContent で保存し直す。
+// tx の中で添付レコードを作成・更新すること。
+func AcquireStoredContent(tx *gorm.DB, att *Attachment) error {
+	var obj ContentObject
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("object_key = ? AND ref_count > 0", att.ObjectKey).
+		First(&obj).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrContentGone
+	}
+	if err != nil {
+		return err
+	}
+	if err := tx.Model(&obj).Update("ref_count", gorm.Expr("ref_count + 1")).Error; err != nil {
+		return err
+	}
+	att.ContentType = obj.ContentType
+	att.Size = obj.Size
+
+	// 寸法・プレースホルダー・縮小版・再生時間は同じオブジェクトを使う同じ種類の添付から引き継ぐ
+	var prev Attachment
+	err = tx.Where("object_key = ? AND kind = ? AND id <> ?", att.ObjectKey, att.Kind, att.ID).Order("id DESC").First(&prev).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+	if err == nil {
+		att.Width, att.Height = prev.Width, prev.Height
+		att.Blurhash = prev.Blurhash
+		att.Variants = prev.Variants
+		att.DurationMs = prev.DurationMs
+	}
+	return nil
+}
+
+// RegisterContent は保存したオブジェクトを参照 1 で登録する（登録済みなら参照を足す）。
+// ほかに参照がない（最初の参照になった）ときは true を返す。保存から登録までの間に purgeContent が
+// 同じキーのオブジェクトを削除していることがあるので、呼び出し側で存在を確かめること（行ロック中なので以降は削除されない）。
+// tx の中で添付レコードを作成・更新すること。
+func RegisterContent(tx *gorm.DB, att *Attachment) (bool, error) {
+	obj := ContentObject{
+		ObjectKey:   att.ObjectKey,
+		Hash:        att.ContentHash,
+		Kind:        att.Kind,
+		ContentType: att.ContentType,
+		Size:        att.Size,
+		RefCount:    1,
+		CreatedAt:   time.Now(),
+	}
+	err := tx.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "object_key"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{"ref_count": gorm.Expr("ref_count + 1")}),
+	}).Create(&obj).Error
+	if err != nil {
+		return false, err
+	}
+	var refCount int
+	if err := tx.Model(&ContentObject{}).Where("object_key = ?", att.ObjectKey).Select("ref_count").Scan(&refCount).Error; err != nil {
+		return false, err
+	}
+	return refCount == 1, nil
+}
+
+// DiscardUnregisteredContent は保存したものの登録できなかったオブジェクトを、ほかに参照がなければ削除する
+func DiscardUnregisteredContent(objectKey string) {
+	purgeContent(objectKey)
+}
+
+// releaseContent は参照を 1 つ減らす。最後の参照なら true を返すので、コミット後に purgeContent で削除する
+// （tx がロールバックしても、残った記録が削除済みのオブジェクトを指さないように）
+func releaseContent(tx *gorm.DB, objectKey string) (bool, error) {
+	var obj ContentObject
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("object_key = ?", objectKey).First(&obj).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if err := tx.Model(&obj).Update("ref_count", gorm.Expr("ref_count - 1")).Error; err != nil {
+		return false, err
+	}
+	return obj.RefCount <= 1, nil
+}
+
+// purgeContent は参照が 0 のままならオブジェクト（縮小版を含む）と記録を削除する。
+// 行ロックを持ったまま削除し、同じ内容を登録し直すアップロード（RegisterContent）と順番に処理する。
+// 記録がなければオブジェクトだけ削除する。削除できなかったものは参照 0 の記録として残り（再利用はされない）、オブジェクトは storage gc が回収する
+func purgeContent(objectKey string) {
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		var obj ContentObject
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("object_key = ?", objectKey).First(&obj).Error
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+		if err == nil && obj.RefCount > 0 {
+			return nil
+		}
+		if err := storage.Default().Delete(objectKey); err != nil && !errors.Is(err, storage.ErrNotFound) {
+			return err
+		}
+		if obj.ObjectKey == "" {
+			return nil
+		}
+		return tx.Delete(&obj).Error
+	})
+	if err != nil {
+		log.Printf("⚠️ failed to delete content object %s: %v", objectKey, err)
+	}
+}
+
+// ReleaseUnusedAttachment はメッセージに使われていない添付のレコードを削除して中身を手放す。
+// 削除と同時にメッセージから使われた場合は何もせず false を返す。
+func ReleaseUnusedAttachment(att *Attachment) (bool, error) {
+	released, last := false, false
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		res := tx.Where("id = ? AND message_id IS NULL", att.ID).Delete(&Attachment{})
+		if res.Error != nil || res.RowsAffected == 0 {
+			return res.Error
+		}
+		released = true
+		if att.ContentHash != "" {
+			var err error
+			last, err = releaseContent(tx, att.ObjectKey)
+			return err
+		}
+		return nil
+	})
+	if err != nil || !released {
+		return false, err
+	}
+	if last {
+		purgeContent(att.ObjectKey)
+	}
+	if att.ContentHash == "" {
+		if err := storage.Default().Delete(att.ObjectKey); err != nil && !errors.Is(err, storage.ErrNotFound) {
+			log.Printf("⚠️ failed to delete unused attachment %s: %v", att.ObjectKey, err)
+		}
+	}
+	return true, nil
+}
+
+// ReleaseMessageAttachment はメッセージに使った添付を手放す。
+// 内容アドレスの添付はレコードを削除して参照を減らし（2 回目以降は何もしない）、
+// それ以前に保存された添付は objectKey を直接削除する。
+func ReleaseMessageAttachment(messageID uint, objectKey string) error {
+	var att Attachment
+	err := db.DB.Where("message_id = ? AND content_hash <> ''", messageID).First(&att).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		if storage.IsContentObjectKey(objectKey) {
+			return nil
+		}
+		return storage.Default().Delete(objectKey)
+	}
+	if err != nil {
+		return err
+	}
+	last := false
+	err = db.DB.Transaction(func(tx *gorm.DB) error {
+		res := tx.Where("id = ?", att.ID).Delete(&Attachment{})
+		if res.Error != nil || res.RowsAffected == 0 {
+			return res.Error
+		}
+		var err error
+		last, err = releaseContent(tx, att.ObjectKey)
+		return err
+	})
+	if err == nil && last {
+		purgeContent(att.ObjectKey)
+	}
+	return err
+}
+
+// ReferencedContentKeys は参照が残っている内容アドレスのオブジェクトキー
+func ReferencedContentKeys() ([]string, error) {
+	var keys []string
+	err := db.DB.Model(&ContentObject{}).Where("ref_count > 0").Pluck("object_key", &keys).Error
+	return keys, err
+}
+
+// FindOwnedContent はユーザーが以前にアップロードした同じ中身（アップロード時の SHA-256 が一致する）の添付を返す。
+// 他のユーザーの添付は対象にしない（中身を持っていない相手に存在を知らせないため）。
+func FindOwnedContent(ownerID uint, sourceHash, kind string) (*Attachment, error) {
+	var att Attachment
+	err := db.DB.
+		Where("owner_id = ? AND source_hash = ? AND kind = ? AND content_hash <> '' AND status = ?", ownerID, sourceHash, kind, AttachmentReady).
+		Order("id DESC").
+		First(&att).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &att, nil
+}
@@ -0,0 +1,50 @@
+package model
+
+import (
+	"chillow/db"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ConversationSetting は 2 ユーザー間の会話ごとの設定（小さい ID を Low に正規化）
+type ConversationSetting struct {
+	ID               uint      `gorm:"primaryKey" json:"id"`
+	UserLowID        uint      `gorm:"uniqueIndex:ux_conversation_pair" json:"user_low_id"`
+	UserHighID       uint      `gorm:"uniqueIndex:ux_conversation_pair" json:"user_high_id"`
+	RetentionSeconds int64     `json:"retention_seconds"` // 0 は無期限
+	UpdatedBy        uint      `json:"updated_by"`
+	CreatedAt        time.Time `json:"created_at"`
+	UpdatedAt        time.Time `json:"updated_at"`
+}
+
+func ConversationPair(userA, userB uint) (uint, uint) {
+	if userA < userB {
+		return userA, userB
+	}
+	return userB, userA
+}
+
+// 会話設定を取得（未設定ならゼロ値を返す）
+func FindConversationSetting(userA, userB uint) (ConversationSetting, error) {
+	low, high := ConversationPair(userA, userB)
+	var setting ConversationSetting
+	err := db.DB.Where("user_low_id = ? AND user_high_id = ?", low, high).First(&setting).Error
+	if err == gorm.ErrRecordNotFound {
+		return ConversationSetting{UserLowID: low, UserHighID: high}, nil
+	}
+	return setting, err
+}
+
+// 新規メッセージの有効期限を会話の保持期間から算出
+func MessageExpiry(senderID, receiverID uint, sentAt time.Time) (*time.Time, error) {
+	setting, err := FindConversationSetting(senderID, receiverID)
+	if err != nil {
+		return nil, err
+	}
+	if setting.RetentionSeconds <= 0 {
+		return nil, nil
+	}
+	expires := sentAt.Add(time.Duration(setting.RetentionSeconds) * time.Second)
+	return &expires, nil
+}
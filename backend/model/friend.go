@@ -51,3 +51,9 @@ func AreFriends(userID1, userID2 uint) (bool, error) {
 	return err == nil, err
 }
 
+// 友達のユーザーID一覧
+func FriendIDs(userID uint) ([]uint, error) {
+	var ids []uint
+	err := db.DB.Model(&Friend{}).Where("user_id = ?", userID).Pluck("friend_id", &ids).Error
+	return ids, err
+}
@@ -0,0 +1,39 @@
+package model
+
+import (
+	"strings"
+	"time"
+
+	"chillow/db"
+)
+
+// ユーザーごとに残すログイン履歴の件数
+const loginSessionKeep = 50
+
+// LoginSession はログインの記録（管理画面でアカウントの利用状況を確認する）
+type LoginSession struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`
+	UserID    uint      `json:"user_id" gorm:"index"`
+	IP        string    `json:"ip" gorm:"type:varchar(45)"`
+	UserAgent string    `json:"user_agent" gorm:"type:varchar(255)"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// RecordLoginSession はログインを記録し、古い履歴を間引く
+func RecordLoginSession(userID uint, ip, userAgent string) error {
+	if len(userAgent) > 255 {
+		userAgent = strings.ToValidUTF8(userAgent[:255], "")
+	}
+	if err := db.DB.Create(&LoginSession{UserID: userID, IP: ip, UserAgent: userAgent}).Error; err != nil {
+		return err
+	}
+	// 残す中で最も古い記録より前のものを消す
+	var cutoff []uint
+	if err := db.DB.Model(&LoginSession{}).Where("user_id = ?", userID).Order("id DESC").Offset(loginSessionKeep-1).Limit(1).Pluck("id", &cutoff).Error; err != nil {
+		return err
+	}
+	if len(cutoff) == 0 {
+		return nil
+	}
+	return db.DB.Where("user_id = ? AND id < ?", userID, cutoff[0]).Delete(&LoginSession{}).Error
+}
@@ -1,19 +1,135 @@
 package model
 
-import "time"
+import (
+	"chillow/config"
+	"chillow/db"
+	"chillow/storage"
+	"fmt"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const MaxClientMsgIDLength = 64
 
 type Message struct {
-	ID            uint       `gorm:"primaryKey" json:"id"`
-	SenderID      uint       `json:"sender_id"`
-	ReceiverID    uint       `json:"receiver_id"`
-	Content       string     `json:"content"`
-	MessageType   string     `gorm:"type:varchar(20);default:'text'" json:"message_type"`
-	AttachmentURL *string    `json:"attachment_url"`
-	AttachmentObj *string    `json:"attachment_object"`
-	IsRead        bool       `json:"is_read"`
-	IsDeleted     bool       `json:"is_deleted"`
-	EditedAt      *time.Time `json:"edited_at"`
-	DeletedAt     *time.Time `json:"deleted_at"`
-	CreatedAt     time.Time  `json:"created_at"`
-	UpdatedAt     time.Time  `json:"updated_at"`
+	ID            uint    `gorm:"primaryKey" json:"id"`
+	SenderID      uint    `gorm:"uniqueIndex:ux_sender_client_msg" json:"sender_id"`
+	ReceiverID    uint    `json:"receiver_id"`
+	Content       string  `json:"content"`
+	MessageType   string  `gorm:"type:varchar(20);default:'text'" json:"message_type"`
+	AttachmentURL *string `json:"attachment_url"`
+	AttachmentObj *string `json:"attachment_object"`
+	AttachmentID  *uint   `json:"attachment_id,omitempty" gorm:"index"`
+	// 画像の寸法・プレースホルダー・縮小版（添付の記録から複製）
+	AttachmentWidth     *int    `json:"attachment_width,omitempty"`
+	AttachmentHeight    *int    `json:"attachment_height,omitempty"`
+	AttachmentBlurhash  *string `gorm:"type:varchar(64)" json:"attachment_blurhash,omitempty"`
+	AttachmentVariants  *string `gorm:"type:varchar(64)" json:"-"` // 生成済みのバリアント名（カンマ区切り）
+	AttachmentThumbURL  *string `gorm:"-" json:"attachment_thumb_url,omitempty"`
+	AttachmentMediumURL *string `gorm:"-" json:"attachment_medium_url,omitempty"`
+	// ファイル・音声
+	AttachmentName       *string    `gorm:"type:varchar(255)" json:"attachment_name,omitempty"`
+	AttachmentSize       *int64     `json:"attachment_size,omitempty"`
+	AttachmentDurationMs *int64     `json:"attachment_duration_ms,omitempty"`
+	IsRead               bool       `json:"is_read"`
+	IsDeleted            bool       `json:"is_deleted"`
+	EditedAt             *time.Time `json:"edited_at"`
+	DeletedAt            *time.Time `json:"deleted_at"`
+	ExpiresAt            *time.Time `gorm:"index" json:"expires_at"`
+	ClientMsgID          *string    `gorm:"type:varchar(64);uniqueIndex:ux_sender_client_msg" json:"client_msg_id"`
+	Seq                  uint64     `gorm:"index" json:"seq"` // この行に最後に反映されたルームイベントの通番
+	CreatedAt            time.Time  `json:"created_at"`
+	UpdatedAt            time.Time  `json:"updated_at"`
+}
+
+// AttachmentAccessURL は添付を取得する API の URL（参加者のみ、期限付き URL へリダイレクト）
+func AttachmentAccessURL(messageID uint) string {
+	return fmt.Sprintf("%s/api/messages/attachments/%d", strings.TrimRight(config.Cfg.BackendURL, "/"), messageID)
+}
+
+// AttachmentVariantURL は縮小版（storage.VariantThumb など）を取得する URL
+func AttachmentVariantURL(messageID uint, variant string) string {
+	return AttachmentAccessURL(messageID) + "?variant=" + variant
+}
+
+// WithAccessURL は保存済みの添付 URL をアクセス制御付きの URL に置き換えたコピーを返す
+func (m Message) WithAccessURL() Message {
+	if m.AttachmentObj != nil && *m.AttachmentObj != "" {
+		url := AttachmentAccessURL(m.ID)
+		m.AttachmentURL = &url
+		if m.HasVariant(storage.VariantThumb) {
+			thumb := AttachmentVariantURL(m.ID, storage.VariantThumb)
+			m.AttachmentThumbURL = &thumb
+		}
+		if m.HasVariant(storage.VariantMedium) {
+			medium := AttachmentVariantURL(m.ID, storage.VariantMedium)
+			m.AttachmentMediumURL = &medium
+		}
+	}
+	return m
+}
+
+func (m Message) HasVariant(name string) bool {
+	return m.AttachmentVariants != nil && hasVariant(*m.AttachmentVariants, name)
+}
+
+// SetAttachment は添付の記録からメッセージの添付列を埋める（location はストレージ上の保存先）
+func (m *Message) SetAttachment(att *Attachment, location string) {
+	m.AttachmentID = &att.ID
+	m.AttachmentObj = &att.ObjectKey
+	m.AttachmentURL = &location
+	if att.Width > 0 && att.Height > 0 {
+		width, height := att.Width, att.Height
+		m.AttachmentWidth = &width
+		m.AttachmentHeight = &height
+	}
+	if att.Blurhash != "" {
+		blurhash := att.Blurhash
+		m.AttachmentBlurhash = &blurhash
+	}
+	if att.Variants != "" {
+		variants := att.Variants
+		m.AttachmentVariants = &variants
+	}
+	if att.Filename != "" {
+		name := att.Filename
+		m.AttachmentName = &name
+	}
+	size := att.Size
+	m.AttachmentSize = &size
+	if att.DurationMs > 0 {
+		duration := att.DurationMs
+		m.AttachmentDurationMs = &duration
+	}
+}
+
+// ClearAttachment はストレージから削除した添付の列を空にする
+func (m *Message) ClearAttachment() {
+	m.AttachmentURL = nil
+	m.AttachmentObj = nil
+	m.AttachmentWidth = nil
+	m.AttachmentHeight = nil
+	m.AttachmentBlurhash = nil
+	m.AttachmentVariants = nil
+	m.AttachmentName = nil
+	m.AttachmentSize = nil
+	m.AttachmentDurationMs = nil
+}
+
+// 送信者ごとのクライアント採番IDで既存メッセージを探す（なければ nil）
+func FindMessageByClientID(senderID uint, clientMsgID string) (*Message, error) {
+	if clientMsgID == "" {
+		return nil, nil
+	}
+	var msg Message
+	err := db.DB.Where("sender_id = ? AND client_msg_id = ?", senderID, clientMsgID).First(&msg).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &msg, nil
 }
@@ -1,6 +1,14 @@
 package model
 
-import "time"
+import (
+	"chillow/config"
+	"chillow/db"
+	"fmt"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
 
 type Report struct {
 	ID             uint       `gorm:"primaryKey" json:"id"`
@@ -11,6 +19,8 @@ type Report struct {
 	MessageType    string     `json:"message_type"`
 	AttachmentURL  *string    `json:"attachment_url"`
 	AttachmentObj  *string    `json:"attachment_object"`
+	AttachmentName *string    `json:"attachment_name,omitempty" gorm:"type:varchar(255)"`
+	AttachmentSize *int64     `json:"attachment_size,omitempty"`
 	Reason         string     `json:"reason"`
 	Status         string     `gorm:"type:varchar(20);default:'pending'" json:"status"`
 	Resolution     *string    `json:"resolution"`
@@ -23,3 +33,28 @@ type Report struct {
 	ReportedUser   User       `gorm:"foreignKey:ReportedUserID" json:"reported_user"`
 	HandledByUser  *User      `gorm:"foreignKey:HandledBy" json:"handled_by_user,omitempty"`
 }
+
+// WithAccessURL は証拠の添付 URL を管理者用の取得 API に置き換えたコピーを返す
+func (r Report) WithAccessURL() Report {
+	if r.AttachmentObj != nil && *r.AttachmentObj != "" {
+		url := fmt.Sprintf("%s/api/admin/reports/%d/attachment", strings.TrimRight(config.Cfg.BackendURL, "/"), r.ID)
+		r.AttachmentURL = &url
+	}
+	return r
+}
+
+// 未処理の通報が付いているメッセージIDのサブクエリ
+func PendingReportMessageIDs() *gorm.DB {
+	return db.DB.Model(&Report{}).Select("message_id").Where("status = ?", "pending")
+}
+
+func HasPendingReports(messageID uint) (bool, error) {
+	if messageID == 0 {
+		return false, nil
+	}
+	var count int64
+	if err := db.DB.Model(&Report{}).Where("message_id = ? AND status = ?", messageID, "pending").Count(&count).Error; err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
@@ -0,0 +1,151 @@
+package model
+
+import (
+	"log"
+	"strings"
+
+	"chillow/config"
+	"chillow/db"
+)
+
+// ロール（user 以外は運営スタッフで、チャット機能は使えない）
+const (
+	RoleUser       = "user"
+	RoleModerator  = "moderator"
+	RoleAdmin      = "admin"
+	RoleSuperadmin = "superadmin"
+)
+
+// ロールの付与元
+const (
+	RoleSourceConfig = "config" // ADMIN_EMAILS / SUPERADMIN_EMAILS（設定から外れたら user に戻る）
+	RoleSourceManual = "manual" // 管理 API で付与
+)
+
+// 権限
+const (
+	PermReportsView       = "reports.view"
+	PermReportsResolve    = "reports.resolve"
+	PermUsersView         = "users.view" // ユーザーの検索と詳細
+	PermUsersBan          = "users.ban"  // 期限付き BAN と解除
+	PermUsersBanPermanent = "users.ban_permanent"
+	PermStorageManage     = "storage.manage"
+	PermAuditView         = "audit.view"
+	PermSystemView        = "system.view" // WebSocket の接続状況など
+	PermRolesManage       = "roles.manage"
+)
+
+var moderatorPermissions = []string{PermReportsView, PermReportsResolve, PermUsersView, PermUsersBan}
+
+var adminPermissions = append(append([]string{}, moderatorPermissions...), PermUsersBanPermanent, PermStorageManage, PermAuditView, PermSystemView)
+
+var rolePermissions = map[string][]string{
+	RoleModerator:  moderatorPermissions,
+	RoleAdmin:      adminPermissions,
+	RoleSuperadmin: append(append([]string{}, adminPermissions...), PermRolesManage),
+}
+
+// StaffRoles は運営スタッフのロール（権限の少ない順）
+func StaffRoles() []string {
+	return []string{RoleModerator, RoleAdmin, RoleSuperadmin}
+}
+
+func IsStaffRole(role string) bool {
+	_, ok := rolePermissions[role]
+	return ok
+}
+
+func ValidRole(role string) bool {
+	return role == RoleUser || IsStaffRole(role)
+}
+
+func RoleHasPermission(role, perm string) bool {
+	for _, p := range rolePermissions[role] {
+		if p == perm {
+			return true
+		}
+	}
+	return false
+}
+
+// RolePermissions はロールに含まれる権限の一覧（user は空）
+func RolePermissions(role string) []string {
+	return append([]string{}, rolePermissions[role]...)
+}
+
+// configuredRole は ADMIN_EMAILS / SUPERADMIN_EMAILS に載っているメールアドレスのロールを返す
+func configuredRole(email string) (string, bool) {
+	if config.Cfg == nil {
+		return "", false
+	}
+	needle := strings.ToLower(strings.TrimSpace(email))
+	if needle == "" {
+		return "", false
+	}
+	for _, list := range []struct {
+		role   string
+		emails []string
+	}{
+		{RoleSuperadmin, config.Cfg.SuperadminEmails},
+		{RoleAdmin, config.Cfg.AdminEmails},
+	} {
+		for _, candidate := range list.emails {
+			if strings.ToLower(strings.TrimSpace(candidate)) == needle {
+				return list.role, true
+			}
+		}
+	}
+	return "", false
+}
+
+// applyConfiguredRole は設定のロールを反映する。変更があれば true を返す（保存は呼び出し側）。
+// 設定に載っていればそのロールにし、設定由来のロールで設定から外れたものは user に戻す。
+// 付与元が空のスタッフは設定由来（ロール管理の導入前は設定でしか付与できなかった）とみなす。
+func (u *User) applyConfiguredRole() bool {
+	if role, ok := configuredRole(u.Email); ok {
+		if u.Role == role && u.RoleSource == RoleSourceConfig {
+			return false
+		}
+		u.Role = role
+		u.RoleSource = RoleSourceConfig
+		return true
+	}
+	if u.RoleSource == RoleSourceConfig || (u.RoleSource == "" && u.Role != RoleUser) {
+		u.Role = RoleUser
+		u.RoleSource = ""
+		return true
+	}
+	return false
+}
+
+// SyncConfiguredRoles は既存アカウントにも ADMIN_EMAILS / SUPERADMIN_EMAILS の変更を反映する（起動時に実行）
+func SyncConfiguredRoles() error {
+	var users []User
+	q := db.DB.Where("role_source = ? OR (role_source = '' AND role <> ?)", RoleSourceConfig, RoleUser)
+	if config.Cfg != nil {
+		var emails []string
+		for _, e := range append(append([]string{}, config.Cfg.AdminEmails...), config.Cfg.SuperadminEmails...) {
+			emails = append(emails, strings.ToLower(strings.TrimSpace(e)))
+		}
+		if len(emails) > 0 {
+			q = q.Or("LOWER(email) IN ?", emails)
+		}
+	}
+	if err := q.Find(&users).Error; err != nil {
+		return err
+	}
+	for i := range users {
+		u := &users[i]
+		prev := u.Role
+		if !u.applyConfiguredRole() {
+			continue
+		}
+		if err := db.DB.Model(u).Updates(map[string]interface{}{"role": u.Role, "role_source": u.RoleSource}).Error; err != nil {
+			return err
+		}
+		if prev != u.Role {
+			log.Printf("ℹ️ role of user %d changed by config: %s → %s", u.ID, prev, u.Role)
+		}
+	}
+	return nil
+}
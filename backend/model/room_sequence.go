@@ -0,0 +1,77 @@
+package model
+
+import (
+	"chillow/db"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// RoomSequence はルームごとのイベント通番。FloorSeq 未満の seq からは再送できない。
+type RoomSequence struct {
+	RoomID    string    `gorm:"primaryKey;type:varchar(64)" json:"room_id"`
+	Seq       uint64    `json:"seq"`
+	FloorSeq  uint64    `json:"floor_seq"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// 次の通番を採番（行ロックで単調増加を保証）
+func NextRoomSeq(roomID string) (uint64, error) {
+	var seq uint64
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		seq, err = nextRoomSeq(tx, roomID)
+		return err
+	})
+	return seq, err
+}
+
+// StampRoomSeq は通番を採番し、同じトランザクションでメッセージに記録する。
+// 行ロックをコミットまで持つため、後に採番した方が必ず後に書き込まれる（まとめて既読にした分は同じ通番）
+func StampRoomSeq(roomID string, messageIDs []uint) (uint64, error) {
+	var seq uint64
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		if seq, err = nextRoomSeq(tx, roomID); err != nil {
+			return err
+		}
+		if len(messageIDs) == 0 {
+			return nil
+		}
+		return tx.Model(&Message{}).Where("id IN ? AND seq < ?", messageIDs, seq).UpdateColumn("seq", seq).Error
+	})
+	return seq, err
+}
+
+func nextRoomSeq(tx *gorm.DB, roomID string) (uint64, error) {
+	var seq uint64
+	now := time.Now()
+	if err := tx.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "room_id"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{
+			"seq":        gorm.Expr("seq + 1"),
+			"updated_at": now,
+		}),
+	}).Create(&RoomSequence{RoomID: roomID, Seq: 1, UpdatedAt: now}).Error; err != nil {
+		return 0, err
+	}
+	err := tx.Model(&RoomSequence{}).Select("seq").Where("room_id = ?", roomID).Scan(&seq).Error
+	return seq, err
+}
+
+// 再送不能なイベント（物理削除など）以前への resume を打ち切る
+func RaiseRoomFloor(roomID string, seq uint64) error {
+	return db.DB.Model(&RoomSequence{}).
+		Where("room_id = ? AND floor_seq < ?", roomID, seq).
+		Update("floor_seq", seq).Error
+}
+
+func FindRoomSequence(roomID string) (RoomSequence, error) {
+	var rs RoomSequence
+	err := db.DB.Where("room_id = ?", roomID).First(&rs).Error
+	if err == gorm.ErrRecordNotFound {
+		return RoomSequence{RoomID: roomID}, nil
+	}
+	return rs, err
+}
@@ -0,0 +1,274 @@
+package model
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"strings"
+	"time"
+
+	"chillow/db"
+
+	"gorm.io/gorm"
+)
+
+// 制裁の種類
+const (
+	SanctionWarning = "warning"
+	SanctionMute    = "mute" // 期限までメッセージを送れない
+	SanctionTempBan = "temp_ban"
+	SanctionPermBan = "permanent_ban"
+)
+
+// Sanction はユーザーへの制裁の記録。解除しても消さずに revoked_at を入れる。
+// BAN 解除（早めに終えただけ）は違反の回数に数え、誤りとして取り消したもの（voided）は数えない
+type Sanction struct {
+	ID           uint       `json:"id" gorm:"primaryKey"`
+	UserID       uint       `json:"user_id" gorm:"index"`
+	Kind         string     `json:"kind" gorm:"type:varchar(20)"`
+	IssuedBy     *uint      `json:"issued_by"` // 移行した BAN など発行者が分からないものは null
+	Reason       string     `json:"reason" gorm:"type:text"`
+	ReportID     *uint      `json:"report_id,omitempty" gorm:"index"`
+	ExpiresAt    *time.Time `json:"expires_at"` // null は無期限（warning は効力を持たない）
+	RevokedAt    *time.Time `json:"revoked_at,omitempty"`
+	RevokedBy    *uint      `json:"revoked_by,omitempty"`
+	RevokeReason *string    `json:"revoke_reason,omitempty" gorm:"type:text"`
+	Voided       bool       `json:"voided" gorm:"not null;default:false"`
+	CreatedAt    time.Time  `json:"created_at" gorm:"index"`
+	Issuer       *User      `json:"issuer,omitempty" gorm:"foreignKey:IssuedBy"`
+}
+
+func ValidSanctionKind(kind string) bool {
+	switch kind {
+	case SanctionWarning, SanctionMute, SanctionTempBan, SanctionPermBan:
+		return true
+	}
+	return false
+}
+
+// SanctionNeedsDuration は期限の指定が必要な種類か
+func SanctionNeedsDuration(kind string) bool {
+	return kind == SanctionMute || kind == SanctionTempBan
+}
+
+func (s *Sanction) IsBan() bool {
+	return s.Kind == SanctionTempBan || s.Kind == SanctionPermBan
+}
+
+// Active は効力が続いているか（warning は記録のみで効力を持たない）
+func (s *Sanction) Active(now time.Time) bool {
+	if s.Kind == SanctionWarning || s.RevokedAt != nil {
+		return false
+	}
+	return s.ExpiresAt == nil || now.Before(*s.ExpiresAt)
+}
+
+func activeSanctions(q *gorm.DB, userID uint, now time.Time, kinds ...string) *gorm.DB {
+	return activeSanctionsOfKinds(q.Where("user_id = ?", userID), now, kinds...)
+}
+
+func activeSanctionsOfKinds(q *gorm.DB, now time.Time, kinds ...string) *gorm.DB {
+	return q.Where("kind IN ? AND revoked_at IS NULL AND (expires_at IS NULL OR expires_at > ?)", kinds, now)
+}
+
+// WhereBanned は有効な BAN の有無でユーザーを絞り込む（表示用の is_banned ではなく sanctions を見る）
+func WhereBanned(q *gorm.DB, banned bool, now time.Time) *gorm.DB {
+	bans := activeSanctionsOfKinds(db.DB.Model(&Sanction{}).Select("1").Where("sanctions.user_id = users.id"), now, SanctionTempBan, SanctionPermBan)
+	if banned {
+		return q.Where("EXISTS (?)", bans)
+	}
+	return q.Where("NOT EXISTS (?)", bans)
+}
+
+// ActiveBan は有効な BAN を返す（無期限を優先し、次に期限の遅いもの）。なければ nil
+func ActiveBan(tx *gorm.DB, userID uint, now time.Time) (*Sanction, error) {
+	var bans []Sanction
+	err := activeSanctions(tx, userID, now, SanctionTempBan, SanctionPermBan).
+		Order("expires_at IS NULL DESC, expires_at DESC").Limit(1).Find(&bans).Error
+	if err != nil || len(bans) == 0 {
+		return nil, err
+	}
+	return &bans[0], nil
+}
+
+// ActiveMute は有効な mute のうち最も遅く終わるものを返す。なければ nil
+func ActiveMute(userID uint, now time.Time) (*Sanction, error) {
+	var mutes []Sanction
+	if err := activeSanctions(db.DB, userID, now, SanctionMute).Order("expires_at DESC").Limit(1).Find(&mutes).Error; err != nil {
+		return nil, err
+	}
+	if len(mutes) == 0 {
+		return nil, nil
+	}
+	return &mutes[0], nil
+}
+
+// SyncBan はユーザーの表示用の BAN 項目（is_banned など）を有効な BAN に合わせる。変更があれば true
+func (u *User) SyncBan(ban *Sanction) bool {
+	if ban == nil {
+		if !u.IsBanned && u.BannedAt == nil && u.BanReason == nil && u.BanExpiresAt == nil {
+			return false
+		}
+		u.ClearBan()
+		return true
+	}
+	if u.IsBanned && u.BannedAt != nil && u.BannedAt.Equal(ban.CreatedAt) && u.BanReason != nil && *u.BanReason == ban.Reason && sameTime(u.BanExpiresAt, ban.ExpiresAt) {
+		return false
+	}
+	bannedAt, reason := ban.CreatedAt, ban.Reason
+	u.IsBanned = true
+	u.BannedAt = &bannedAt
+	u.BanReason = &reason
+	u.BanExpiresAt = ban.ExpiresAt
+	return true
+}
+
+func sameTime(a, b *time.Time) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Equal(*b)
+}
+
+// CurrentBan は有効な BAN を返し、表示用の項目がずれていれば保存し直す（期限切れ・解除済みの BAN を外す）
+func CurrentBan(u *User, now time.Time) (*Sanction, error) {
+	ban, err := ActiveBan(db.DB, u.ID, now)
+	if err != nil {
+		return nil, err
+	}
+	if u.SyncBan(ban) {
+		if err := SaveBanState(db.DB, u); err != nil {
+			log.Printf("⚠️ failed to sync ban state of user %d: %v", u.ID, err)
+		}
+	}
+	return ban, nil
+}
+
+// SyncBanStates は一覧に載せるユーザーの表示用の BAN 項目をまとめて合わせ直す（1 回の問い合わせで済ませる）
+func SyncBanStates(users []User, now time.Time) error {
+	if len(users) == 0 {
+		return nil
+	}
+	ids := make([]uint, len(users))
+	for i, u := range users {
+		ids[i] = u.ID
+	}
+	var bans []Sanction
+	err := activeSanctionsOfKinds(db.DB.Where("user_id IN ?", ids), now, SanctionTempBan, SanctionPermBan).
+		Order("expires_at IS NULL DESC, expires_at DESC").Find(&bans).Error
+	if err != nil {
+		return err
+	}
+	// ユーザーごとに先頭（ActiveBan と同じ優先順位）を使う
+	current := make(map[uint]*Sanction, len(bans))
+	for i := range bans {
+		if _, ok := current[bans[i].UserID]; !ok {
+			current[bans[i].UserID] = &bans[i]
+		}
+	}
+	for i := range users {
+		if users[i].SyncBan(current[users[i].ID]) {
+			if err := SaveBanState(db.DB, &users[i]); err != nil {
+				log.Printf("⚠️ failed to sync ban state of user %d: %v", users[i].ID, err)
+			}
+		}
+	}
+	return nil
+}
+
+// SaveBanState は表示用の BAN 項目だけを保存する
+func SaveBanState(tx *gorm.DB, u *User) error {
+	return tx.Model(u).Select("is_banned", "banned_at", "ban_reason", "ban_expires_at").Updates(u).Error
+}
+
+// RevokeActiveSanctions は有効な制裁（kinds のもの）をまとめて解除し、解除した件数を返す
+func RevokeActiveSanctions(tx *gorm.DB, userID, revokedBy uint, reason string, now time.Time, kinds ...string) (int64, error) {
+	res := activeSanctions(tx.Model(&Sanction{}), userID, now, kinds...).
+		Updates(map[string]interface{}{"revoked_at": now, "revoked_by": revokedBy, "revoke_reason": reason})
+	return res.RowsAffected, res.Error
+}
+
+// SanctionStep は制裁の段階の 1 つ
+type SanctionStep struct {
+	Kind          string `json:"kind"`
+	DurationHours int    `json:"duration_hours,omitempty"`
+}
+
+var sanctionLadder []SanctionStep
+
+// LoadSanctionLadder は SANCTION_LADDER（kind または kind:期間）を読み込む
+func LoadSanctionLadder(steps []string) error {
+	if len(steps) == 0 {
+		return fmt.Errorf("ladder is empty")
+	}
+	ladder := make([]SanctionStep, 0, len(steps))
+	for _, raw := range steps {
+		kind, dur, hasDur := strings.Cut(strings.TrimSpace(raw), ":")
+		kind = strings.ToLower(strings.TrimSpace(kind))
+		if !ValidSanctionKind(kind) {
+			return fmt.Errorf("unknown sanction %q", raw)
+		}
+		step := SanctionStep{Kind: kind}
+		if SanctionNeedsDuration(kind) != hasDur {
+			return fmt.Errorf("%q: mute and temp_ban need a duration, others must not have one", raw)
+		}
+		if hasDur {
+			d, err := time.ParseDuration(strings.TrimSpace(dur))
+			if err != nil || d <= 0 {
+				return fmt.Errorf("%q: invalid duration", raw)
+			}
+			step.DurationHours = int(math.Ceil(d.Hours()))
+		}
+		ladder = append(ladder, step)
+	}
+	sanctionLadder = ladder
+	return nil
+}
+
+// CountStrikes は since 以降の制裁（取り消したものを除く）の件数
+func CountStrikes(userID uint, since time.Time) (int64, error) {
+	var count int64
+	err := db.DB.Model(&Sanction{}).Where("user_id = ? AND voided = ? AND created_at >= ?", userID, false, since).Count(&count).Error
+	return count, err
+}
+
+// NextSanction は違反の回数から次の制裁を提案する（段階の最後で止まる）
+func NextSanction(strikes int64) SanctionStep {
+	if len(sanctionLadder) == 0 {
+		return SanctionStep{Kind: SanctionWarning}
+	}
+	return sanctionLadder[min(int(strikes), len(sanctionLadder)-1)]
+}
+
+// MigrateLegacyBans はユーザーの項目だけにある BAN（制裁の記録を導入する前のもの）を制裁として記録する
+func MigrateLegacyBans() error {
+	now := time.Now()
+	var users []User
+	if err := db.DB.Where("is_banned = ? AND (ban_expires_at IS NULL OR ban_expires_at > ?)", true, now).Find(&users).Error; err != nil {
+		return err
+	}
+	for _, u := range users {
+		ban, err := ActiveBan(db.DB, u.ID, now)
+		if err != nil {
+			return err
+		}
+		if ban != nil {
+			continue
+		}
+		s := Sanction{UserID: u.ID, Kind: SanctionPermBan, ExpiresAt: u.BanExpiresAt, CreatedAt: now}
+		if u.BanExpiresAt != nil {
+			s.Kind = SanctionTempBan
+		}
+		if u.BanReason != nil {
+			s.Reason = *u.BanReason
+		}
+		if u.BannedAt != nil {
+			s.CreatedAt = *u.BannedAt
+		}
+		if err := db.DB.Create(&s).Error; err != nil {
+			return err
+		}
+		log.Printf("ℹ️ migrated ban of user %d to sanction %d", u.ID, s.ID)
+	}
+	return nil
+}
@@ -0,0 +1,172 @@
+package model
+
+import (
+	"time"
+
+	"chillow/config"
+	"chillow/db"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// StoredObject はストレージに保存したオブジェクトの台帳（ユーザーごとの使用量の集計元）
+type StoredObject struct {
+	ObjectKey string    `json:"object_key" gorm:"primaryKey;type:varchar(255)"`
+	OwnerID   uint      `json:"owner_id" gorm:"index"`
+	Size      int64     `json:"size"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// StorageUsageRecorder は storage.UsageRecorder の実装（保存・削除のたびに台帳を更新する）
+type StorageUsageRecorder struct{}
+
+func (StorageUsageRecorder) ObjectStored(ownerID uint, objectKey string, size int64) error {
+	obj := StoredObject{ObjectKey: objectKey, OwnerID: ownerID, Size: size, CreatedAt: time.Now()}
+	return db.DB.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "object_key"}},
+		DoUpdates: clause.AssignmentColumns([]string{"owner_id", "size"}),
+	}).Create(&obj).Error
+}
+
+func (StorageUsageRecorder) ObjectDeleted(objectKey string) error {
+	return db.DB.Where("object_key = ?", objectKey).Delete(&StoredObject{}).Error
+}
+
+// chargedObjects はユーザーに計上する保存量の行（owner_id, size）。
+// 内容アドレスのオブジェクトは共有されるので、台帳ではなく参照している添付ごとにそれぞれの持ち主へ計上する。
+func chargedObjects() *gorm.DB {
+	return db.DB.Raw("? UNION ALL ?",
+		db.DB.Model(&StoredObject{}).Select("owner_id", "size"),
+		db.DB.Model(&Attachment{}).Select("owner_id", "size").Where("content_hash <> ''"),
+	)
+}
+
+// StorageUsed はユーザーが保存しているオブジェクトの合計バイト数
+func StorageUsed(userID uint) (int64, error) {
+	var used int64
+	err := db.DB.Table("(?) AS charged", chargedObjects()).
+		Where("owner_id = ?", userID).
+		Select("COALESCE(SUM(size), 0)").
+		Scan(&used).Error
+	return used, err
+}
+
+// StorageQuota はユーザーの保存容量の上限（個別設定がなければ既定値。0 以下は無制限）
+func (u *User) StorageQuota() int64 {
+	if u.StorageQuotaBytes != nil {
+		return *u.StorageQuotaBytes
+	}
+	return config.Cfg.StorageQuotaBytes
+}
+
+type StorageConsumer struct {
+	UserID      uint   `json:"user_id"`
+	Nickname    string `json:"nickname"`
+	Email       string `json:"email"`
+	UsedBytes   int64  `json:"used_bytes"`
+	ObjectCount int64  `json:"object_count"`
+	QuotaBytes  int64  `json:"quota_bytes"`
+	CustomQuota bool   `json:"custom_quota"` // 既定値ではなく個別に設定された上限か
+}
+
+// TopStorageConsumers は使用量の多いユーザーから順に返す
+func TopStorageConsumers(limit int) ([]StorageConsumer, error) {
+	var rows []struct {
+		UserID            uint
+		Nickname          string
+		Email             string
+		UsedBytes         int64
+		ObjectCount       int64
+		StorageQuotaBytes *int64
+	}
+	err := db.DB.Table("(?) AS charged", chargedObjects()).
+		Select(`
+			charged.owner_id AS user_id,
+			users.nickname,
+			users.email,
+			users.storage_quota_bytes,
+			SUM(charged.size) AS used_bytes,
+			COUNT(*) AS object_count
+		`).
+		Joins("JOIN users ON users.id = charged.owner_id").
+		Group("charged.owner_id, users.nickname, users.email, users.storage_quota_bytes").
+		Order("used_bytes DESC").
+		Limit(limit).
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	out := make([]StorageConsumer, 0, len(rows))
+	for _, r := range rows {
+		u := User{StorageQuotaBytes: r.StorageQuotaBytes}
+		out = append(out, StorageConsumer{
+			UserID:      r.UserID,
+			Nickname:    r.Nickname,
+			Email:       r.Email,
+			UsedBytes:   r.UsedBytes,
+			ObjectCount: r.ObjectCount,
+			QuotaBytes:  u.StorageQuota(),
+			CustomQuota: r.StorageQuotaBytes != nil,
+		})
+	}
+	return out, nil
+}
+
+// ReferencedObjectKeys はストレージに残す必要があるオブジェクトキー（バリアントは含まない）。
+// 表示中のメッセージ、処理待ちの通報が保全している添付、まだメッセージに使われていないアップロード、参照の残っている内容アドレスのオブジェクトが対象。
+func ReferencedObjectKeys() (map[string]struct{}, error) {
+	refs := make(map[string]struct{})
+	add := func(keys []string) {
+		for _, k := range keys {
+			if k != "" {
+				refs[k] = struct{}{}
+			}
+		}
+	}
+
+	var keys []string
+	if err := db.DB.Model(&Message{}).
+		Where("attachment_obj IS NOT NULL").
+		Where("is_deleted = ? OR id IN (?)", false, PendingReportMessageIDs()).
+		Pluck("attachment_obj", &keys).Error; err != nil {
+		return nil, err
+	}
+	add(keys)
+
+	keys = nil
+	if err := db.DB.Model(&Report{}).
+		Where("status = ? AND attachment_obj IS NOT NULL", "pending").
+		Pluck("attachment_obj", &keys).Error; err != nil {
+		return nil, err
+	}
+	add(keys)
+
+	keys = nil
+	if err := db.DB.Model(&Attachment{}).
+		Where("message_id IS NULL").
+		Pluck("object_key", &keys).Error; err != nil {
+		return nil, err
+	}
+	add(keys)
+
+	keys, err := ReferencedContentKeys()
+	if err != nil {
+		return nil, err
+	}
+	add(keys)
+	return refs, nil
+}
+
+// StoredObjectTimes は台帳のキーと記録日時を返す（before 以降に記録されたものは含まない）
+func StoredObjectTimes(before time.Time) (map[string]time.Time, error) {
+	var rows []StoredObject
+	if err := db.DB.Select("object_key", "created_at").Where("created_at < ?", before).Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	out := make(map[string]time.Time, len(rows))
+	for _, r := range rows {
+		out[r.ObjectKey] = r.CreatedAt
+	}
+	return out, nil
+}
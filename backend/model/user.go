@@ -1,29 +1,31 @@
 package model
 
 import (
-	"chillow/config"
 	"chillow/db"
 	"fmt"
 	"math/rand"
-	"strings"
 	"time"
 
 	"gorm.io/gorm"
 )
 
 type User struct {
-	ID           uint       `json:"id" gorm:"primaryKey"`
-	Nickname     string     `json:"nickname"`
-	Email        string     `json:"email" gorm:"type:varchar(191);uniqueIndex"`
-	FriendCode   string     `json:"friend_code" gorm:"type:varchar(20);uniqueIndex"`
-	AvatarURL    string     `json:"avatar_url"`
-	Role         string     `json:"role" gorm:"type:varchar(20);default:user"`
-	IsBanned     bool       `json:"is_banned"`
-	BannedAt     *time.Time `json:"banned_at,omitempty"`
-	BanReason    *string    `json:"ban_reason,omitempty"`
-	BanExpiresAt *time.Time `json:"ban_expires_at,omitempty"`
-	CreatedAt    time.Time  `json:"created_at"`
-	UpdatedAt    time.Time  `json:"updated_at"`
+	ID                uint       `json:"id" gorm:"primaryKey"`
+	Nickname          string     `json:"nickname"`
+	Email             string     `json:"email" gorm:"type:varchar(191);uniqueIndex"`
+	FriendCode        string     `json:"friend_code" gorm:"type:varchar(20);uniqueIndex"`
+	AvatarURL         string     `json:"avatar_url"`
+	Role              string     `json:"role" gorm:"type:varchar(20);default:user"`
+	RoleSource        string     `json:"role_source,omitempty" gorm:"type:varchar(20);not null;default:''"` // config / manual（user は空）
+	IsBanned          bool       `json:"is_banned"`                                                         // 以下 4 項目は有効な BAN（sanctions）の写し
+	BannedAt          *time.Time `json:"banned_at,omitempty"`
+	BanReason         *string    `json:"ban_reason,omitempty"`
+	BanExpiresAt      *time.Time `json:"ban_expires_at,omitempty"`
+	HidePresence      bool       `json:"hide_presence" gorm:"default:false"` // オンライン状態・最終ログインを友達に見せない
+	LastSeenAt        *time.Time `json:"last_seen_at,omitempty"`
+	StorageQuotaBytes *int64     `json:"storage_quota_bytes,omitempty"` // 保存容量の個別上限（nil なら STORAGE_QUOTA_BYTES）
+	CreatedAt         time.Time  `json:"created_at"`
+	UpdatedAt         time.Time  `json:"updated_at"`
 }
 
 func FindOrCreateUserByEmail(email, nickname, avatarURL string) (*User, error) {
@@ -33,17 +35,24 @@ func FindOrCreateUserByEmail(email, nickname, avatarURL string) (*User, error) {
 			return nil, err
 		}
 		// なければ新規作成
-		role := determineDefaultRole(email)
 		user = User{
 			Email:      email,
 			Nickname:   nickname,
 			AvatarURL:  avatarURL,
 			FriendCode: generateUniqueFriendCode(),
-			Role:       role,
+			Role:       RoleUser,
 		}
+		user.applyConfiguredRole()
 		if err := db.DB.Create(&user).Error; err != nil {
 			return nil, err
 		}
+		return &user, nil
+	}
+	// 既存アカウントにも設定の変更を反映する
+	if user.applyConfiguredRole() {
+		if err := db.DB.Model(&user).Updates(map[string]interface{}{"role": user.Role, "role_source": user.RoleSource}).Error; err != nil {
+			return nil, err
+		}
 	}
 	return &user, nil
 }
@@ -59,18 +68,7 @@ func generateUniqueFriendCode() string {
 	}
 }
 
-func determineDefaultRole(email string) string {
-	if config.Cfg != nil {
-		needle := strings.ToLower(strings.TrimSpace(email))
-		for _, candidate := range config.Cfg.AdminEmails {
-			if strings.ToLower(strings.TrimSpace(candidate)) == needle && needle != "" {
-				return "admin"
-			}
-		}
-	}
-	return "user"
-}
-
+// ClearBan は表示用の BAN 項目を空にする（BAN の有無は sanctions を正とする）
 func (u *User) ClearBan() {
 	u.IsBanned = false
 	u.BannedAt = nil
@@ -78,6 +76,7 @@ func (u *User) ClearBan() {
 	u.BanExpiresAt = nil
 }
 
-func (u *User) ShouldLiftBan(now time.Time) bool {
-	return u.IsBanned && u.BanExpiresAt != nil && now.After(*u.BanExpiresAt)
+// 接続状態が変わるたびに記録する（オフライン時は最終ログイン時刻になる）
+func TouchLastSeen(userID uint, at time.Time) error {
+	return db.DB.Model(&User{}).Where("id = ?", userID).UpdateColumn("last_seen_at", at).Error
 }
@@ -0,0 +1,82 @@
+package model
+
+import (
+	"strings"
+	"time"
+
+	"chillow/db"
+
+	"gorm.io/gorm"
+)
+
+// UserFilter は管理画面のユーザー検索の条件（ゼロ値の項目は絞り込まない）
+type UserFilter struct {
+	Query        string // メールアドレス・ニックネームの部分一致、またはフレンドコード
+	Role         string
+	Banned       *bool
+	CreatedSince *time.Time
+	CreatedUntil *time.Time
+	BeforeID     uint // この ID より古いものだけ（ページング）
+}
+
+func (f UserFilter) Apply(q *gorm.DB) *gorm.DB {
+	if query := strings.TrimSpace(f.Query); query != "" {
+		like := "%" + escapeLike(strings.ToLower(query)) + "%"
+		q = q.Where("LOWER(email) LIKE ? OR LOWER(nickname) LIKE ? OR friend_code = ?", like, like, strings.ToUpper(query))
+	}
+	if f.Role != "" {
+		q = q.Where("role = ?", f.Role)
+	}
+	if f.Banned != nil {
+		q = WhereBanned(q, *f.Banned, time.Now())
+	}
+	if f.CreatedSince != nil {
+		q = q.Where("created_at >= ?", *f.CreatedSince)
+	}
+	if f.CreatedUntil != nil {
+		q = q.Where("created_at < ?", *f.CreatedUntil)
+	}
+	if f.BeforeID != 0 {
+		q = q.Where("id < ?", f.BeforeID)
+	}
+	return q
+}
+
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+// MessageStats はユーザーの送受信量（削除済みのメッセージも数える）
+type MessageStats struct {
+	SentTotal     int64      `json:"sent_total"`
+	SentRecent    int64      `json:"sent_recent"` // since 以降に送ったもの
+	ReceivedTotal int64      `json:"received_total"`
+	LastSentAt    *time.Time `json:"last_sent_at"`
+}
+
+func LoadMessageStats(userID uint, since time.Time) (MessageStats, error) {
+	var stats MessageStats
+	var row struct {
+		Total  int64
+		Recent int64
+		Last   *time.Time
+	}
+	err := db.DB.Model(&Message{}).
+		Select("COUNT(*) AS total, COALESCE(SUM(CASE WHEN created_at >= ? THEN 1 ELSE 0 END), 0) AS recent, MAX(created_at) AS last", since).
+		Where("sender_id = ?", userID).
+		Scan(&row).Error
+	if err != nil {
+		return stats, err
+	}
+	stats.SentTotal, stats.SentRecent, stats.LastSentAt = row.Total, row.Recent, row.Last
+	if err := db.DB.Model(&Message{}).Where("receiver_id = ?", userID).Count(&stats.ReceivedTotal).Error; err != nil {
+		return stats, err
+	}
+	return stats, nil
+}
+
+func CountFriends(userID uint) (int64, error) {
+	var count int64
+	err := db.DB.Model(&Friend{}).Where("user_id = ?", userID).Count(&count).Error
+	return count, err
+}
@@ -4,6 +4,7 @@ import (
 	"chillow/config"
 	"chillow/controller"
 	"chillow/middleware"
+	"chillow/model"
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
@@ -11,7 +12,8 @@ import (
 
 func SetupRouter() *gin.Engine {
 	r := gin.Default()
-	r.Static("/uploads", config.Cfg.UploadDir)
+	// ローカル保存の添付は署名付き URL でのみ配信する
+	r.GET("/uploads/*filepath", controller.ServeUploadHandler)
 
 	// CORS 設定
 	r.Use(cors.New(cors.Config{
@@ -37,6 +39,9 @@ func SetupRouter() *gin.Engine {
 			auth.POST("/logout", controller.LogoutHandler)
 		}
 
+		// 署名付き URL への直接アップロード（ローカル保存時。認証はトークンで行う）
+		api.PUT("/uploads", controller.LocalSignedUploadHandler)
+
 		// ユーザー情報
 		users := api.Group("/users")
 		users.Use(middleware.AuthMiddleware()) // 🔐 JWTミドルウェア
@@ -48,7 +53,7 @@ func SetupRouter() *gin.Engine {
 
 		// フレンド申請・承認・一覧など
 		friendRequests := api.Group("/friend-requests")
-		friendRequests.Use(middleware.AuthMiddleware(), middleware.ForbidRoles("admin")) // 🔐 JWTミドルウェア
+		friendRequests.Use(middleware.AuthMiddleware(), middleware.ForbidRoles(model.StaffRoles()...)) // 🔐 JWTミドルウェア
 		{
 			friendRequests.POST("", controller.SendFriendRequestHandler)
 			friendRequests.GET("", controller.GetFriendRequestsHandler)
@@ -57,7 +62,7 @@ func SetupRouter() *gin.Engine {
 
 		// フレンド一覧・削除
 		friends := api.Group("/friends")
-		friends.Use(middleware.AuthMiddleware(), middleware.ForbidRoles("admin")) // 🔐 JWTミドルウェア
+		friends.Use(middleware.AuthMiddleware(), middleware.ForbidRoles(model.StaffRoles()...)) // 🔐 JWTミドルウェア
 		{
 			friends.GET("", controller.GetFriendsHandler)
 			friends.DELETE("/:id", controller.DeleteFriendHandler)
@@ -65,28 +70,55 @@ func SetupRouter() *gin.Engine {
 
 		// メッセージ関連
 		messages := api.Group("/messages")
-		messages.Use(middleware.AuthMiddleware(), middleware.ForbidRoles("admin")) // 🔐 JWTミドルウェア
+		messages.Use(middleware.AuthMiddleware(), middleware.ForbidRoles(model.StaffRoles()...)) // 🔐 JWTミドルウェア
 		{
 			messages.GET("/:friend_id", controller.GetMessagesHandler)
 			messages.POST("", controller.PostMessageHandler)
 			messages.POST("/media", controller.UploadMessageMediaHandler)
+			messages.POST("/media/preflight", controller.PreflightMessageMediaHandler)
+			messages.POST("/media/presign", controller.PresignMessageMediaHandler)
+			messages.POST("/media/confirm", controller.ConfirmMessageMediaHandler)
+			messages.GET("/attachments/:id", controller.MessageAttachmentHandler)
 			messages.POST("/:id/read", controller.MarkMessageAsReadHandler)
 			messages.PATCH("/:id", controller.UpdateMessageHandler)
 			messages.DELETE("/:id", controller.DeleteMessageHandler)
 			messages.POST("/:id/report", controller.ReportMessageHandler)
 		}
 
-		// 管理者専用
+		// 会話ごとの設定（消えるメッセージ）
+		conversations := api.Group("/conversations")
+		conversations.Use(middleware.AuthMiddleware(), middleware.ForbidRoles(model.StaffRoles()...)) // 🔐 JWTミドルウェア
+		{
+			conversations.GET("/:friend_id/retention", controller.GetConversationRetentionHandler)
+			conversations.PUT("/:friend_id/retention", controller.UpdateConversationRetentionHandler)
+		}
+
+		// 運営スタッフ専用（操作ごとにロールの権限を確認する）
 		admin := api.Group("/admin")
-		admin.Use(middleware.AuthMiddleware(), middleware.RequireRoles("admin"))
+		admin.Use(middleware.AuthMiddleware(), middleware.RequireRoles(model.StaffRoles()...))
 		{
 			admin.GET("/health", controller.AdminHealthHandler)
-			admin.POST("/users/:id/ban", controller.AdminBanUserHandler)
-			admin.POST("/users/:id/unban", controller.AdminUnbanUserHandler)
-			admin.GET("/events", controller.AdminEventsHandler)
-			admin.GET("/reports", controller.AdminListReportsHandler)
-			admin.POST("/reports/:id/resolve", controller.AdminResolveReportHandler)
-			admin.GET("/banned-users", controller.AdminListBannedUsersHandler)
+			admin.GET("/metrics", middleware.RequirePermission(model.PermSystemView), controller.AdminMetricsHandler)
+			admin.GET("/users", middleware.RequirePermission(model.PermUsersView), controller.AdminListUsersHandler)
+			admin.GET("/users/:id", middleware.RequirePermission(model.PermUsersView), controller.AdminGetUserHandler)
+			admin.POST("/users/:id/ban", middleware.RequirePermission(model.PermUsersBan), controller.AdminBanUserHandler)
+			admin.POST("/users/:id/unban", middleware.RequirePermission(model.PermUsersBan), controller.AdminUnbanUserHandler)
+			admin.GET("/users/:id/sanctions", middleware.RequirePermission(model.PermUsersView), controller.AdminListSanctionsHandler)
+			admin.POST("/users/:id/sanctions", middleware.RequirePermission(model.PermUsersBan), controller.AdminIssueSanctionHandler)
+			admin.POST("/sanctions/:id/revoke", middleware.RequirePermission(model.PermUsersBan), controller.AdminRevokeSanctionHandler)
+			admin.GET("/events", middleware.RequirePermission(model.PermReportsView), controller.AdminEventsHandler)
+			admin.GET("/reports", middleware.RequirePermission(model.PermReportsView), controller.AdminListReportsHandler)
+			admin.POST("/reports/:id/resolve", middleware.RequirePermission(model.PermReportsResolve), controller.AdminResolveReportHandler)
+			admin.GET("/reports/:id/attachment", middleware.RequirePermission(model.PermReportsView), controller.AdminReportAttachmentHandler)
+			admin.GET("/banned-users", middleware.RequirePermission(model.PermUsersBan), controller.AdminListBannedUsersHandler)
+			admin.GET("/storage/usage", middleware.RequirePermission(model.PermStorageManage), controller.AdminStorageUsageHandler)
+			admin.PUT("/users/:id/storage-quota", middleware.RequirePermission(model.PermStorageManage), controller.AdminUpdateStorageQuotaHandler)
+			admin.POST("/storage/gc", middleware.RequirePermission(model.PermStorageManage), controller.AdminStorageGCHandler)
+			admin.GET("/audit", middleware.RequirePermission(model.PermAuditView), controller.AdminListAuditLogsHandler)
+			admin.GET("/roles", controller.AdminListRolesHandler)
+			admin.GET("/staff", middleware.RequirePermission(model.PermRolesManage), controller.AdminListStaffHandler)
+			admin.PUT("/users/:id/role", middleware.RequirePermission(model.PermRolesManage), controller.AdminGrantRoleHandler)
+			admin.DELETE("/users/:id/role", middleware.RequirePermission(model.PermRolesManage), controller.AdminRevokeRoleHandler)
 		}
 
 		// 		// 通知関連（未読件数など）
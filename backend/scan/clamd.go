@@ -0,0 +1,102 @@
+package scan
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+// clamd の INSTREAM は 1 チャンクあたりの上限が StreamMaxLength より小さければよい
+const clamdChunkSize = 64 * 1024
+
+// clamdScanner は clamd の TCP ソケットへ INSTREAM で中身を送って検査する
+type clamdScanner struct {
+	addr    string
+	timeout time.Duration
+}
+
+func newClamdScanner(addr string, timeout time.Duration) *clamdScanner {
+	return &clamdScanner{addr: addr, timeout: timeout}
+}
+
+func (s *clamdScanner) Name() string { return "clamd" }
+
+func (s *clamdScanner) Scan(r io.Reader) (Result, error) {
+	conn, err := net.DialTimeout("tcp", s.addr, s.timeout)
+	if err != nil {
+		return Result{}, fmt.Errorf("%w: %v", ErrUnavailable, err)
+	}
+	defer conn.Close()
+	// 大きいファイルでも全体で timeout を超えたら打ち切る
+	if err := conn.SetDeadline(time.Now().Add(s.timeout)); err != nil {
+		return Result{}, fmt.Errorf("%w: %v", ErrUnavailable, err)
+	}
+
+	// z 接頭辞のコマンドは NUL 区切りで応答が返る
+	if _, err := conn.Write([]byte("zINSTREAM\x00")); err != nil {
+		return Result{}, fmt.Errorf("%w: %v", ErrUnavailable, err)
+	}
+	// サイズ上限を超えると clamd は途中で応答して切断する。切断（RST）で応答が捨てられないよう、送信と並行して読む
+	replies := make(chan clamdReply, 1)
+	go func() { replies <- readClamdReply(conn) }()
+
+	buf := make([]byte, 4+clamdChunkSize)
+	for {
+		n, readErr := r.Read(buf[4:])
+		if n > 0 {
+			binary.BigEndian.PutUint32(buf[:4], uint32(n))
+			if _, err := conn.Write(buf[:4+n]); err != nil {
+				// clamd が先に応答して切断した場合は、その応答を使う
+				if reply := <-replies; reply.line != "" {
+					return reply.result()
+				}
+				return Result{}, fmt.Errorf("%w: %v", ErrUnavailable, err)
+			}
+		}
+		if readErr == io.EOF {
+			break
+		}
+		if readErr != nil {
+			return Result{}, readErr
+		}
+	}
+	if _, err := conn.Write([]byte{0, 0, 0, 0}); err != nil {
+		return Result{}, fmt.Errorf("%w: %v", ErrUnavailable, err)
+	}
+	return (<-replies).result()
+}
+
+type clamdReply struct {
+	line string
+	err  error // 応答がないまま読めなくなったときのエラー
+}
+
+// readClamdReply は接続が閉じられるまで応答を読む
+func readClamdReply(conn net.Conn) clamdReply {
+	reply, err := io.ReadAll(io.LimitReader(conn, 4096))
+	line := strings.TrimSpace(string(bytes.TrimRight(reply, "\x00\n")))
+	if line != "" {
+		err = nil
+	}
+	return clamdReply{line: line, err: err}
+}
+
+// result は "stream: OK" / "stream: <name> FOUND" / "<message> ERROR" を解釈する
+func (r clamdReply) result() (Result, error) {
+	if r.err != nil {
+		return Result{}, fmt.Errorf("%w: %v", ErrUnavailable, r.err)
+	}
+	line := strings.TrimPrefix(r.line, "stream: ")
+	switch {
+	case line == "OK":
+		return Result{}, nil
+	case strings.HasSuffix(line, " FOUND"):
+		return Result{Infected: true, Signature: strings.TrimSuffix(line, " FOUND")}, nil
+	default:
+		return Result{}, fmt.Errorf("%w: clamd replied %q", ErrUnavailable, line)
+	}
+}
@@ -0,0 +1,160 @@
+package scan
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// clamdStub は 1 接続だけ受け付け、zINSTREAM のチャンクを読んでから reply を返す。
+// limit を超えて受け取ったら、残りを読まずに reply を返して切断する（clamd の StreamMaxLength と同じ動き）
+type clamdStub struct {
+	ln    net.Listener
+	reply string
+	limit int
+
+	done    chan struct{}
+	command string
+	chunks  []int // 受け取ったチャンクの長さ（終端の 0 を含む）
+	data    []byte
+	err     error
+}
+
+func newClamdStub(t *testing.T, reply string, limit int) *clamdStub {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &clamdStub{ln: ln, reply: reply, limit: limit, done: make(chan struct{})}
+	t.Cleanup(func() { ln.Close() })
+	go s.serve()
+	return s
+}
+
+func (s *clamdStub) serve() {
+	defer close(s.done)
+	conn, err := s.ln.Accept()
+	if err != nil {
+		s.err = err
+		return
+	}
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	if s.command, s.err = r.ReadString(0); s.err != nil {
+		return
+	}
+	for {
+		var size uint32
+		if s.err = binary.Read(r, binary.BigEndian, &size); s.err != nil {
+			return
+		}
+		s.chunks = append(s.chunks, int(size))
+		if size == 0 {
+			break
+		}
+		chunk := make([]byte, size)
+		if _, s.err = io.ReadFull(r, chunk); s.err != nil {
+			return
+		}
+		s.data = append(s.data, chunk...)
+		if s.limit > 0 && len(s.data) > s.limit {
+			break
+		}
+	}
+	conn.Write([]byte(s.reply + "\x00"))
+}
+
+// wait は stub が応答を返し終えるのを待つ
+func (s *clamdStub) wait(t *testing.T) {
+	t.Helper()
+	select {
+	case <-s.done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("stub did not finish")
+	}
+}
+
+func TestClamdScan(t *testing.T) {
+	tests := []struct {
+		name     string
+		reply    string
+		result   Result
+		errMatch string // 空なら成功
+	}{
+		{"clean", "stream: OK", Result{}, ""},
+		{"infected", "stream: Eicar-Signature FOUND", Result{Infected: true, Signature: "Eicar-Signature"}, ""},
+		{"error", "INSTREAM size limit exceeded. ERROR", Result{}, "size limit exceeded"},
+		{"garbage", "PONG", Result{}, "PONG"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := newClamdStub(t, tt.reply, 0)
+			// チャンクの上限をまたぐ大きさにして、複数チャンクに分かれることを確認する
+			data := bytes.Repeat([]byte("0123456789abcdef"), (2*clamdChunkSize+100)/16)
+			res, err := newClamdScanner(stub.ln.Addr().String(), 5*time.Second).Scan(bytes.NewReader(data))
+			stub.wait(t)
+
+			if stub.err != nil {
+				t.Fatalf("stub: %v", stub.err)
+			}
+			if stub.command != "zINSTREAM\x00" {
+				t.Errorf("command = %q", stub.command)
+			}
+			if n := len(stub.chunks); n < 3 || stub.chunks[n-1] != 0 {
+				t.Errorf("chunks = %v, want several chunks and a zero-length terminator", stub.chunks)
+			}
+			for _, size := range stub.chunks {
+				if size > clamdChunkSize {
+					t.Errorf("chunk of %d bytes exceeds %d", size, clamdChunkSize)
+				}
+			}
+			if !bytes.Equal(stub.data, data) {
+				t.Errorf("stub received %d bytes, want %d", len(stub.data), len(data))
+			}
+
+			if tt.errMatch == "" {
+				if err != nil {
+					t.Fatalf("Scan: %v", err)
+				}
+			} else if !errors.Is(err, ErrUnavailable) || !strings.Contains(err.Error(), tt.errMatch) {
+				t.Fatalf("err = %v, want ErrUnavailable with %q", err, tt.errMatch)
+			}
+			if res != tt.result {
+				t.Errorf("result = %+v, want %+v", res, tt.result)
+			}
+		})
+	}
+}
+
+// clamd が上限を超えた時点で応答して切断したら、送信の失敗ではなくその応答を返す
+func TestClamdScanEarlyReply(t *testing.T) {
+	stub := newClamdStub(t, "INSTREAM size limit exceeded. ERROR", clamdChunkSize)
+	data := make([]byte, 64<<20)
+	_, err := newClamdScanner(stub.ln.Addr().String(), 10*time.Second).Scan(bytes.NewReader(data))
+	stub.wait(t)
+	if !errors.Is(err, ErrUnavailable) || !strings.Contains(err.Error(), "size limit exceeded") {
+		t.Fatalf("err = %v, want the clamd reply", err)
+	}
+	if len(stub.data) >= len(data) {
+		t.Fatal("stub read the whole stream; the early-reply path was not exercised")
+	}
+}
+
+func TestClamdScanUnavailable(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+	if _, err := newClamdScanner(addr, time.Second).Scan(strings.NewReader("x")); !errors.Is(err, ErrUnavailable) {
+		t.Fatalf("err = %v, want ErrUnavailable", err)
+	}
+}
@@ -0,0 +1,56 @@
+// Package scan はアップロードされた添付のマルウェア検査を抽象化する。
+package scan
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"chillow/config"
+)
+
+// ErrUnavailable は検査できなかった（スキャナに接続できない・応答が不正など）ことを表す。
+// 検査を通っていない添付は受け付けない。
+var ErrUnavailable = errors.New("scanner unavailable")
+
+// Result は検査結果。Infected のとき Signature に検出名が入る
+type Result struct {
+	Infected  bool
+	Signature string
+}
+
+// Scanner は中身を最後まで読んで検査する
+type Scanner interface {
+	Scan(r io.Reader) (Result, error)
+	Name() string
+}
+
+var defaultScanner Scanner = noopScanner{}
+
+func Init(cfg *config.Config) error {
+	switch strings.ToLower(cfg.AttachmentScanner) {
+	case "", "none":
+		defaultScanner = noopScanner{}
+	case "clamd":
+		defaultScanner = newClamdScanner(cfg.ClamdAddr, cfg.ClamdTimeout)
+	default:
+		return fmt.Errorf("unsupported attachment scanner: %s", cfg.AttachmentScanner)
+	}
+	return nil
+}
+
+func Default() Scanner { return defaultScanner }
+
+// Enabled は実際に検査するスキャナが設定されているかを返す
+func Enabled() bool {
+	_, noop := defaultScanner.(noopScanner)
+	return !noop
+}
+
+// noopScanner は検査せずにすべて通す（既定）
+type noopScanner struct{}
+
+func (noopScanner) Scan(io.Reader) (Result, error) { return Result{}, nil }
+
+func (noopScanner) Name() string { return "none" }
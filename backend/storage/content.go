@@ -0,0 +1,50 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"strings"
+)
+
+// 内容アドレスのオブジェクトは chat/sha256/<先頭2文字>/<SHA-256>-<種類><拡張子> に保存する。
+// 同じ中身・種類・形式は誰がアップロードしても同じキーになるので、持ち主を持たない（使用量は添付ごとに数える）。
+const contentKeyPrefix = "chat/sha256/"
+
+// ContentObjectKey は中身の SHA-256（16進小文字）と添付の種類、拡張子（画像・音声は中身から判定したもの）からキーを返す。
+// 同じバイト列でも種類や拡張子が違えば別のオブジェクトにする（Content-Type や縮小版を取り違えないため）
+func ContentObjectKey(hash, kind, ext string) string {
+	return contentKeyPrefix + hash[:2] + "/" + hash + "-" + kind + strings.ToLower(ext)
+}
+
+func IsContentObjectKey(objectKey string) bool {
+	return strings.HasPrefix(objectKey, contentKeyPrefix)
+}
+
+func HashContent(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// HashReader は r を最後まで読んで SHA-256 と読んだバイト数を返す
+func HashReader(r io.Reader) (string, int64, error) {
+	h := sha256.New()
+	n, err := io.Copy(h, r)
+	if err != nil {
+		return "", 0, err
+	}
+	return hex.EncodeToString(h.Sum(nil)), n, nil
+}
+
+// ValidContentHash はクライアントから受け取った SHA-256 の形式を確認する
+func ValidContentHash(hash string) bool {
+	if len(hash) != sha256.Size*2 {
+		return false
+	}
+	for _, c := range hash {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,112 @@
+package storage
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ObjectEntry は List で列挙したオブジェクト
+type ObjectEntry struct {
+	Key          string
+	Size         int64
+	LastModified time.Time
+}
+
+func (m *localManager) List(prefix string, fn func(ObjectEntry) error) error {
+	root := filepath.Join(m.baseDir, filepath.FromSlash(prefix))
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		// 書き込み途中の一時ファイル（writeFile）はオブジェクトとして扱わない
+		if d.IsDir() || strings.HasPrefix(d.Name(), ".upload-") {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil // 列挙中に削除された
+		}
+		rel, err := filepath.Rel(m.baseDir, path)
+		if err != nil {
+			return err
+		}
+		return fn(ObjectEntry{Key: filepath.ToSlash(rel), Size: info.Size(), LastModified: info.ModTime()})
+	})
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// ListObjectsV2 のレスポンス（必要な項目のみ）
+type s3ListResult struct {
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+	Contents              []struct {
+		Key          string    `xml:"Key"`
+		Size         int64     `xml:"Size"`
+		LastModified time.Time `xml:"LastModified"`
+	} `xml:"Contents"`
+}
+
+// List は ListObjectsV2 を継続トークンで辿って列挙する（1 ページ最大 1000 件）
+func (s *s3Manager) List(prefix string, fn func(ObjectEntry) error) error {
+	token := ""
+	for {
+		q := url.Values{}
+		q.Set("list-type", "2")
+		q.Set("prefix", prefix)
+		if token != "" {
+			q.Set("continuation-token", token)
+		}
+		page, err := s.listPage(q)
+		if err != nil {
+			return err
+		}
+		for _, obj := range page.Contents {
+			if err := fn(ObjectEntry{Key: obj.Key, Size: obj.Size, LastModified: obj.LastModified}); err != nil {
+				return err
+			}
+		}
+		if !page.IsTruncated || page.NextContinuationToken == "" {
+			return nil
+		}
+		token = page.NextContinuationToken
+	}
+}
+
+func (s *s3Manager) listPage(q url.Values) (*s3ListResult, error) {
+	resp, err := s.send(func() (*http.Request, error) {
+		req, err := s.newRequest(http.MethodGet, "", nil)
+		if err != nil {
+			return nil, err
+		}
+		req.URL.RawQuery = strings.ReplaceAll(q.Encode(), "+", "%20")
+		return req, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		b, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("s3 list failed: %s %s", resp.Status, string(b))
+	}
+	var page s3ListResult
+	if err := xml.NewDecoder(resp.Body).Decode(&page); err != nil {
+		return nil, err
+	}
+	return &page, nil
+}
@@ -4,16 +4,18 @@ import (
 	"bytes"
 	"crypto/hmac"
 	"crypto/sha256"
+	"encoding/base64"
 	"encoding/hex"
 	"fmt"
 	"io"
-	"mime/multipart"
+	"mime"
 	"net/http"
 	"net/url"
 	"os"
 	"path"
 	"path/filepath"
 	"sort"
+	"strconv"
 	"strings"
 	"time"
 
@@ -22,26 +24,70 @@ import (
 
 // Manager abstracts attachment persistence.
 type Manager interface {
-	SaveChatMedia(userID uint, file *multipart.FileHeader) (publicURL string, objectKey string, err error)
+	// Put は指定キーにオブジェクトを保存する（サムネイルなどサーバー側で生成したもの）
+	Put(objectKey string, body []byte, contentType string) error
+	// PutStream は body を読みながら保存する（size が分からなければ -1）。大きいファイルをメモリに載せずに送るときに使う
+	PutStream(objectKey string, body io.Reader, size int64, contentType string) error
+	// Get はオブジェクトを読み出す（存在しなければ ErrNotFound）
+	Get(objectKey string) (io.ReadCloser, error)
+	// Delete はオブジェクトとそのバリアント（サムネイル等）をまとめて削除する
 	Delete(objectKey string) error
+
+	// PresignUpload はクライアントが API を経由せず直接 PUT するための署名付き URL を返す。
+	// Content-Type と Content-Length は署名に含まれ、異なる値では受け付けられない。
+	PresignUpload(objectKey, contentType string, size int64, ttl time.Duration) (*UploadTarget, error)
+	// Head はオブジェクトの存在とサイズを確認する（存在しなければ ErrNotFound）
+	Head(objectKey string) (*ObjectInfo, error)
+	// List は prefix 配下のオブジェクトを順に fn へ渡す（fn がエラーを返すとそこで止まり、そのエラーを返す）
+	List(prefix string, fn func(ObjectEntry) error) error
+	PublicURL(objectKey string) string
+	// SignedURL は期限付きのダウンロード URL を返す（バケット・保存先は非公開のまま）
+	SignedURL(objectKey string, ttl time.Duration) (string, error)
+	// SignedDownloadURL は Content-Disposition: attachment（元のファイル名）で返す期限付き URL
+	SignedDownloadURL(objectKey, filename string, ttl time.Duration) (string, error)
+}
+
+// SignedFileServer は SignedURL を API 自身が配信するバックエンド（local）
+type SignedFileServer interface {
+	// download は SignedDownloadURL で指定したファイル名（なければ空）
+	OpenSigned(objectKey, expires, download, signature string) (filePath string, err error)
+}
+
+// ContentDisposition はダウンロード時のヘッダー値（日本語のファイル名は RFC 2231 形式になる）
+func ContentDisposition(filename string) string {
+	return mime.FormatMediaType("attachment", map[string]string{"filename": filename})
+}
+
+// SignedUploadReceiver は PresignUpload の URL を API 自身が受け付けるバックエンド（local）
+type SignedUploadReceiver interface {
+	ReceiveSignedUpload(token, contentType string, size int64, body io.Reader) error
 }
 
 var defaultManager Manager
 
 func Init(cfg *config.Config) error {
+	mgr, err := New(cfg)
+	if err != nil {
+		return err
+	}
+	defaultManager = mgr
+	return nil
+}
+
+// New は cfg.AttachmentStorage に応じたバックエンドを作る（移行ツールなどで既定以外のバックエンドを扱うときに使う）
+func New(cfg *config.Config) (Manager, error) {
 	switch strings.ToLower(cfg.AttachmentStorage) {
 	case "", "local":
-		defaultManager = &localManager{baseDir: cfg.UploadDir, backendURL: cfg.BackendURL}
-	case "s3":
-		mgr, err := newS3Manager(cfg)
-		if err != nil {
-			return err
+		// 空の鍵だと誰でも /uploads のトークンを作れてしまう
+		if cfg.StorageSigningSecret == "" {
+			return nil, fmt.Errorf("STORAGE_SIGNING_SECRET (or JWT_SECRET) is required for local storage")
 		}
-		defaultManager = mgr
+		return &localManager{baseDir: cfg.UploadDir, backendURL: cfg.BackendURL, secret: cfg.StorageSigningSecret}, nil
+	case "s3":
+		return newS3Manager(cfg)
 	default:
-		return fmt.Errorf("unsupported attachment storage: %s", cfg.AttachmentStorage)
+		return nil, fmt.Errorf("unsupported attachment storage: %s", cfg.AttachmentStorage)
 	}
-	return nil
 }
 
 func Default() Manager { return defaultManager }
@@ -51,30 +97,191 @@ func Default() Manager { return defaultManager }
 type localManager struct {
 	baseDir    string
 	backendURL string
+	secret     string
+}
+
+type uploadClaims struct {
+	Key         string `json:"k"`
+	ContentType string `json:"ct"`
+	Size        int64  `json:"sz"`
+	Exp         int64  `json:"exp"`
+}
+
+func (m *localManager) PublicURL(objectKey string) string {
+	return strings.TrimRight(m.backendURL, "/") + "/uploads/" + objectKey
 }
 
-func (m *localManager) SaveChatMedia(userID uint, file *multipart.FileHeader) (string, string, error) {
-	filename := fmt.Sprintf("%d_%d%s", userID, time.Now().UnixNano(), filepath.Ext(file.Filename))
-	dir := filepath.Join(m.baseDir, "chat", fmt.Sprintf("%d", userID))
-	if err := os.MkdirAll(dir, 0o755); err != nil {
-		return "", "", err
+func (m *localManager) SignedURL(objectKey string, ttl time.Duration) (string, error) {
+	return m.SignedDownloadURL(objectKey, "", ttl)
+}
+
+func (m *localManager) SignedDownloadURL(objectKey, filename string, ttl time.Duration) (string, error) {
+	exp := strconv.FormatInt(time.Now().Add(ttl).Unix(), 10)
+	q := url.Values{}
+	q.Set("exp", exp)
+	if filename != "" {
+		q.Set("dl", filename)
+	}
+	q.Set("sig", m.downloadSignature(objectKey, exp, filename))
+	return m.PublicURL(objectKey) + "?" + q.Encode(), nil
+}
+
+func (m *localManager) downloadSignature(objectKey, exp, download string) string {
+	mac := hmac.New(sha256.New, []byte(m.secret))
+	mac.Write([]byte("GET\n" + objectKey + "\n" + exp + "\n" + download))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// OpenSigned は SignedURL の署名と期限を検証してファイルパスを返す
+func (m *localManager) OpenSigned(objectKey, expires, download, signature string) (string, error) {
+	given, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil {
+		return "", ErrInvalidSignature
+	}
+	want, _ := base64.RawURLEncoding.DecodeString(m.downloadSignature(objectKey, expires, download))
+	if !hmac.Equal(given, want) {
+		return "", ErrInvalidSignature
 	}
-	path := filepath.Join(dir, filename)
-	if err := saveUploadedFile(file, path); err != nil {
-		return "", "", err
+	exp, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil {
+		return "", ErrInvalidSignature
 	}
-	rel := strings.TrimPrefix(path, filepath.Clean(m.baseDir)+string(os.PathSeparator))
-	public := strings.TrimRight(m.backendURL, "/") + "/uploads/" + filepath.ToSlash(rel)
-	return public, filepath.ToSlash(rel), nil
+	if time.Now().Unix() > exp {
+		return "", ErrSignatureExpired
+	}
+	// 署名済みのキーでも保存ディレクトリの外は参照させない
+	filePath := filepath.Join(m.baseDir, filepath.FromSlash(path.Clean("/"+objectKey)))
+	if _, err := os.Stat(filePath); err != nil {
+		if os.IsNotExist(err) {
+			return "", ErrNotFound
+		}
+		return "", err
+	}
+	return filePath, nil
+}
+
+func (m *localManager) PresignUpload(objectKey, contentType string, size int64, ttl time.Duration) (*UploadTarget, error) {
+	expiresAt := time.Now().Add(ttl)
+	token, err := signToken(m.secret, uploadClaims{Key: objectKey, ContentType: contentType, Size: size, Exp: expiresAt.Unix()})
+	if err != nil {
+		return nil, err
+	}
+	return &UploadTarget{
+		Method:    http.MethodPut,
+		URL:       strings.TrimRight(m.backendURL, "/") + "/api/uploads?token=" + url.QueryEscape(token),
+		Headers:   map[string]string{"Content-Type": contentType},
+		ExpiresAt: expiresAt,
+	}, nil
+}
+
+// ReceiveSignedUpload は署名条件（期限・Content-Type・サイズ）を検証して保存する
+func (m *localManager) ReceiveSignedUpload(token, contentType string, size int64, body io.Reader) error {
+	var claims uploadClaims
+	if err := verifyToken(m.secret, token, &claims); err != nil {
+		return err
+	}
+	if time.Now().Unix() > claims.Exp {
+		return ErrSignatureExpired
+	}
+	if contentType != claims.ContentType || size != claims.Size {
+		return ErrUploadMismatch
+	}
+
+	_, err := m.writeFile(claims.Key, body, claims.Size)
+	return err
+}
+
+// writeFile は body を一時ファイルに書いてから置き換える（途中で切れたファイルが見えないように）。
+// size が 0 以上なら長さが一致しないとき ErrUploadMismatch を返す。
+func (m *localManager) writeFile(objectKey string, body io.Reader, size int64) (int64, error) {
+	dst := filepath.Join(m.baseDir, filepath.FromSlash(objectKey))
+	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+		return 0, err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(dst), ".upload-*")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tmp.Name())
+	if size >= 0 {
+		body = io.LimitReader(body, size+1)
+	}
+	written, err := io.Copy(tmp, body)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return 0, err
+	}
+	if size >= 0 && written != size {
+		return 0, ErrUploadMismatch
+	}
+	if err := os.Chmod(tmp.Name(), 0o644); err != nil {
+		return 0, err
+	}
+	return written, os.Rename(tmp.Name(), dst)
+}
+
+func (m *localManager) Head(objectKey string) (*ObjectInfo, error) {
+	f, err := os.Open(filepath.Join(m.baseDir, filepath.FromSlash(objectKey)))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	defer f.Close()
+	stat, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	head := make([]byte, 512)
+	n, _ := io.ReadFull(f, head)
+	return &ObjectInfo{Size: stat.Size(), ContentType: http.DetectContentType(head[:n]), LastModified: stat.ModTime()}, nil
+}
+
+func (m *localManager) Put(objectKey string, body []byte, contentType string) error {
+	path := filepath.Join(m.baseDir, filepath.FromSlash(objectKey))
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, body, 0o644); err != nil {
+		return err
+	}
+	recordStored(objectKey, int64(len(body)))
+	return nil
+}
+
+func (m *localManager) PutStream(objectKey string, body io.Reader, size int64, contentType string) error {
+	written, err := m.writeFile(objectKey, body, size)
+	if err != nil {
+		return err
+	}
+	recordStored(objectKey, written)
+	return nil
+}
+
+func (m *localManager) Get(objectKey string) (io.ReadCloser, error) {
+	f, err := os.Open(filepath.Join(m.baseDir, filepath.FromSlash(objectKey)))
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	return f, err
 }
 
 func (m *localManager) Delete(objectKey string) error {
 	if objectKey == "" {
 		return nil
 	}
-	filePath := filepath.Join(m.baseDir, objectKey)
-	if _, err := os.Stat(filePath); err == nil {
-		return os.Remove(filePath)
+	for _, key := range append([]string{objectKey}, VariantKeys(objectKey)...) {
+		filePath := filepath.Join(m.baseDir, key)
+		if _, err := os.Stat(filePath); err != nil {
+			continue
+		}
+		if err := os.Remove(filePath); err != nil {
+			return err
+		}
+		recordDeleted(key)
 	}
 	return nil
 }
@@ -117,35 +324,51 @@ func newS3Manager(cfg *config.Config) (Manager, error) {
 	}, nil
 }
 
-func (s *s3Manager) SaveChatMedia(userID uint, file *multipart.FileHeader) (string, string, error) {
-	key := fmt.Sprintf("chat/%d/%d%s", userID, time.Now().UnixNano(), filepath.Ext(file.Filename))
-	src, err := file.Open()
+func (s *s3Manager) Put(objectKey string, body []byte, contentType string) error {
+	if err := s.putObject(objectKey, body, contentType); err != nil {
+		return err
+	}
+	recordStored(objectKey, int64(len(body)))
+	return nil
+}
+
+func (s *s3Manager) Get(objectKey string) (io.ReadCloser, error) {
+	resp, err := s.send(func() (*http.Request, error) {
+		return s.newRequest(http.MethodGet, objectKey, nil)
+	})
 	if err != nil {
-		return "", "", err
+		return nil, err
 	}
-	defer src.Close()
-	buf := bytes.NewBuffer(nil)
-	if _, err := io.Copy(buf, src); err != nil {
-		return "", "", err
+	if resp.StatusCode == http.StatusNotFound {
+		resp.Body.Close()
+		return nil, ErrNotFound
 	}
-	if err := s.putObject(key, buf.Bytes(), file.Header.Get("Content-Type")); err != nil {
-		return "", "", err
+	if resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		b, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("s3 get failed: %s %s", resp.Status, string(b))
 	}
-	return s.objectURL(key), key, nil
+	return resp.Body, nil
 }
 
+// Delete は元画像に続いてバリアントも削除する（存在しないキーの DELETE も S3 では成功扱い）
 func (s *s3Manager) Delete(objectKey string) error {
 	if objectKey == "" {
 		return nil
 	}
-	req, err := s.newRequest(http.MethodDelete, objectKey, nil)
-	if err != nil {
-		return err
-	}
-	if err := s.signRequest(req, hashSHA256(nil)); err != nil {
-		return err
+	for _, key := range append([]string{objectKey}, VariantKeys(objectKey)...) {
+		if err := s.deleteObject(key); err != nil {
+			return err
+		}
+		recordDeleted(key)
 	}
-	resp, err := s.client.Do(req)
+	return nil
+}
+
+func (s *s3Manager) deleteObject(objectKey string) error {
+	resp, err := s.send(func() (*http.Request, error) {
+		return s.newRequest(http.MethodDelete, objectKey, nil)
+	})
 	if err != nil {
 		return err
 	}
@@ -158,19 +381,18 @@ func (s *s3Manager) Delete(objectKey string) error {
 }
 
 func (s *s3Manager) putObject(key string, body []byte, contentType string) error {
-	req, err := s.newRequest(http.MethodPut, key, bytes.NewReader(body))
-	if err != nil {
-		return err
-	}
-	if contentType != "" {
-		req.Header.Set("Content-Type", contentType)
-	}
 	hash := hashSHA256(body)
-	req.Header.Set("x-amz-content-sha256", hash)
-	if err := s.signRequest(req, hash); err != nil {
-		return err
-	}
-	resp, err := s.client.Do(req)
+	resp, err := s.send(func() (*http.Request, error) {
+		req, err := s.newRequest(http.MethodPut, key, bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		req.Header.Set("x-amz-content-sha256", hash)
+		return req, nil
+	})
 	if err != nil {
 		return err
 	}
@@ -182,18 +404,12 @@ func (s *s3Manager) putObject(key string, body []byte, contentType string) error
 	return nil
 }
 
-func (s *s3Manager) objectURL(key string) string {
-	u := *s.endpoint
-	if s.usePathURL {
-		u.Path = strings.TrimSuffix(u.Path, "/") + "/" + path.Join(s.bucket, key)
-	} else {
-		u.Host = s.bucket + "." + u.Host
-		u.Path = strings.TrimSuffix(u.Path, "/") + "/" + key
-	}
+func (s *s3Manager) PublicURL(key string) string {
+	u := s.objectLocation(key)
 	return u.String()
 }
 
-func (s *s3Manager) newRequest(method, key string, body io.Reader) (*http.Request, error) {
+func (s *s3Manager) objectLocation(key string) url.URL {
 	u := *s.endpoint
 	if s.usePathURL {
 		u.Path = strings.TrimSuffix(u.Path, "/") + "/" + path.Join(s.bucket, key)
@@ -201,6 +417,113 @@ func (s *s3Manager) newRequest(method, key string, body io.Reader) (*http.Reques
 		u.Host = s.bucket + "." + u.Host
 		u.Path = strings.TrimSuffix(u.Path, "/") + "/" + key
 	}
+	return u
+}
+
+func (s *s3Manager) Head(objectKey string) (*ObjectInfo, error) {
+	resp, err := s.send(func() (*http.Request, error) {
+		return s.newRequest(http.MethodHead, objectKey, nil)
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, ErrNotFound
+	}
+	if resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("s3 head failed: %s", resp.Status)
+	}
+	info := &ObjectInfo{Size: resp.ContentLength, ContentType: resp.Header.Get("Content-Type"), ETag: resp.Header.Get("ETag")}
+	if t, err := http.ParseTime(resp.Header.Get("Last-Modified")); err == nil {
+		info.LastModified = t
+	}
+	return info, nil
+}
+
+func (s *s3Manager) SignedURL(objectKey string, ttl time.Duration) (string, error) {
+	return s.presign(http.MethodGet, objectKey, nil, nil, ttl, time.Now())
+}
+
+// SignedDownloadURL は response-content-disposition を署名に含めて S3 にヘッダーを付けさせる
+func (s *s3Manager) SignedDownloadURL(objectKey, filename string, ttl time.Duration) (string, error) {
+	params := url.Values{}
+	if filename != "" {
+		params.Set("response-content-disposition", ContentDisposition(filename))
+	}
+	return s.presign(http.MethodGet, objectKey, nil, params, ttl, time.Now())
+}
+
+// PresignUpload は SigV4 のクエリ署名で PUT 用 URL を作る（本文は UNSIGNED-PAYLOAD）
+func (s *s3Manager) PresignUpload(objectKey, contentType string, size int64, ttl time.Duration) (*UploadTarget, error) {
+	headers := map[string]string{
+		"Content-Type":   contentType,
+		"Content-Length": strconv.FormatInt(size, 10),
+	}
+	signed, err := s.presign(http.MethodPut, objectKey, headers, nil, ttl, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	// Content-Length はブラウザが自動で付けるため返さない
+	return &UploadTarget{
+		Method:    http.MethodPut,
+		URL:       signed,
+		Headers:   map[string]string{"Content-Type": contentType},
+		ExpiresAt: time.Now().Add(ttl),
+	}, nil
+}
+
+func (s *s3Manager) presign(method, key string, headers map[string]string, params url.Values, ttl time.Duration, at time.Time) (string, error) {
+	seconds := int64(ttl / time.Second)
+	if seconds <= 0 || seconds > 7*24*3600 {
+		return "", fmt.Errorf("invalid presign ttl: %s", ttl)
+	}
+	u := s.objectLocation(key)
+	now := at.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	dateStamp := now.Format("20060102")
+	scope := fmt.Sprintf("%s/%s/s3/aws4_request", dateStamp, s.region)
+
+	canon := map[string]string{"host": strings.ToLower(u.Host)}
+	for k, v := range headers {
+		canon[strings.ToLower(k)] = strings.TrimSpace(v)
+	}
+	names := make([]string, 0, len(canon))
+	for k := range canon {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	lines := make([]string, 0, len(names))
+	for _, k := range names {
+		lines = append(lines, k+":"+canon[k])
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	q := url.Values{}
+	for k, vs := range params {
+		q[k] = vs
+	}
+	q.Set("X-Amz-Algorithm", "AWS4-HMAC-SHA256")
+	q.Set("X-Amz-Credential", s.accessKey+"/"+scope)
+	q.Set("X-Amz-Date", amzDate)
+	q.Set("X-Amz-Expires", strconv.FormatInt(seconds, 10))
+	q.Set("X-Amz-SignedHeaders", signedHeaders)
+	query := canonicalQuery(q.Encode())
+
+	canonRequest := strings.Join([]string{
+		method,
+		canonicalURI(u.Path),
+		query,
+		strings.Join(lines, "\n") + "\n",
+		signedHeaders,
+		"UNSIGNED-PAYLOAD",
+	}, "\n")
+	u.RawQuery = query + "&X-Amz-Signature=" + s.signature(amzDate, dateStamp, scope, canonRequest)
+	return u.String(), nil
+}
+
+func (s *s3Manager) newRequest(method, key string, body io.Reader) (*http.Request, error) {
+	u := s.objectLocation(key)
 	req, err := http.NewRequest(method, u.String(), body)
 	if err != nil {
 		return nil, err
@@ -230,6 +553,13 @@ func (s *s3Manager) signRequest(req *http.Request, payloadHash string) error {
 		req.Header.Get("x-amz-content-sha256"),
 	}, "\n")
 	scope := fmt.Sprintf("%s/%s/s3/aws4_request", dateStamp, s.region)
+	signature := s.signature(amzDate, dateStamp, scope, canonRequest)
+	auth := fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s", s.accessKey, scope, signedHeaders, signature)
+	req.Header.Set("Authorization", auth)
+	return nil
+}
+
+func (s *s3Manager) signature(amzDate, dateStamp, scope, canonRequest string) string {
 	stringToSign := strings.Join([]string{
 		"AWS4-HMAC-SHA256",
 		amzDate,
@@ -237,10 +567,7 @@ func (s *s3Manager) signRequest(req *http.Request, payloadHash string) error {
 		hashSHA256([]byte(canonRequest)),
 	}, "\n")
 	key := deriveSigningKey(s.secretKey, dateStamp, s.region, "s3")
-	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
-	auth := fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s", s.accessKey, scope, signedHeaders, signature)
-	req.Header.Set("Authorization", auth)
-	return nil
+	return hex.EncodeToString(hmacSHA256(key, stringToSign))
 }
 
 // helper functions for SigV4
@@ -270,12 +597,17 @@ func canonicalQuery(raw string) string {
 		vs := values[k]
 		sort.Strings(vs)
 		for _, v := range vs {
-			parts = append(parts, url.QueryEscape(k)+"="+url.QueryEscape(v))
+			parts = append(parts, uriEncode(k)+"="+uriEncode(v))
 		}
 	}
 	return strings.Join(parts, "&")
 }
 
+// uriEncode は SigV4 の URI エンコード（空白は + ではなく %20）
+func uriEncode(s string) string {
+	return strings.ReplaceAll(url.QueryEscape(s), "+", "%20")
+}
+
 func canonicalHeaders(req *http.Request) (string, string) {
 	type pair struct{ key, value string }
 	headers := []pair{{"host", strings.ToLower(req.Host)}}
@@ -0,0 +1,281 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+const (
+	s3MaxAttempts    = 4
+	s3RetryBaseDelay = 200 * time.Millisecond
+	// これを超える（またはサイズ不明の）アップロードは分割アップロードにする
+	s3MultipartThreshold = 16 << 20
+	// 分割アップロードの 1 パート（S3 の下限は最後のパート以外 5MB）。パートごとにメモリへ読み込んで再送に備える
+	s3PartSize = 8 << 20
+
+	unsignedPayload = "UNSIGNED-PAYLOAD"
+)
+
+// send は build で作ったリクエストを署名して送り、通信エラー・5xx・429 のときは間隔を空けて作り直して再送する。
+// build は試行ごとに呼ばれるので、本文は毎回先頭から読めるものを返すこと。
+// x-amz-content-sha256 を設定しなければ空の本文として署名する。
+func (s *s3Manager) send(build func() (*http.Request, error)) (*http.Response, error) {
+	var lastErr error
+	for attempt := 0; attempt < s3MaxAttempts; attempt++ {
+		if attempt > 0 {
+			time.Sleep(s3Backoff(attempt))
+		}
+		req, err := build()
+		if err != nil {
+			return nil, err
+		}
+		if err := s.signRequest(req, hashSHA256(nil)); err != nil {
+			return nil, err
+		}
+		resp, err := s.client.Do(req)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if !retryableStatus(resp.StatusCode) || attempt == s3MaxAttempts-1 {
+			return resp, nil
+		}
+		b, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		resp.Body.Close()
+		lastErr = fmt.Errorf("s3 %s %s: %s %s", req.Method, req.URL.Path, resp.Status, string(b))
+		log.Printf("⚠️ retrying s3 request (%d/%d): %v", attempt+1, s3MaxAttempts, lastErr)
+	}
+	return nil, lastErr
+}
+
+// 429 と 5xx（SlowDown は 503）は時間を置けば通る可能性がある
+func retryableStatus(code int) bool {
+	return code == http.StatusTooManyRequests || code >= 500
+}
+
+// s3Backoff は 200ms, 400ms, 800ms ... に同程度のゆらぎを加えた待ち時間
+func s3Backoff(attempt int) time.Duration {
+	d := s3RetryBaseDelay << (attempt - 1)
+	return d + time.Duration(rand.Int63n(int64(d)))
+}
+
+// PutStream は body を読みながら保存する（size が不明なら -1）。
+// 大きいもの・サイズ不明のものは分割アップロード、io.ReadSeeker は UNSIGNED-PAYLOAD でそのまま送る。
+func (s *s3Manager) PutStream(objectKey string, body io.Reader, size int64, contentType string) error {
+	var err error
+	written := size
+	switch {
+	case size < 0 || size > s3MultipartThreshold:
+		written, err = s.putMultipart(objectKey, body, contentType)
+	case isSeeker(body):
+		err = s.putStreamed(objectKey, body.(io.ReadSeeker), size, contentType)
+	default:
+		buf := make([]byte, size)
+		if _, err = io.ReadFull(body, buf); err == nil {
+			err = s.putObject(objectKey, buf, contentType)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	recordStored(objectKey, written)
+	return nil
+}
+
+func isSeeker(r io.Reader) bool {
+	_, ok := r.(io.ReadSeeker)
+	return ok
+}
+
+// putStreamed は本文をハッシュせずに送る（再送時は読み始めの位置に戻す）
+func (s *s3Manager) putStreamed(key string, body io.ReadSeeker, size int64, contentType string) error {
+	start, err := body.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	resp, err := s.send(func() (*http.Request, error) {
+		if _, err := body.Seek(start, io.SeekStart); err != nil {
+			return nil, err
+		}
+		req, err := s.newRequest(http.MethodPut, key, io.NopCloser(io.LimitReader(body, size)))
+		if err != nil {
+			return nil, err
+		}
+		// 長さが分からないと chunked で送られ、S3 では受け付けられない
+		req.ContentLength = size
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		req.Header.Set("x-amz-content-sha256", unsignedPayload)
+		return req, nil
+	})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		b, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("s3 upload failed: %s %s", resp.Status, string(b))
+	}
+	return nil
+}
+
+type s3CompletedPart struct {
+	PartNumber int    `xml:"PartNumber"`
+	ETag       string `xml:"ETag"`
+}
+
+// putMultipart は s3PartSize ごとに読み込んでパートとして送り、最後にまとめる。失敗したら途中のパートを破棄する。
+func (s *s3Manager) putMultipart(key string, body io.Reader, contentType string) (int64, error) {
+	buf := make([]byte, s3PartSize)
+	n, err := io.ReadFull(body, buf)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return 0, err
+	}
+	// 1 パートに収まるなら通常の PUT で済ませる（空のオブジェクトは分割アップロードできない）
+	if n < s3PartSize {
+		return int64(n), s.putObject(key, buf[:n], contentType)
+	}
+
+	uploadID, err := s.createMultipartUpload(key, contentType)
+	if err != nil {
+		return 0, err
+	}
+	var parts []s3CompletedPart
+	var total int64
+	for partNumber := 1; n > 0; partNumber++ {
+		etag, err := s.uploadPart(key, uploadID, partNumber, buf[:n])
+		if err != nil {
+			s.abortMultipartUpload(key, uploadID)
+			return 0, err
+		}
+		parts = append(parts, s3CompletedPart{PartNumber: partNumber, ETag: etag})
+		total += int64(n)
+
+		n, err = io.ReadFull(body, buf)
+		if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+			s.abortMultipartUpload(key, uploadID)
+			return 0, err
+		}
+	}
+	if err := s.completeMultipartUpload(key, uploadID, parts); err != nil {
+		s.abortMultipartUpload(key, uploadID)
+		return 0, err
+	}
+	return total, nil
+}
+
+func (s *s3Manager) multipartRequest(method, key string, query url.Values, body []byte) func() (*http.Request, error) {
+	hash := hashSHA256(body)
+	return func() (*http.Request, error) {
+		var reader io.Reader
+		if body != nil {
+			reader = bytes.NewReader(body)
+		}
+		req, err := s.newRequest(method, key, reader)
+		if err != nil {
+			return nil, err
+		}
+		req.URL.RawQuery = query.Encode()
+		req.Header.Set("x-amz-content-sha256", hash)
+		return req, nil
+	}
+}
+
+func (s *s3Manager) createMultipartUpload(key, contentType string) (string, error) {
+	build := s.multipartRequest(http.MethodPost, key, url.Values{"uploads": {""}}, nil)
+	resp, err := s.send(func() (*http.Request, error) {
+		req, err := build()
+		if err == nil && contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		return req, err
+	})
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		b, _ := io.ReadAll(resp.Body)
+		return "", fmt.Errorf("s3 create multipart upload failed: %s %s", resp.Status, string(b))
+	}
+	var result struct {
+		UploadID string `xml:"UploadId"`
+	}
+	if err := xml.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", err
+	}
+	if result.UploadID == "" {
+		return "", errors.New("s3 create multipart upload returned no upload id")
+	}
+	return result.UploadID, nil
+}
+
+func (s *s3Manager) uploadPart(key, uploadID string, partNumber int, data []byte) (string, error) {
+	query := url.Values{"partNumber": {strconv.Itoa(partNumber)}, "uploadId": {uploadID}}
+	resp, err := s.send(s.multipartRequest(http.MethodPut, key, query, data))
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		b, _ := io.ReadAll(resp.Body)
+		return "", fmt.Errorf("s3 upload part %d failed: %s %s", partNumber, resp.Status, string(b))
+	}
+	etag := resp.Header.Get("ETag")
+	if etag == "" {
+		return "", fmt.Errorf("s3 upload part %d returned no etag", partNumber)
+	}
+	return etag, nil
+}
+
+func (s *s3Manager) completeMultipartUpload(key, uploadID string, parts []s3CompletedPart) error {
+	body, err := xml.Marshal(struct {
+		XMLName xml.Name          `xml:"CompleteMultipartUpload"`
+		Parts   []s3CompletedPart `xml:"Part"`
+	}{Parts: parts})
+	if err != nil {
+		return err
+	}
+	resp, err := s.send(s.multipartRequest(http.MethodPost, key, url.Values{"uploadId": {uploadID}}, body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	b, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("s3 complete multipart upload failed: %s %s", resp.Status, string(b))
+	}
+	// 完了処理は 200 のまま本文で失敗を返すことがある
+	var result struct {
+		XMLName xml.Name
+		Code    string `xml:"Code"`
+		Message string `xml:"Message"`
+	}
+	if err := xml.Unmarshal(b, &result); err == nil && result.XMLName.Local == "Error" {
+		return fmt.Errorf("s3 complete multipart upload failed: %s %s", result.Code, result.Message)
+	}
+	return nil
+}
+
+// abortMultipartUpload は途中まで送ったパートを破棄する（失敗してもバケットのライフサイクル設定で消える）
+func (s *s3Manager) abortMultipartUpload(key, uploadID string) {
+	resp, err := s.send(s.multipartRequest(http.MethodDelete, key, url.Values{"uploadId": {uploadID}}, nil))
+	if err != nil {
+		log.Printf("⚠️ failed to abort multipart upload %s: %v", key, err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 && resp.StatusCode != http.StatusNotFound {
+		log.Printf("⚠️ failed to abort multipart upload %s: %s", key, resp.Status)
+	}
+}
@@ -0,0 +1,294 @@
+package storage
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	"chillow/config"
+)
+
+// S3 互換ストレージ（MinIO など）に対する結合テスト。S3_TEST_ENDPOINT を設定したときだけ実行する。
+//
+//	docker compose --profile s3 up -d minio minio-init
+//	S3_TEST_ENDPOINT=http://localhost:9000 go test ./storage
+//
+// 障害はテストとストレージの間に挟んだプロキシで起こす。
+
+// faultProxy は fail が応答を返したリクエストをストレージへ渡さずにその応答で返す
+type faultProxy struct {
+	proxy *httputil.ReverseProxy
+
+	mu       sync.Mutex
+	fail     func(r *http.Request, body []byte) (int, string, bool)
+	requests []string // "METHOD パス?クエリ"
+}
+
+func (p *faultProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	p.mu.Lock()
+	p.requests = append(p.requests, r.Method+" "+r.URL.RequestURI())
+	fail := p.fail
+	p.mu.Unlock()
+	if fail != nil {
+		if status, resp, ok := fail(r, body); ok {
+			w.WriteHeader(status)
+			io.WriteString(w, resp)
+			return
+		}
+	}
+	p.proxy.ServeHTTP(w, r)
+}
+
+func (p *faultProxy) setFail(fail func(r *http.Request, body []byte) (int, string, bool)) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.fail = fail
+	p.requests = nil
+}
+
+// count は条件に合うリクエストの数
+func (p *faultProxy) count(match func(req string) bool) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	n := 0
+	for _, req := range p.requests {
+		if match(req) {
+			n++
+		}
+	}
+	return n
+}
+
+// failTimes は match に合うリクエストを最初の n 回だけ status で失敗させる
+func failTimes(n, status int, match func(r *http.Request) bool) func(*http.Request, []byte) (int, string, bool) {
+	var mu sync.Mutex
+	return func(r *http.Request, _ []byte) (int, string, bool) {
+		mu.Lock()
+		defer mu.Unlock()
+		if n == 0 || !match(r) {
+			return 0, "", false
+		}
+		n--
+		return status, "<Error><Code>SlowDown</Code></Error>", true
+	}
+}
+
+func newTestS3(t *testing.T) (*s3Manager, *faultProxy) {
+	t.Helper()
+	endpoint := os.Getenv("S3_TEST_ENDPOINT")
+	if endpoint == "" {
+		t.Skip("S3_TEST_ENDPOINT is not set")
+	}
+	target, err := url.Parse(endpoint)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &faultProxy{proxy: httputil.NewSingleHostReverseProxy(target)}
+	srv := httptest.NewServer(p)
+	t.Cleanup(srv.Close)
+
+	m, err := newS3Manager(&config.Config{
+		S3Bucket:       getTestEnv("S3_TEST_BUCKET", "chillow"),
+		S3Region:       getTestEnv("S3_TEST_REGION", "us-east-1"),
+		S3Endpoint:     srv.URL,
+		S3AccessKey:    getTestEnv("S3_TEST_ACCESS_KEY", "minioadmin"),
+		S3SecretKey:    getTestEnv("S3_TEST_SECRET_KEY", "minioadmin"),
+		S3UsePathStyle: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m.(*s3Manager), p
+}
+
+func getTestEnv(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
+
+func testObjectKey(t *testing.T) string {
+	return "test/" + strings.ReplaceAll(t.Name(), "/", "-")
+}
+
+// assertObject は保存された中身が want と一致することを確認して削除する
+func assertObject(t *testing.T, s *s3Manager, key string, want []byte) {
+	t.Helper()
+	defer s.deleteObject(key)
+	r, err := s.Get(key)
+	if err != nil {
+		t.Fatalf("get %s: %v", key, err)
+	}
+	defer r.Close()
+	got, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatalf("stored %d bytes, want %d", len(got), len(want))
+	}
+}
+
+func testPayload(size int) []byte {
+	data := make([]byte, size)
+	for i := range data {
+		data[i] = byte(i*7 + i/s3PartSize)
+	}
+	return data
+}
+
+func isPart(req string) bool { return strings.Contains(req, "partNumber=") }
+
+func TestS3PutMultipart(t *testing.T) {
+	s, proxy := newTestS3(t)
+
+	t.Run("three parts", func(t *testing.T) {
+		proxy.setFail(nil)
+		key := testObjectKey(t)
+		data := testPayload(2*s3PartSize + 1234)
+		// サイズ不明なら分割アップロードになる
+		if err := s.PutStream(key, bytes.NewReader(data), -1, "application/octet-stream"); err != nil {
+			t.Fatal(err)
+		}
+		if n := proxy.count(isPart); n != 3 {
+			t.Fatalf("uploaded %d parts, want 3", n)
+		}
+		assertObject(t, s, key, data)
+	})
+
+	t.Run("smaller than a part", func(t *testing.T) {
+		proxy.setFail(nil)
+		key := testObjectKey(t)
+		data := testPayload(1000)
+		if err := s.PutStream(key, bytes.NewReader(data), -1, "text/plain"); err != nil {
+			t.Fatal(err)
+		}
+		if n := proxy.count(func(req string) bool { return strings.Contains(req, "uploads") || isPart(req) }); n != 0 {
+			t.Fatalf("sent %d multipart requests for a single-part body", n)
+		}
+		info, err := s.Head(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.ContentType != "text/plain" {
+			t.Errorf("content type = %q", info.ContentType)
+		}
+		assertObject(t, s, key, data)
+	})
+
+	t.Run("part retried", func(t *testing.T) {
+		proxy.setFail(failTimes(1, http.StatusServiceUnavailable, func(r *http.Request) bool {
+			return r.URL.Query().Get("partNumber") == "2"
+		}))
+		key := testObjectKey(t)
+		data := testPayload(2*s3PartSize + 10)
+		if err := s.PutStream(key, bytes.NewReader(data), -1, ""); err != nil {
+			t.Fatal(err)
+		}
+		if n := proxy.count(isPart); n != 4 {
+			t.Fatalf("sent %d part requests, want 4 (one retry)", n)
+		}
+		assertObject(t, s, key, data)
+	})
+
+	// 完了処理が 200 のまま本文で失敗を返したら、エラーにして途中のパートを破棄する
+	t.Run("complete error body", func(t *testing.T) {
+		proxy.setFail(func(r *http.Request, _ []byte) (int, string, bool) {
+			q := r.URL.Query()
+			if r.Method != http.MethodPost || q.Get("uploadId") == "" {
+				return 0, "", false
+			}
+			return http.StatusOK, `<?xml version="1.0" encoding="UTF-8"?>
+<Error><Code>InternalError</Code><Message>We encountered an internal error.</Message></Error>`, true
+		})
+		key := testObjectKey(t)
+		err := s.PutStream(key, bytes.NewReader(testPayload(s3PartSize+1)), -1, "")
+		if err == nil || !strings.Contains(err.Error(), "InternalError") {
+			t.Fatalf("err = %v, want the InternalError from the body", err)
+		}
+		if n := proxy.count(func(req string) bool { return strings.HasPrefix(req, "DELETE ") && strings.Contains(req, "uploadId=") }); n != 1 {
+			t.Fatalf("sent %d abort requests, want 1", n)
+		}
+		if _, err := s.Head(key); err != ErrNotFound {
+			t.Fatalf("head after failed upload: %v", err)
+		}
+	})
+}
+
+// 再送時は本文を読み始めの位置に戻して送り直す
+func TestS3PutStreamedRetry(t *testing.T) {
+	s, proxy := newTestS3(t)
+	proxy.setFail(failTimes(2, http.StatusServiceUnavailable, func(r *http.Request) bool {
+		return r.Method == http.MethodPut
+	}))
+	key := testObjectKey(t)
+	data := testPayload(64 << 10)
+	body := bytes.NewReader(append([]byte("skipped-prefix"), data...))
+	body.Seek(int64(len("skipped-prefix")), io.SeekStart)
+	if err := s.PutStream(key, body, int64(len(data)), "application/octet-stream"); err != nil {
+		t.Fatal(err)
+	}
+	if n := proxy.count(func(req string) bool { return strings.HasPrefix(req, "PUT ") }); n != 3 {
+		t.Fatalf("sent %d PUTs, want 3", n)
+	}
+	assertObject(t, s, key, data)
+}
+
+func TestS3SendRetry(t *testing.T) {
+	s, proxy := newTestS3(t)
+	key := testObjectKey(t)
+	if err := s.Put(key, []byte("hello"), "text/plain"); err != nil {
+		t.Fatal(err)
+	}
+	defer s.deleteObject(key)
+
+	isGet := func(r *http.Request) bool { return r.Method == http.MethodGet }
+	gets := func(req string) bool { return strings.HasPrefix(req, "GET ") }
+
+	// 503 が続いても上限までに通れば成功する
+	proxy.setFail(failTimes(s3MaxAttempts-1, http.StatusServiceUnavailable, isGet))
+	r, err := s.Get(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, _ := io.ReadAll(r)
+	r.Close()
+	if string(got) != "hello" {
+		t.Fatalf("got %q", got)
+	}
+	if n := proxy.count(gets); n != s3MaxAttempts {
+		t.Fatalf("sent %d GETs, want %d", n, s3MaxAttempts)
+	}
+
+	// 上限を超えたら最後の応答で失敗する
+	proxy.setFail(failTimes(s3MaxAttempts, http.StatusServiceUnavailable, isGet))
+	if _, err := s.Get(key); err == nil || !strings.Contains(err.Error(), "503") {
+		t.Fatalf("err = %v, want 503", err)
+	}
+	if n := proxy.count(gets); n != s3MaxAttempts {
+		t.Fatalf("sent %d GETs, want %d", n, s3MaxAttempts)
+	}
+
+	// 4xx は再送しない
+	proxy.setFail(failTimes(1, http.StatusForbidden, isGet))
+	if _, err := s.Get(key); err == nil {
+		t.Fatal("403 was not an error")
+	}
+	if n := proxy.count(gets); n != 1 {
+		t.Fatalf("sent %d GETs for a 403, want 1", n)
+	}
+}
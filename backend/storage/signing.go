@@ -0,0 +1,77 @@
+package storage
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+var (
+	ErrNotFound         = errors.New("object not found")
+	ErrInvalidSignature = errors.New("invalid signature")
+	ErrSignatureExpired = errors.New("signature expired")
+	ErrUploadMismatch   = errors.New("upload does not match signed conditions")
+)
+
+// UploadTarget はクライアントが直接アップロードする先
+type UploadTarget struct {
+	Method    string            `json:"method"`
+	URL       string            `json:"url"`
+	Headers   map[string]string `json:"headers"` // 署名に含めたヘッダー（同じ値で送る必要がある）
+	ExpiresAt time.Time         `json:"expires_at"`
+}
+
+type ObjectInfo struct {
+	Size         int64
+	ContentType  string
+	LastModified time.Time
+	ETag         string // S3 のみ（分割アップロードしたものは MD5 ではない）
+}
+
+// ChatObjectKey は添付のオブジェクトキーを発行する
+func ChatObjectKey(userID uint, filename string) string {
+	return fmt.Sprintf("chat/%d/%d%s", userID, time.Now().UnixNano(), strings.ToLower(filepath.Ext(filename)))
+}
+
+// ---- HMAC トークン（ローカル保存用の署名付き URL） ----
+
+func signToken(secret string, claims any) (string, error) {
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	body := base64.RawURLEncoding.EncodeToString(payload)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(body))
+	return body + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil)), nil
+}
+
+func verifyToken(secret, token string, claims any) error {
+	body, sig, ok := strings.Cut(token, ".")
+	if !ok {
+		return ErrInvalidSignature
+	}
+	given, err := base64.RawURLEncoding.DecodeString(sig)
+	if err != nil {
+		return ErrInvalidSignature
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(body))
+	if !hmac.Equal(given, mac.Sum(nil)) {
+		return ErrInvalidSignature
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(body)
+	if err != nil {
+		return ErrInvalidSignature
+	}
+	if err := json.Unmarshal(payload, claims); err != nil {
+		return ErrInvalidSignature
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package storage
+
+import (
+	"log"
+	"strconv"
+	"strings"
+)
+
+// UsageRecorder は保存・削除したオブジェクトを持ち主ごとに記録する（容量の集計・上限の確認用）。
+// 署名付き URL で直接アップロードされた未確認のオブジェクトは Put を通らないので記録されない。
+type UsageRecorder interface {
+	ObjectStored(ownerID uint, objectKey string, size int64) error
+	ObjectDeleted(objectKey string) error
+}
+
+var usageRecorder UsageRecorder
+
+// SetUsageRecorder は Init の後に一度だけ呼ぶ
+func SetUsageRecorder(r UsageRecorder) { usageRecorder = r }
+
+// ObjectOwner は chat/<userID>/... 形式のキーから持ち主のユーザーID を返す
+func ObjectOwner(objectKey string) (uint, bool) {
+	rest, ok := strings.CutPrefix(objectKey, "chat/")
+	if !ok {
+		return 0, false
+	}
+	idPart, _, ok := strings.Cut(rest, "/")
+	if !ok {
+		return 0, false
+	}
+	id, err := strconv.ParseUint(idPart, 10, 64)
+	if err != nil || id == 0 {
+		return 0, false
+	}
+	return uint(id), true
+}
+
+// 記録に失敗しても保存・削除自体は成功しているので、ログだけ残す
+func recordStored(objectKey string, size int64) {
+	if usageRecorder == nil {
+		return
+	}
+	ownerID, ok := ObjectOwner(objectKey)
+	if !ok {
+		return
+	}
+	if err := usageRecorder.ObjectStored(ownerID, objectKey, size); err != nil {
+		log.Printf("⚠️ failed to record storage usage for %s: %v", objectKey, err)
+	}
+}
+
+func recordDeleted(objectKey string) {
+	if usageRecorder == nil {
+		return
+	}
+	if err := usageRecorder.ObjectDeleted(objectKey); err != nil {
+		log.Printf("⚠️ failed to record storage release for %s: %v", objectKey, err)
+	}
+}
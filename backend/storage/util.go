@@ -1,24 +0,0 @@
-package storage
-
-import (
-	"io"
-	"mime/multipart"
-	"os"
-)
-
-func saveUploadedFile(header *multipart.FileHeader, dst string) error {
-	src, err := header.Open()
-	if err != nil {
-		return err
-	}
-	defer src.Close()
-
-	out, err := os.Create(dst)
-	if err != nil {
-		return err
-	}
-	defer out.Close()
-
-	_, err = io.Copy(out, src)
-	return err
-}
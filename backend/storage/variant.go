@@ -0,0 +1,27 @@
+package storage
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// 画像のバリアント（元画像と同じ場所に <キー>.<名前>.jpg で保存する）
+const (
+	VariantThumb  = "thumb"
+	VariantMedium = "medium"
+)
+
+var variantNames = []string{VariantThumb, VariantMedium}
+
+// VariantKey は元オブジェクトのキーからバリアントのキーを返す
+func VariantKey(objectKey, variant string) string {
+	return strings.TrimSuffix(objectKey, filepath.Ext(objectKey)) + "." + variant + ".jpg"
+}
+
+func VariantKeys(objectKey string) []string {
+	keys := make([]string, 0, len(variantNames))
+	for _, name := range variantNames {
+		keys = append(keys, VariantKey(objectKey, name))
+	}
+	return keys
+}
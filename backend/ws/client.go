@@ -2,9 +2,16 @@ package ws
 
 import (
 	"encoding/json"
+	"errors"
+	"log"
+	"net"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"chillow/config"
+	"chillow/errcode"
+
 	"github.com/gorilla/websocket"
 )
 
@@ -14,65 +21,131 @@ type Client struct {
 	hub         *Hub
 	send        chan []byte
 	joinedRooms map[string]struct{}
+	protocol    int
+	away        atomic.Bool // presence:set で離席中
+	hidden      atomic.Bool // hide_presence（ルームの在室者にも含めない）
 	closeOnce   sync.Once
+
+	pingInterval time.Duration
+	pongTimeout  time.Duration
+	writeTimeout time.Duration
 }
 
 func NewClient(userID uint, conn *websocket.Conn, hub *Hub) *Client {
+	conn.SetReadLimit(int64(config.Cfg.WSMaxMessageBytes))
 	return &Client{
-		userID:      userID,
-		conn:        conn,
-		hub:         hub,
-		send:        make(chan []byte, 256),
-		joinedRooms: make(map[string]struct{}),
+		userID:       userID,
+		conn:         conn,
+		hub:          hub,
+		send:         make(chan []byte, 256),
+		joinedRooms:  make(map[string]struct{}),
+		protocol:     protocolVersion(conn.Subprotocol()),
+		pingInterval: config.Cfg.WSPingInterval,
+		pongTimeout:  config.Cfg.WSPongTimeout,
+		writeTimeout: config.Cfg.WSWriteTimeout,
 	}
 }
 
+// SetHidePresence は接続時のユーザーの hide_presence を設定する（Start より前に呼ぶ）
+func (c *Client) SetHidePresence(hidden bool) { c.hidden.Store(hidden) }
+
 func (c *Client) Start() {
 	registerClient(c)
+	c.hub.userStatusChanged(c.userID)
+	metrics.opened.Add(1)
+	metrics.active.Add(1)
 	go c.writeLoop()
 	go c.readLoop()
 }
 
 func (c *Client) readLoop() {
 	defer c.Close()
+	_ = c.conn.SetReadDeadline(time.Now().Add(c.pongTimeout))
+	c.conn.SetPongHandler(func(string) error {
+		return c.conn.SetReadDeadline(time.Now().Add(c.pongTimeout))
+	})
 	for {
 		_, msg, err := c.conn.ReadMessage()
 		if err != nil {
+			c.handleReadError(err)
 			break
 		}
+		// アプリ層のメッセージ受信も生存確認とみなす
+		_ = c.conn.SetReadDeadline(time.Now().Add(c.pongTimeout))
 		Dispatch(c, msg)
 	}
 }
 
+func (c *Client) handleReadError(err error) {
+	var netErr net.Error
+	switch {
+	case errors.Is(err, websocket.ErrReadLimit):
+		// 1009 の Close フレームは gorilla/websocket が送信済み
+		metrics.reapedOversize.Add(1)
+		log.Printf("✂️ ws user %d exceeded max message size", c.userID)
+	case errors.As(err, &netErr) && netErr.Timeout():
+		metrics.reapedIdle.Add(1)
+		log.Printf("✂️ ws user %d reaped: heartbeat timeout", c.userID)
+		c.CloseWith(websocket.CloseGoingAway, "heartbeat timeout")
+	}
+}
+
 func (c *Client) writeLoop() {
-	defer c.Close()
+	ticker := time.NewTicker(c.pingInterval)
+	defer func() {
+		ticker.Stop()
+		c.Close()
+	}()
 	for {
 		select {
 		case msg, ok := <-c.send:
 			if !ok {
 				return
 			}
-			c.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+			_ = c.conn.SetWriteDeadline(time.Now().Add(c.writeTimeout))
 			if err := c.conn.WriteMessage(websocket.TextMessage, msg); err != nil {
 				return
 			}
+		case <-ticker.C:
+			_ = c.conn.SetWriteDeadline(time.Now().Add(c.writeTimeout))
+			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
 		}
 	}
 }
 
 func (c *Client) Close() {
+	c.CloseWith(websocket.CloseNormalClosure, "")
+}
+
+// CloseWith は Close フレームにコードと理由を載せてから切断する
+func (c *Client) CloseWith(code int, reason string) {
 	c.closeOnce.Do(func() {
 		unregisterClient(c)
-		// ルーム離脱
+		// 入力中表示を解除してからルーム離脱
+		c.hub.clearTyping(c)
 		for roomID := range c.joinedRooms {
 			c.hub.Leave(roomID, c)
 		}
+		c.hub.userStatusChanged(c.userID)
 		// チャネルクローズ & コネクションクローズ
 		close(c.send)
+		_ = c.conn.WriteControl(websocket.CloseMessage,
+			websocket.FormatCloseMessage(code, reason),
+			time.Now().Add(c.writeTimeout))
 		_ = c.conn.Close()
+		metrics.active.Add(-1)
+		metrics.closed.Add(1)
 	})
 }
 
+// 送信バッファが詰まった（受信が追いつかない）クライアントを切断
+func (c *Client) reapSlow() {
+	metrics.reapedSlow.Add(1)
+	c.CloseWith(websocket.CloseTryAgainLater, "send buffer full")
+}
+
 func (c *Client) joinRoom(roomID string) {
 	if _, exists := c.joinedRooms[roomID]; exists {
 		return
@@ -94,10 +167,40 @@ func (c *Client) sendJSON(v any) error {
 	if err != nil {
 		return err
 	}
+	c.sendRaw(b)
+	return nil
+}
+
+func (c *Client) sendRaw(b []byte) {
 	select {
 	case c.send <- b:
 	default:
-		go c.Close()
+		go c.reapSlow()
 	}
-	return nil
+}
+
+// v2 クライアントにのみ requestId 付きの ack を返す
+func (c *Client) replyAck(base Envelope) {
+	if c.protocol < ProtocolV2 || base.RequestID == "" {
+		return
+	}
+	_ = c.sendJSON(AckEvent{Type: "ack", RequestID: base.RequestID, Event: base.Type})
+}
+
+// v2 クライアントにエラーイベントを返す（v1 は従来どおりログのみ）
+func (c *Client) replyError(base Envelope, err error) {
+	if c.protocol < ProtocolV2 {
+		return
+	}
+	var protoErr *Error
+	if !errors.As(err, &protoErr) {
+		protoErr = newError(errcode.Internal, "internal error")
+	}
+	_ = c.sendJSON(ErrorEvent{
+		Type:      "error",
+		RequestID: base.RequestID,
+		Event:     base.Type,
+		Code:      protoErr.Code,
+		Message:   protoErr.Message,
+	})
 }
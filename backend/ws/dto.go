@@ -8,6 +8,7 @@ import (
 
 // BuildMessageDTO converts a DB message model into a WebSocket DTO.
 func BuildMessageDTO(roomID string, message model.Message) MessageDTO {
+	message = message.WithAccessURL()
 	var attachment *string
 	if message.AttachmentURL != nil {
 		copy := *message.AttachmentURL
@@ -30,13 +31,24 @@ func BuildMessageDTO(roomID string, message model.Message) MessageDTO {
 		RoomID:        roomID,
 		SenderID:      message.SenderID,
 		ReceiverID:    message.ReceiverID,
+		ClientMsgID:   message.ClientMsgID,
 		Content:       message.Content,
 		MessageType:   message.MessageType,
 		AttachmentURL: attachment,
 		AttachmentObj: attachmentObj,
-		IsDeleted:     message.IsDeleted,
-		IsRead:        message.IsRead,
-		CreatedAt:     message.CreatedAt.Format(time.RFC3339),
-		EditedAt:      editedAt,
+
+		AttachmentWidth:      message.AttachmentWidth,
+		AttachmentHeight:     message.AttachmentHeight,
+		AttachmentBlurhash:   message.AttachmentBlurhash,
+		AttachmentThumbURL:   message.AttachmentThumbURL,
+		AttachmentMediumURL:  message.AttachmentMediumURL,
+		AttachmentName:       message.AttachmentName,
+		AttachmentSize:       message.AttachmentSize,
+		AttachmentDurationMs: message.AttachmentDurationMs,
+		IsDeleted:            message.IsDeleted,
+		IsRead:               message.IsRead,
+		Seq:                  message.Seq,
+		CreatedAt:            message.CreatedAt.Format(time.RFC3339),
+		EditedAt:             editedAt,
 	}
 }
@@ -0,0 +1,13 @@
+package ws
+
+// Error ... ハンドラーが返すプロトコルエラー（Code は REST と共通の errcode の値）
+type Error struct {
+	Code    string
+	Message string
+}
+
+func (e *Error) Error() string { return e.Code + ": " + e.Message }
+
+func newError(code, message string) *Error {
+	return &Error{Code: code, Message: message}
+}
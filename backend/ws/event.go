@@ -2,11 +2,13 @@ package ws
 
 import (
 	"encoding/json"
+	"errors"
 	"log"
 	"strings"
 	"time"
 
 	"chillow/db"
+	"chillow/errcode"
 	"chillow/model"
 	"chillow/storage"
 
@@ -15,63 +17,107 @@ import (
 
 const maxWSMessageLength = 2000
 
+const (
+	// 通番の採番を試す回数と間隔（2 回目以降は回数に比例して待つ）
+	seqMaxAttempts = 3
+	seqRetryDelay  = 50 * time.Millisecond
+)
+
 func Dispatch(c *Client, msg []byte) {
-	var base struct {
-		Type string `json:"type"`
-	}
+	var base Envelope
 	if err := json.Unmarshal(msg, &base); err != nil {
 		log.Println("❌ invalid WS message:", err)
+		c.replyError(base, newError(errcode.InvalidPayload, "malformed json"))
 		return
 	}
 
+	var err error
 	switch base.Type {
 	case "join":
-		handleJoin(c, msg)
+		err = handleJoin(c, msg)
+	case "resume":
+		err = handleResume(c, msg)
 	case "message:send":
-		handleMessageSend(c, msg)
+		// message:send は成否とも message:ack で応答する
+		handleMessageSend(c, base, msg)
+		return
 	case "message:edit":
-		handleMessageEdit(c, msg)
+		err = handleMessageEdit(c, msg)
 	case "message:delete":
-		handleMessageDelete(c, msg)
+		err = handleMessageDelete(c, msg)
 	case "typing:start":
-		handleTyping(c, msg, "typing:start")
+		err = handleTyping(c, msg, true)
 	case "typing:stop":
-		handleTyping(c, msg, "typing:stop")
+		err = handleTyping(c, msg, false)
+	case "presence:set":
+		err = handlePresenceSet(c, msg)
 	case "ping":
 		c.sendJSON(map[string]string{"type": "pong"})
+		return
 	default:
 		log.Println("⚠️ unknown event:", base.Type)
+		err = newError(errcode.UnknownEvent, "unknown event type")
 	}
-}
 
-func handleJoin(c *Client, msg []byte) {
-	var e struct {
-		Type   string `json:"type"`
-		RoomID string `json:"roomId"`
+	if err != nil {
+		c.replyError(base, err)
+		return
 	}
+	c.replyAck(base)
+}
+
+func handleJoin(c *Client, msg []byte) error {
+	var e JoinEvent
 	if err := json.Unmarshal(msg, &e); err != nil {
-		return
+		return newError(errcode.InvalidPayload, "invalid join payload")
 	}
 	if !isUserInRoom(e.RoomID, c.userID) {
 		log.Printf("⚠️ unauthorized room access. user=%d room=%s", c.userID, e.RoomID)
-		return
+		return newError(errcode.UnauthorizedRoom, "not a member of this room")
 	}
-	if _, ok := ensureRoomAccess(c, e.RoomID); !ok {
-		return
+	if _, err := ensureRoomAccess(c, e.RoomID); err != nil {
+		return err
 	}
 	c.joinRoom(e.RoomID)
 	log.Printf("👥 user %d joined %s", c.userID, e.RoomID)
+	return nil
 }
 
-func handleMessageSend(c *Client, raw []byte) {
+func handlePresenceSet(c *Client, msg []byte) error {
+	var e PresenceSetEvent
+	if err := json.Unmarshal(msg, &e); err != nil {
+		return newError(errcode.InvalidPayload, "invalid presence payload")
+	}
+	switch e.Status {
+	case StatusOnline:
+		c.away.Store(false)
+	case StatusAway:
+		c.away.Store(true)
+	default:
+		return newError(errcode.InvalidPayload, "status must be online or away")
+	}
+	c.hub.userStatusChanged(c.userID)
+	return nil
+}
+
+func handleMessageSend(c *Client, base Envelope, raw []byte) {
 	var e SendMessageEvent
 	if err := json.Unmarshal(raw, &e); err != nil {
 		log.Println("❌ invalid message payload:", err)
+		ackSendFailure(c, base, e, errcode.InvalidPayload)
 		return
 	}
 
+	clientMsgID := strings.TrimSpace(e.ClientMsgID)
+	if len(clientMsgID) > model.MaxClientMsgIDLength {
+		ackSendFailure(c, base, e, errcode.InvalidClientMsgID)
+		return
+	}
+	e.ClientMsgID = clientMsgID
+
 	if !isUserInRoom(e.RoomID, c.userID) {
 		log.Printf("⚠️ user %d is not part of room %s", c.userID, e.RoomID)
+		ackSendFailure(c, base, e, errcode.UnauthorizedRoom)
 		return
 	}
 
@@ -83,119 +129,207 @@ func handleMessageSend(c *Client, raw []byte) {
 	content := strings.TrimSpace(e.Content)
 	if len([]rune(content)) > maxWSMessageLength {
 		log.Println("⚠️ message too long")
+		ackSendFailure(c, base, e, errcode.ContentTooLong)
 		return
 	}
 	switch messageType {
 	case "text":
 		if content == "" {
 			log.Println("⚠️ text content is empty")
+			ackSendFailure(c, base, e, errcode.ContentRequired)
 			return
 		}
 	case "sticker":
 		if content == "" {
 			log.Println("⚠️ sticker payload missing")
+			ackSendFailure(c, base, e, errcode.ContentRequired)
 			return
 		}
-	case "image":
-		if e.AttachmentURL == nil || strings.TrimSpace(*e.AttachmentURL) == "" {
-			log.Println("⚠️ image attachment missing")
+	case "image", "file", "audio":
+		if e.AttachmentID == nil || *e.AttachmentID == 0 {
+			log.Println("⚠️ attachment missing for", messageType)
+			ackSendFailure(c, base, e, errcode.AttachmentRequired)
 			return
 		}
 	default:
 		log.Println("⚠️ unsupported message type", messageType)
+		ackSendFailure(c, base, e, errcode.UnsupportedType)
 		return
 	}
 
-	var attachment *string
-	if e.AttachmentURL != nil {
-		trimmed := strings.TrimSpace(*e.AttachmentURL)
-		if trimmed != "" {
-			attachment = &trimmed
-		}
-	}
-	var attachmentObj *string
-	if e.AttachmentObj != nil {
-		trimmed := strings.TrimSpace(*e.AttachmentObj)
-		if trimmed != "" {
-			attachmentObj = &trimmed
-		}
-	}
-	if messageType == "image" && attachment == nil {
-		log.Println("⚠️ missing attachment for image")
+	receiverID, accessErr := ensureRoomAccess(c, e.RoomID)
+	if accessErr != nil {
+		ackSendFailure(c, base, e, accessErr.Code)
 		return
 	}
 
-	if !isUserInRoom(e.RoomID, c.userID) {
-		log.Printf("⚠️ user %d is not part of room %s", c.userID, e.RoomID)
+	// 再送（同じ clientMsgId）は新規作成せず元のメッセージを返す（送信済みの添付は使用済みなので、添付の確認より先に）
+	if existing, err := model.FindMessageByClientID(c.userID, e.ClientMsgID); err != nil {
+		log.Printf("❌ failed to look up client message id: %v", err)
+		ackSendFailure(c, base, e, errcode.Internal)
+		return
+	} else if existing != nil {
+		ackSendDuplicate(c, base, e, *existing, receiverID)
 		return
 	}
 
-	receiverID, ok := ensureRoomAccess(c, e.RoomID)
-	if !ok {
+	// 制裁（mute）中は送信できない（mute 前に届いた分の再送は上で返す）
+	if mute, err := model.ActiveMute(c.userID, time.Now()); err != nil || mute != nil {
+		code := errcode.AccountMuted
+		if err != nil {
+			log.Printf("❌ failed to check mute of user %d: %v", c.userID, err)
+			code = errcode.Internal
+		}
+		ackSendFailure(c, base, e, code)
 		return
 	}
 
+	// 添付はクライアントの指定した URL ではなく、本人がアップロードした記録から解決する（種類も一致が必要）
+	var att *model.Attachment
+	if e.AttachmentID != nil && *e.AttachmentID != 0 {
+		found, err := model.FindUsableAttachment(c.userID, *e.AttachmentID, messageType)
+		if err != nil {
+			log.Printf("⚠️ attachment %d rejected for user %d: %v", *e.AttachmentID, c.userID, err)
+			code := errcode.Internal
+			if errors.Is(err, model.ErrAttachmentUnavailable) {
+				code = errcode.AttachmentUnusable
+			}
+			ackSendFailure(c, base, e, code)
+			return
+		}
+		att = found
+	}
+
 	now := time.Now()
+	expiresAt, err := model.MessageExpiry(c.userID, receiverID, now)
+	if err != nil {
+		log.Printf("❌ failed to load conversation setting: %v", err)
+		ackSendFailure(c, base, e, errcode.Internal)
+		return
+	}
 	message := model.Message{
-		SenderID:      c.userID,
-		ReceiverID:    receiverID,
-		Content:       content,
-		MessageType:   messageType,
-		AttachmentURL: attachment,
-		AttachmentObj: attachmentObj,
-		CreatedAt:     now,
-		UpdatedAt:     now,
+		SenderID:    c.userID,
+		ReceiverID:  receiverID,
+		Content:     content,
+		MessageType: messageType,
+		ExpiresAt:   expiresAt,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+	if e.ClientMsgID != "" {
+		message.ClientMsgID = &e.ClientMsgID
 	}
 
-	if err := db.DB.Create(&message).Error; err != nil {
+	if att != nil {
+		message.SetAttachment(att, storage.Default().PublicURL(att.ObjectKey))
+	}
+
+	if err := model.CreateMessageWithAttachment(&message, att); err != nil {
+		// 同時再送でユニーク制約に当たった場合は先に保存された方を返す
+		if existing, lookupErr := model.FindMessageByClientID(c.userID, e.ClientMsgID); lookupErr == nil && existing != nil {
+			ackSendDuplicate(c, base, e, *existing, receiverID)
+			return
+		}
+		if errors.Is(err, model.ErrAttachmentUnavailable) {
+			ackSendFailure(c, base, e, errcode.AttachmentUnusable)
+			return
+		}
 		log.Printf("❌ failed to persist message: %v", err)
+		ackSendFailure(c, base, e, errcode.Internal)
 		return
 	}
 	createReadReceipt(c.userID, message.ID)
-	broadcastMessage(c.hub, e.RoomID, "message:new", message)
+
+	dto := BuildMessageDTO(e.RoomID, message)
+	_ = c.sendJSON(MessageAckEvent{
+		Type:        "message:ack",
+		RequestID:   base.RequestID,
+		RoomID:      e.RoomID,
+		ClientMsgID: e.ClientMsgID,
+		MessageID:   message.ID,
+		Message:     &dto,
+	})
+	BroadcastMessage(c.hub, "message:new", message)
+}
+
+func ackSendFailure(c *Client, base Envelope, e SendMessageEvent, code string) {
+	_ = c.sendJSON(MessageAckEvent{
+		Type:        "message:ack",
+		RequestID:   base.RequestID,
+		RoomID:      e.RoomID,
+		ClientMsgID: e.ClientMsgID,
+		Error:       code,
+	})
 }
 
-func handleMessageEdit(c *Client, raw []byte) {
+// ackSendDuplicate は再送に元のメッセージを返す（別の宛先で使われた client_msg_id は REST と同じく拒否）
+func ackSendDuplicate(c *Client, base Envelope, e SendMessageEvent, message model.Message, receiverID uint) {
+	if message.ReceiverID != receiverID {
+		ackSendFailure(c, base, e, errcode.ClientMsgIDInUse)
+		return
+	}
+	dto := BuildMessageDTO(BuildRoomID(message.SenderID, message.ReceiverID), message)
+	_ = c.sendJSON(MessageAckEvent{
+		Type:        "message:ack",
+		RequestID:   base.RequestID,
+		RoomID:      e.RoomID,
+		ClientMsgID: e.ClientMsgID,
+		MessageID:   message.ID,
+		Duplicate:   true,
+		Message:     &dto,
+	})
+}
+
+func handleMessageEdit(c *Client, raw []byte) error {
 	var e EditMessageEvent
 	if err := json.Unmarshal(raw, &e); err != nil {
 		log.Println("❌ invalid edit payload:", err)
-		return
+		return newError(errcode.InvalidPayload, "invalid edit payload")
 	}
 
 	if !isUserInRoom(e.RoomID, c.userID) {
 		log.Printf("⚠️ user %d cannot edit in room %s", c.userID, e.RoomID)
-		return
+		return newError(errcode.UnauthorizedRoom, "not a member of this room")
 	}
-	if _, ok := ensureRoomAccess(c, e.RoomID); !ok {
-		return
+	if _, err := ensureRoomAccess(c, e.RoomID); err != nil {
+		return err
 	}
 
 	var message model.Message
 	if err := db.DB.First(&message, e.MessageID).Error; err != nil {
 		log.Printf("⚠️ message %d not found: %v", e.MessageID, err)
-		return
+		return newError(errcode.NotFound, "message not found")
 	}
 
 	if BuildRoomID(message.SenderID, message.ReceiverID) != e.RoomID {
 		log.Printf("⚠️ message %d not in room %s", message.ID, e.RoomID)
-		return
+		return newError(errcode.NotFound, "message not found")
 	}
-	if message.SenderID != c.userID {
+	if message.SenderID != c.userID || message.MessageType == "system" {
 		log.Printf("⚠️ user %d cannot edit message %d", c.userID, message.ID)
-		return
+		return newError(errcode.Forbidden, "not allowed")
 	}
 	if message.IsDeleted {
-		return
+		return newError(errcode.MessageDeleted, "cannot edit deleted message")
+	}
+	// 制裁（mute）中は編集でも新しい内容を送れない
+	mute, err := model.ActiveMute(c.userID, time.Now())
+	if err != nil {
+		log.Printf("❌ failed to check mute of user %d: %v", c.userID, err)
+		return newError(errcode.Internal, "failed to update message")
+	}
+	if mute != nil {
+		return newError(errcode.AccountMuted, "account is muted")
 	}
 
 	content := strings.TrimSpace(e.Content)
 	if message.MessageType != "image" && content == "" {
 		log.Println("⚠️ empty edit content")
-		return
+		return newError(errcode.ContentRequired, "content is required")
 	}
 	if message.MessageType != "image" && len([]rune(content)) > maxWSMessageLength {
 		log.Println("⚠️ edit content too long")
-		return
+		return newError(errcode.ContentTooLong, "content is too long")
 	}
 
 	message.Content = content
@@ -204,40 +338,41 @@ func handleMessageEdit(c *Client, raw []byte) {
 	message.UpdatedAt = now
 	if err := db.DB.Save(&message).Error; err != nil {
 		log.Printf("⚠️ failed to update message: %v", err)
-		return
+		return newError(errcode.Internal, "failed to update message")
 	}
 
-	broadcastMessage(c.hub, e.RoomID, "message:updated", message)
+	BroadcastMessage(c.hub, "message:updated", message)
+	return nil
 }
 
-func handleMessageDelete(c *Client, raw []byte) {
+func handleMessageDelete(c *Client, raw []byte) error {
 	var e DeleteMessageEvent
 	if err := json.Unmarshal(raw, &e); err != nil {
 		log.Println("❌ invalid delete payload:", err)
-		return
+		return newError(errcode.InvalidPayload, "invalid delete payload")
 	}
 
 	if !isUserInRoom(e.RoomID, c.userID) {
-		return
+		return newError(errcode.UnauthorizedRoom, "not a member of this room")
 	}
-	if _, ok := ensureRoomAccess(c, e.RoomID); !ok {
-		return
+	if _, err := ensureRoomAccess(c, e.RoomID); err != nil {
+		return err
 	}
 
 	var message model.Message
 	if err := db.DB.First(&message, e.MessageID).Error; err != nil {
 		log.Printf("⚠️ message %d not found: %v", e.MessageID, err)
-		return
+		return newError(errcode.NotFound, "message not found")
 	}
 
 	if BuildRoomID(message.SenderID, message.ReceiverID) != e.RoomID {
-		return
+		return newError(errcode.NotFound, "message not found")
 	}
-	if message.SenderID != c.userID {
-		return
+	if message.SenderID != c.userID || message.MessageType == "system" {
+		return newError(errcode.Forbidden, "not allowed")
 	}
 	if message.IsDeleted {
-		return
+		return nil
 	}
 
 	now := time.Now()
@@ -246,65 +381,152 @@ func handleMessageDelete(c *Client, raw []byte) {
 	message.UpdatedAt = now
 	message.Content = ""
 	if message.AttachmentObj != nil {
-		_ = storage.Default().Delete(*message.AttachmentObj)
+		_ = model.ReleaseMessageAttachment(message.ID, *message.AttachmentObj)
 	}
-	message.AttachmentURL = nil
-	message.AttachmentObj = nil
+	message.ClearAttachment()
 	if err := db.DB.Save(&message).Error; err != nil {
 		log.Printf("⚠️ failed to delete message: %v", err)
-		return
+		return newError(errcode.Internal, "failed to delete message")
 	}
 
-	broadcastMessage(c.hub, e.RoomID, "message:deleted", message)
+	BroadcastMessage(c.hub, "message:deleted", message)
+	return nil
 }
 
-func handleTyping(c *Client, raw []byte, eventType string) {
+// 入力状態は Hub が保持し、期限切れ・切断・送信時に typing:stop を出す
+func handleTyping(c *Client, raw []byte, start bool) error {
 	var e TypingEvent
 	if err := json.Unmarshal(raw, &e); err != nil {
-		return
+		return newError(errcode.InvalidPayload, "invalid typing payload")
 	}
 	if !isUserInRoom(e.RoomID, c.userID) {
+		return newError(errcode.UnauthorizedRoom, "not a member of this room")
+	}
+	if _, err := ensureRoomAccess(c, e.RoomID); err != nil {
+		return err
+	}
+
+	if start {
+		c.hub.StartTyping(e.RoomID, c)
+	} else {
+		c.hub.StopTyping(e.RoomID, c.userID)
+	}
+	return nil
+}
+
+// BroadcastMessage はルーム通番を採番して message:* イベントを配信する
+func BroadcastMessage(h *Hub, eventType string, message model.Message) {
+	BroadcastMessages(h, eventType, []model.Message{message})
+}
+
+// BroadcastMessages は同じルームの複数のメッセージに 1 つの通番を採番して配信する（まとめて既読など）
+func BroadcastMessages(h *Hub, eventType string, messages []model.Message) {
+	if len(messages) == 0 {
 		return
 	}
-	if _, ok := ensureRoomAccess(c, e.RoomID); !ok {
+	roomID := BuildRoomID(messages[0].SenderID, messages[0].ReceiverID)
+	ids := make([]uint, len(messages))
+	for i, message := range messages {
+		ids[i] = message.ID
+	}
+	var seq uint64
+	err := retrySeq(func() error {
+		var err error
+		seq, err = model.StampRoomSeq(roomID, ids)
+		return err
+	})
+	if err != nil {
+		// 通番のないイベントは resume で再送できず、取りこぼしにも気づけないので配信せずに再同期させる
+		log.Printf("❌ failed to stamp room seq, requiring resync of %s: %v", roomID, err)
+		broadcastResync(h, roomID)
 		return
 	}
 
-	payload := TypingBroadcast{Type: eventType, RoomID: e.RoomID, UserID: c.userID}
-	data, err := json.Marshal(payload)
+	for _, message := range messages {
+		message.Seq = seq
+		// 送信したら入力中表示は消す
+		if eventType == "message:new" && message.MessageType != "system" {
+			h.StopTyping(roomID, message.SenderID)
+		}
+
+		dto := BuildMessageDTO(roomID, message)
+		event := MessageEvent{Type: eventType, RoomID: roomID, Seq: message.Seq, Message: dto}
+		data, err := json.Marshal(event)
+		if err != nil {
+			log.Printf("⚠️ failed to marshal %s: %v", eventType, err)
+			continue
+		}
+		h.Broadcast(roomID, data)
+	}
+}
+
+// BroadcastMessagesExpired は物理削除したメッセージを通知する。
+// DB から再送できないため、この通番より前からの resume は再同期扱いにする。
+func BroadcastMessagesExpired(h *Hub, roomID string, messageIDs []uint) {
+	var seq uint64
+	err := retrySeq(func() error {
+		var err error
+		if seq == 0 {
+			if seq, err = model.NextRoomSeq(roomID); err != nil {
+				return err
+			}
+		}
+		return model.RaiseRoomFloor(roomID, seq)
+	})
 	if err != nil {
+		log.Printf("❌ failed to raise room floor, requiring resync of %s: %v", roomID, err)
+		broadcastResync(h, roomID)
 		return
 	}
-	c.hub.Broadcast(e.RoomID, data)
-}
 
-func broadcastMessage(h *Hub, roomID, eventType string, message model.Message) {
-	dto := BuildMessageDTO(roomID, message)
-	event := MessageEvent{Type: eventType, RoomID: roomID, Message: dto}
+	event := MessagesExpiredEvent{Type: "message:expired", RoomID: roomID, Seq: seq, MessageIDs: messageIDs}
 	data, err := json.Marshal(event)
 	if err != nil {
-		log.Printf("⚠️ failed to marshal %s: %v", eventType, err)
+		log.Printf("⚠️ failed to marshal expire event: %v", err)
+		return
+	}
+	h.Broadcast(roomID, data)
+}
+
+// retrySeq は通番の採番を一時的な DB エラーに備えて数回試す
+func retrySeq(fn func() error) error {
+	var err error
+	for attempt := 0; attempt < seqMaxAttempts; attempt++ {
+		if attempt > 0 {
+			time.Sleep(seqRetryDelay * time.Duration(attempt))
+		}
+		if err = fn(); err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+// broadcastResync は採番できなかったイベントの代わりに、ルームの全員へ履歴の取り直しを求める
+func broadcastResync(h *Hub, roomID string) {
+	data, err := json.Marshal(ResumeResultEvent{Type: "resync:required", RoomID: roomID})
+	if err != nil {
 		return
 	}
 	h.Broadcast(roomID, data)
 }
 
-func ensureRoomAccess(c *Client, roomID string) (uint, bool) {
+func ensureRoomAccess(c *Client, roomID string) (uint, *Error) {
 	peerID, err := counterpartyFromRoom(roomID, c.userID)
 	if err != nil {
 		log.Printf("⚠️ failed to parse room %s: %v", roomID, err)
-		return 0, false
+		return 0, newError(errcode.UnauthorizedRoom, "invalid room")
 	}
 	ok, err := model.AreFriends(c.userID, peerID)
 	if err != nil {
 		log.Printf("⚠️ friendship check failed: %v", err)
-		return peerID, false
+		return peerID, newError(errcode.Internal, "friendship check failed")
 	}
 	if !ok {
 		notifyRoomRevoked(c, roomID)
-		return peerID, false
+		return peerID, newError(errcode.RoomRevoked, "room is no longer available")
 	}
-	return peerID, true
+	return peerID, nil
 }
 
 func notifyRoomRevoked(c *Client, roomID string) {
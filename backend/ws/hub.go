@@ -1,14 +1,51 @@
 package ws
 
-import "encoding/json"
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"chillow/broker"
+)
+
+const (
+	// 他ノードへ在室状況のスナップショットを送る間隔と、途絶したノードを忘れるまでの時間
+	presenceSyncInterval = 30 * time.Second
+	presenceNodeTTL      = 3 * presenceSyncInterval
+)
 
 type Hub struct {
 	rooms map[string]*Room
 
+	// 他ノードの在室状況（ノードID → ルームID → ユーザーID）
+	remote map[string]*nodePresence
+
 	// ルーム操作用のチャネル（Runでselect）
 	join      chan joinReq
 	leave     chan leaveReq
 	broadcast chan broadcastReq
+	presence  chan presenceMessage
+	status    chan uint // 自ノードで接続状態が変わったユーザー
+	hidden    chan uint // 在室の公開設定が変わったユーザー
+
+	typing     map[typingKey]*typingState
+	typingReqs chan typingReq
+
+	statuses *statusTable
+	notices  chan statusNotice
+
+	broker  broker.Broker
+	publish chan outboundMessage
+
+	draining atomic.Bool
+	stop     chan struct{} // Run ループを止める（受け取った時点で処理中の要求は済んでいる）
+	quit     chan struct{}
+	drainCtx context.Context // quit の後に残った通知を送り切る期限（Shutdown が設定する）
+	loops    sync.WaitGroup  // publishLoop / noticeLoop
 }
 
 type joinReq struct {
@@ -24,27 +61,111 @@ type broadcastReq struct {
 	bytes  []byte
 }
 
+type nodePresence struct {
+	rooms  map[string][]uint
+	seenAt time.Time
+}
+
+// ノード間で流すメッセージ
+type roomEnvelope struct {
+	RoomID string          `json:"roomId"`
+	Data   json.RawMessage `json:"data"`
+}
+
+type presenceMessage struct {
+	Node     string            `json:"node"`
+	RoomID   string            `json:"roomId,omitempty"`
+	Users    []uint            `json:"users,omitempty"`
+	UserID   uint              `json:"userId,omitempty"` // ユーザー単位の状態変化
+	Status   string            `json:"status,omitempty"`
+	Snapshot map[string][]uint `json:"snapshot,omitempty"` // Full のときノードの全ルーム
+	Statuses map[uint]string   `json:"statuses,omitempty"` // Full のときノードの全ユーザー状態
+	Full     bool              `json:"full,omitempty"`
+}
+
+type outboundMessage struct {
+	channel string
+	payload []byte
+}
+
 func NewHub() *Hub {
 	return &Hub{
-		rooms:     make(map[string]*Room),
-		join:      make(chan joinReq),
-		leave:     make(chan leaveReq),
-		broadcast: make(chan broadcastReq),
+		rooms:      make(map[string]*Room),
+		remote:     make(map[string]*nodePresence),
+		join:       make(chan joinReq),
+		leave:      make(chan leaveReq),
+		broadcast:  make(chan broadcastReq, 256),
+		presence:   make(chan presenceMessage, 256),
+		status:     make(chan uint),
+		hidden:     make(chan uint),
+		typing:     make(map[typingKey]*typingState),
+		typingReqs: make(chan typingReq),
+		statuses:   newStatusTable(),
+		notices:    make(chan statusNotice, 1024),
+		publish:    make(chan outboundMessage, 256),
+		stop:       make(chan struct{}),
+		quit:       make(chan struct{}),
+	}
+}
+
+// Attach はブローカー経由の配信を有効にする（Run より前に呼ぶ）
+func (h *Hub) Attach(b broker.Broker) error {
+	h.broker = b
+	cluster = b
+
+	if err := b.Subscribe(broker.ChannelRoom, func(payload []byte) {
+		var env roomEnvelope
+		if err := json.Unmarshal(payload, &env); err != nil {
+			log.Printf("⚠️ invalid room envelope: %v", err)
+			return
+		}
+		enqueue(h, h.broadcast, broadcastReq{roomID: env.RoomID, bytes: env.Data})
+	}); err != nil {
+		return err
+	}
+	if err := b.Subscribe(broker.ChannelPresence, func(payload []byte) {
+		var msg presenceMessage
+		if err := json.Unmarshal(payload, &msg); err != nil {
+			log.Printf("⚠️ invalid presence message: %v", err)
+			return
+		}
+		if msg.Node == broker.NodeID() {
+			return
+		}
+		enqueue(h, h.presence, msg)
+	}); err != nil {
+		return err
 	}
+	return b.Subscribe(broker.ChannelUser, handleUserMessage)
 }
 
 func (h *Hub) Run() {
+	if h.broker != nil {
+		h.loops.Add(1)
+		go h.publishLoop()
+	}
+	h.loops.Add(1)
+	go h.noticeLoop()
+	ticker := time.NewTicker(presenceSyncInterval)
+	defer ticker.Stop()
+	typingTicker := time.NewTicker(typingSweepInterval)
+	defer typingTicker.Stop()
+
 	for {
 		select {
+		case <-h.stop:
+			return
+		case <-h.quit:
+			return
 		case j := <-h.join:
 			r := h.getOrCreateRoom(j.roomID)
 			if r.Add(j.client) {
-				h.emitPresence(r)
+				h.roomChanged(r)
 			}
 		case l := <-h.leave:
 			if r, ok := h.rooms[l.roomID]; ok {
 				if r.Remove(l.client) {
-					h.emitPresence(r)
+					h.roomChanged(r)
 				}
 				if r.IsEmpty() {
 					delete(h.rooms, l.roomID)
@@ -54,6 +175,19 @@ func (h *Hub) Run() {
 			if r, ok := h.rooms[b.roomID]; ok {
 				r.Broadcast(b.bytes)
 			}
+		case userID := <-h.status:
+			h.refreshLocalStatus(userID)
+		case userID := <-h.hidden:
+			h.refreshRooms(userID)
+		case t := <-h.typingReqs:
+			h.handleTypingReq(t, time.Now())
+		case now := <-typingTicker.C:
+			h.expireTyping(now)
+		case p := <-h.presence:
+			h.applyRemotePresence(p)
+		case <-ticker.C:
+			h.publishSnapshot()
+			h.pruneRemote(time.Now())
 		}
 	}
 }
@@ -68,20 +202,197 @@ func (h *Hub) getOrCreateRoom(id string) *Room {
 }
 
 // 外部から呼ぶAPI
-func (h *Hub) Join(roomID string, c *Client)  { h.join <- joinReq{roomID: roomID, client: c} }
-func (h *Hub) Leave(roomID string, c *Client) { h.leave <- leaveReq{roomID: roomID, client: c} }
+func (h *Hub) Join(roomID string, c *Client)  { enqueue(h, h.join, joinReq{roomID: roomID, client: c}) }
+func (h *Hub) Leave(roomID string, c *Client) { enqueue(h, h.leave, leaveReq{roomID: roomID, client: c}) }
+
+// UserStatus は全ノードを合算したユーザーのオンライン状態を返す
+func (h *Hub) UserStatus(userID uint) string { return h.statuses.aggregate(userID) }
+
+// RefreshPresence は公開設定の変更後に、全ノードのルームの在室者と友達への状態を通知し直す
+func (h *Hub) RefreshPresence(userID uint, hidden bool) {
+	publishUserMessage(userMessage{Kind: "visibility", UserID: userID, Hidden: hidden})
+	h.queueNotice(statusNotice{userID: userID, status: h.UserStatus(userID), refresh: true})
+}
+
+func (h *Hub) userStatusChanged(userID uint) { enqueue(h, h.status, userID) }
+
+// Broadcast は全ノードの該当ルームへ配信する（ブローカー未接続ならローカルのみ）
 func (h *Hub) Broadcast(roomID string, b []byte) {
-	h.broadcast <- broadcastReq{roomID: roomID, bytes: b}
+	if h.broker != nil {
+		payload, err := json.Marshal(roomEnvelope{RoomID: roomID, Data: b})
+		if err == nil {
+			if err = h.broker.Publish(broker.ChannelRoom, payload); err == nil {
+				return
+			}
+		}
+		log.Printf("⚠️ broker publish failed, delivering locally: %v", err)
+	}
+	enqueue(h, h.broadcast, broadcastReq{roomID: roomID, bytes: b})
+}
+
+// enqueue は Run ループへ要求を渡す。停止後は破棄する（呼び出し元を永久に待たせない）
+func enqueue[T any](h *Hub, ch chan T, v T) {
+	select {
+	case ch <- v:
+	case <-h.quit:
+	}
+}
+
+func (h *Hub) roomChanged(r *Room) {
+	h.enqueuePresence(presenceMessage{Node: broker.NodeID(), RoomID: r.id, Users: r.VisibleUserIDs()})
+	h.emitPresence(r)
 }
 
+// presence:update はノードごとに自分の接続へ送る（ルーム内の全ノード合算）
 func (h *Hub) emitPresence(r *Room) {
 	if r == nil {
 		return
 	}
-	event := PresenceEvent{Type: "presence:update", RoomID: r.id, Users: r.UserIDs()}
+	event := PresenceEvent{Type: "presence:update", RoomID: r.id, Users: h.roomUsers(r)}
 	data, err := json.Marshal(event)
 	if err != nil {
 		return
 	}
 	r.Broadcast(data)
 }
+
+// refreshRooms は公開設定が変わったユーザーのいるルームの在室者を送り直す
+func (h *Hub) refreshRooms(userID uint) {
+	for _, r := range h.rooms {
+		if r.HasUser(userID) {
+			h.roomChanged(r)
+		}
+	}
+}
+
+func (h *Hub) roomUsers(r *Room) []uint {
+	seen := make(map[uint]struct{})
+	for _, id := range r.VisibleUserIDs() {
+		seen[id] = struct{}{}
+	}
+	for _, node := range h.remote {
+		for _, id := range node.rooms[r.id] {
+			seen[id] = struct{}{}
+		}
+	}
+	ids := make([]uint, 0, len(seen))
+	for id := range seen {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+func (h *Hub) refreshLocalStatus(userID uint) {
+	status := localStatus(userID)
+	before, after, changed := h.statuses.setLocal(userID, status)
+	if !changed {
+		return
+	}
+	h.enqueuePresence(presenceMessage{Node: broker.NodeID(), UserID: userID, Status: status})
+	// 友達への通知は状態が変わったノードだけが行う
+	if before != after {
+		h.queueNotice(statusNotice{userID: userID, status: after})
+	}
+}
+
+func (h *Hub) applyRemotePresence(msg presenceMessage) {
+	node, ok := h.remote[msg.Node]
+	if !ok {
+		node = &nodePresence{rooms: make(map[string][]uint)}
+		h.remote[msg.Node] = node
+	}
+	node.seenAt = time.Now()
+
+	if msg.UserID != 0 {
+		h.statuses.setRemote(msg.Node, msg.UserID, msg.Status)
+		return
+	}
+
+	changed := make(map[string]struct{})
+	if msg.Full {
+		for roomID := range node.rooms {
+			changed[roomID] = struct{}{}
+		}
+		node.rooms = make(map[string][]uint, len(msg.Snapshot))
+		for roomID, users := range msg.Snapshot {
+			node.rooms[roomID] = users
+			changed[roomID] = struct{}{}
+		}
+		statuses := msg.Statuses
+		if statuses == nil {
+			statuses = make(map[uint]string)
+		}
+		h.statuses.replaceRemote(msg.Node, statuses)
+	} else {
+		if len(msg.Users) == 0 {
+			delete(node.rooms, msg.RoomID)
+		} else {
+			node.rooms[msg.RoomID] = msg.Users
+		}
+		changed[msg.RoomID] = struct{}{}
+	}
+	for roomID := range changed {
+		h.emitPresence(h.rooms[roomID])
+	}
+}
+
+func (h *Hub) publishSnapshot() {
+	snapshot := make(map[string][]uint, len(h.rooms))
+	for id, r := range h.rooms {
+		snapshot[id] = r.VisibleUserIDs()
+	}
+	h.enqueuePresence(presenceMessage{
+		Node:     broker.NodeID(),
+		Snapshot: snapshot,
+		Statuses: h.statuses.localSnapshot(),
+		Full:     true,
+	})
+}
+
+// 応答の途絶えたノード（クラッシュ等）の在室情報を破棄
+func (h *Hub) pruneRemote(now time.Time) {
+	for nodeID, node := range h.remote {
+		if now.Sub(node.seenAt) < presenceNodeTTL {
+			continue
+		}
+		delete(h.remote, nodeID)
+		for roomID := range node.rooms {
+			h.emitPresence(h.rooms[roomID])
+		}
+		for userID, status := range h.statuses.dropNode(nodeID) {
+			h.queueNotice(statusNotice{userID: userID, status: status})
+		}
+	}
+}
+
+func (h *Hub) enqueuePresence(msg presenceMessage) {
+	if h.broker == nil {
+		return
+	}
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		return
+	}
+	select {
+	case h.publish <- outboundMessage{channel: broker.ChannelPresence, payload: payload}:
+	default:
+		// 次回のスナップショットで補正される
+		log.Println("⚠️ presence publish queue full")
+	}
+}
+
+// Run ループをネットワーク I/O で止めないよう、ノード間送信は別 goroutine で行う
+func (h *Hub) publishLoop() {
+	defer h.loops.Done()
+	for {
+		select {
+		case <-h.quit:
+			return
+		case msg := <-h.publish:
+			if err := h.broker.Publish(msg.channel, msg.payload); err != nil {
+				log.Printf("⚠️ broker publish failed: %v", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,33 @@
+package ws
+
+import "sync/atomic"
+
+// 接続数と切断理由ごとの回収数（/api/admin/metrics で参照）
+var metrics struct {
+	active         atomic.Int64
+	opened         atomic.Int64
+	closed         atomic.Int64
+	reapedIdle     atomic.Int64
+	reapedOversize atomic.Int64
+	reapedSlow     atomic.Int64
+}
+
+type MetricsSnapshot struct {
+	ActiveConnections int64 `json:"active_connections"`
+	OpenedTotal       int64 `json:"opened_total"`
+	ClosedTotal       int64 `json:"closed_total"`
+	ReapedIdle        int64 `json:"reaped_idle_total"`     // pong 応答なし（半開き接続）
+	ReapedOversize    int64 `json:"reaped_oversize_total"` // 最大メッセージサイズ超過
+	ReapedSlow        int64 `json:"reaped_slow_total"`     // 送信バッファ詰まり
+}
+
+func Metrics() MetricsSnapshot {
+	return MetricsSnapshot{
+		ActiveConnections: metrics.active.Load(),
+		OpenedTotal:       metrics.opened.Load(),
+		ClosedTotal:       metrics.closed.Load(),
+		ReapedIdle:        metrics.reapedIdle.Load(),
+		ReapedOversize:    metrics.reapedOversize.Load(),
+		ReapedSlow:        metrics.reapedSlow.Load(),
+	}
+}
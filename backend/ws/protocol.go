@@ -0,0 +1,45 @@
+package ws
+
+// WebSocket プロトコルのバージョン。接続時に Sec-WebSocket-Protocol で交渉する。
+// 詳細は docs/ws_protocol.md を参照。
+const (
+	ProtocolV1 = 1 // サブプロトコル未指定（従来動作）
+	ProtocolV2 = 2 // requestId / ack / error を含むエンベロープ
+
+	subprotocolV1 = "chillow.v1"
+	subprotocolV2 = "chillow.v2"
+)
+
+// Subprotocols は Upgrader に渡す対応サブプロトコル（優先度順）
+var Subprotocols = []string{subprotocolV2, subprotocolV1}
+
+func protocolVersion(subprotocol string) int {
+	switch subprotocol {
+	case subprotocolV2:
+		return ProtocolV2
+	default:
+		return ProtocolV1
+	}
+}
+
+// Envelope ... クライアント→サーバーの全イベント共通部分
+type Envelope struct {
+	Type      string `json:"type"`
+	RequestID string `json:"requestId,omitempty"`
+}
+
+// AckEvent ... v2 でリクエスト成功時に返す
+type AckEvent struct {
+	Type      string `json:"type"`
+	RequestID string `json:"requestId"`
+	Event     string `json:"event"`
+}
+
+// ErrorEvent ... v2 でリクエスト失敗時に返す
+type ErrorEvent struct {
+	Type      string `json:"type"`
+	RequestID string `json:"requestId,omitempty"`
+	Event     string `json:"event,omitempty"`
+	Code      string `json:"code"`
+	Message   string `json:"message,omitempty"`
+}
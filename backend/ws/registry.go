@@ -1,7 +1,13 @@
 package ws
 
 import (
+	"encoding/json"
+	"log"
 	"sync"
+
+	"chillow/broker"
+
+	"github.com/gorilla/websocket"
 )
 
 type clientRegistry struct {
@@ -11,6 +17,18 @@ type clientRegistry struct {
 
 var registry = &clientRegistry{clients: make(map[uint]map[*Client]struct{})}
 
+// Hub.Attach で設定される。nil の間はこのノードの接続にのみ届く
+var cluster broker.Broker
+
+// ユーザー宛てにノード間で流すメッセージ
+type userMessage struct {
+	Kind   string          `json:"kind"` // "disconnect" | "event" | "visibility"
+	UserID uint            `json:"userId"`
+	Reason string          `json:"reason,omitempty"`
+	Data   json.RawMessage `json:"data,omitempty"`
+	Hidden bool            `json:"hidden,omitempty"` // visibility のとき hide_presence
+}
+
 func registerClient(c *Client) {
 	registry.mu.Lock()
 	defer registry.mu.Unlock()
@@ -33,25 +51,106 @@ func unregisterClient(c *Client) {
 	}
 }
 
-func DisconnectUser(userID uint, reason string) {
+func localClients(userID uint) []*Client {
 	registry.mu.RLock()
+	defer registry.mu.RUnlock()
 	set := registry.clients[userID]
-	if len(set) == 0 {
-		registry.mu.RUnlock()
-		return
-	}
 	targets := make([]*Client, 0, len(set))
 	for c := range set {
 		targets = append(targets, c)
 	}
-	registry.mu.RUnlock()
+	return targets
+}
+
+func allLocalClients() []*Client {
+	registry.mu.RLock()
+	defer registry.mu.RUnlock()
+	var targets []*Client
+	for _, set := range registry.clients {
+		for c := range set {
+			targets = append(targets, c)
+		}
+	}
+	return targets
+}
+
+// 自ノードの接続から求めたユーザーの状態（1つでもアクティブな接続があれば online）
+func localStatus(userID uint) string {
+	registry.mu.RLock()
+	defer registry.mu.RUnlock()
+	set := registry.clients[userID]
+	if len(set) == 0 {
+		return StatusOffline
+	}
+	for c := range set {
+		if !c.away.Load() {
+			return StatusOnline
+		}
+	}
+	return StatusAway
+}
+
+// DisconnectUser は全ノードにある対象ユーザーの接続を切断する
+func DisconnectUser(userID uint, reason string) {
+	publishUserMessage(userMessage{Kind: "disconnect", UserID: userID, Reason: reason})
+}
+
+// SendToUser は全ノードにある対象ユーザーの接続へイベントを送る
+func SendToUser(userID uint, v any) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		log.Printf("⚠️ failed to marshal user event: %v", err)
+		return
+	}
+	publishUserMessage(userMessage{Kind: "event", UserID: userID, Data: data})
+}
+
+func publishUserMessage(msg userMessage) {
+	if cluster != nil {
+		payload, err := json.Marshal(msg)
+		if err == nil {
+			if err = cluster.Publish(broker.ChannelUser, payload); err == nil {
+				return
+			}
+		}
+		log.Printf("⚠️ broker publish failed, delivering locally: %v", err)
+	}
+	deliverUserMessage(msg)
+}
 
-	payload := map[string]string{"type": "account:suspended"}
-	if reason != "" {
-		payload["reason"] = reason
+func handleUserMessage(payload []byte) {
+	var msg userMessage
+	if err := json.Unmarshal(payload, &msg); err != nil {
+		log.Printf("⚠️ invalid user message: %v", err)
+		return
 	}
-	for _, client := range targets {
-		_ = client.sendJSON(payload)
-		go client.Close()
+	deliverUserMessage(msg)
+}
+
+func deliverUserMessage(msg userMessage) {
+	targets := localClients(msg.UserID)
+	if len(targets) == 0 {
+		return
+	}
+	switch msg.Kind {
+	case "disconnect":
+		payload := map[string]string{"type": "account:suspended"}
+		if msg.Reason != "" {
+			payload["reason"] = msg.Reason
+		}
+		for _, client := range targets {
+			_ = client.sendJSON(payload)
+			go client.CloseWith(websocket.ClosePolicyViolation, "account suspended")
+		}
+	case "event":
+		for _, client := range targets {
+			client.sendRaw(msg.Data)
+		}
+	case "visibility":
+		for _, client := range targets {
+			client.hidden.Store(msg.Hidden)
+		}
+		hub := targets[0].hub
+		enqueue(hub, hub.hidden, msg.UserID)
 	}
 }
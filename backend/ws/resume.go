@@ -0,0 +1,98 @@
+package ws
+
+import (
+	"encoding/json"
+	"log"
+
+	"chillow/config"
+	"chillow/db"
+	"chillow/errcode"
+	"chillow/model"
+)
+
+// ResumeEvent ... 再接続時にルームごとの最後に受信した seq を送る
+type ResumeEvent struct {
+	Type  string            `json:"type"`
+	Rooms map[string]uint64 `json:"rooms"`
+}
+
+type ResumeResultEvent struct {
+	Type   string `json:"type"`
+	RoomID string `json:"roomId"`
+	Seq    uint64 `json:"seq"`
+}
+
+// 再送は message:* のみ。typing / presence は現在の状態を表すため通番を付けず再送もしない（join 時の presence:update と typing の再送・期限切れで最新化する）
+func handleResume(c *Client, raw []byte) error {
+	var e ResumeEvent
+	if err := json.Unmarshal(raw, &e); err != nil {
+		return newError(errcode.InvalidPayload, "invalid resume payload")
+	}
+
+	for roomID, lastSeq := range e.Rooms {
+		if !isUserInRoom(roomID, c.userID) {
+			log.Printf("⚠️ unauthorized resume. user=%d room=%s", c.userID, roomID)
+			continue
+		}
+		if _, err := ensureRoomAccess(c, roomID); err != nil {
+			continue
+		}
+		c.joinRoom(roomID)
+		resumeRoom(c, roomID, lastSeq)
+	}
+	return nil
+}
+
+func resumeRoom(c *Client, roomID string, lastSeq uint64) {
+	rs, err := model.FindRoomSequence(roomID)
+	if err != nil {
+		log.Printf("⚠️ failed to load room seq: %v", err)
+		requireResync(c, roomID, 0)
+		return
+	}
+	if lastSeq == rs.Seq {
+		_ = c.sendJSON(ResumeResultEvent{Type: "resume:ok", RoomID: roomID, Seq: rs.Seq})
+		return
+	}
+	// サーバー側より新しい seq や、物理削除を挟んだ古い seq からは再送できない
+	if lastSeq > rs.Seq || lastSeq < rs.FloorSeq {
+		requireResync(c, roomID, rs.Seq)
+		return
+	}
+
+	low, high, err := parseRoomID(roomID)
+	if err != nil {
+		requireResync(c, roomID, rs.Seq)
+		return
+	}
+	limit := config.Cfg.WSReplayLimit
+	var missed []model.Message
+	if err := db.DB.
+		Where("((sender_id = ? AND receiver_id = ?) OR (sender_id = ? AND receiver_id = ?)) AND seq > ?",
+			low, high, high, low, lastSeq).
+		Order("seq ASC").
+		Limit(limit + 1).
+		Find(&missed).Error; err != nil {
+		log.Printf("⚠️ failed to load missed messages: %v", err)
+		requireResync(c, roomID, rs.Seq)
+		return
+	}
+	if len(missed) > limit {
+		requireResync(c, roomID, rs.Seq)
+		return
+	}
+
+	for _, msg := range missed {
+		_ = c.sendJSON(MessageEvent{
+			Type:    "message:replay",
+			RoomID:  roomID,
+			Seq:     msg.Seq,
+			Message: BuildMessageDTO(roomID, msg),
+		})
+	}
+	_ = c.sendJSON(ResumeResultEvent{Type: "resume:ok", RoomID: roomID, Seq: rs.Seq})
+}
+
+func requireResync(c *Client, roomID string, seq uint64) {
+	_ = c.sendJSON(ResumeResultEvent{Type: "resync:required", RoomID: roomID, Seq: seq})
+}
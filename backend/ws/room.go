@@ -33,14 +33,18 @@ func (r *Room) Broadcast(b []byte) {
 		case c.send <- b:
 		default:
 			// バックプレッシャー：詰まってたら切断
-			go c.Close()
+			go c.reapSlow()
 		}
 	}
 }
 
-func (r *Room) UserIDs() []uint {
+// VisibleUserIDs は在室を公開しているユーザー（hide_presence のユーザーは含めない）
+func (r *Room) VisibleUserIDs() []uint {
 	seen := make(map[uint]struct{})
 	for client := range r.clients {
+		if client.hidden.Load() {
+			continue
+		}
 		seen[client.userID] = struct{}{}
 	}
 	ids := make([]uint, 0, len(seen))
@@ -51,6 +55,15 @@ func (r *Room) UserIDs() []uint {
 	return ids
 }
 
+func (r *Room) HasUser(userID uint) bool {
+	for client := range r.clients {
+		if client.userID == userID {
+			return true
+		}
+	}
+	return false
+}
+
 func (r *Room) IsEmpty() bool {
 	return len(r.clients) == 0
 }
@@ -0,0 +1,106 @@
+package ws
+
+import (
+	"context"
+	"log"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// 再接続が一斉に集中しないよう、待ち時間をばらつかせる
+	reconnectHintMin    = time.Second
+	reconnectHintJitter = 4 * time.Second
+	// server:restarting を送り切るまで待つ上限
+	drainFlushTimeout = 2 * time.Second
+)
+
+type ServerRestartingEvent struct {
+	Type             string `json:"type"`
+	ReconnectAfterMs int64  `json:"reconnectAfterMs"`
+}
+
+func (h *Hub) Draining() bool { return h.draining.Load() }
+
+// Shutdown は自ノードの全接続に server:restarting を送って切断し、Run ループと送信用の goroutine を止める
+func (h *Hub) Shutdown(ctx context.Context) {
+	if !h.draining.CompareAndSwap(false, true) {
+		return
+	}
+
+	clients := allLocalClients()
+	for _, c := range clients {
+		hint := reconnectHintMin + time.Duration(rand.Int63n(int64(reconnectHintJitter)))
+		_ = c.sendJSON(ServerRestartingEvent{Type: "server:restarting", ReconnectAfterMs: hint.Milliseconds()})
+	}
+	waitFlushed(ctx, clients)
+
+	// CloseWith は 1 件ごとに writeTimeout まで待つことがあるので並行して閉じる
+	var closing sync.WaitGroup
+	for _, c := range clients {
+		closing.Add(1)
+		go func() {
+			defer closing.Done()
+			c.CloseWith(websocket.CloseServiceRestart, "server restarting")
+		}()
+	}
+	if !waitGroupDone(ctx, &closing) {
+		log.Println("⚠️ shutdown timed out while closing websocket clients")
+	}
+	// 切断で変わった状態（offline の通知）を Run ループが処理し終えてから止める
+	select {
+	case h.stop <- struct{}{}:
+	case <-ctx.Done():
+	}
+	h.drainCtx = ctx
+	close(h.quit)
+
+	// DB を閉じる前に、DB やブローカーを使う goroutine の終了を待つ
+	if !waitGroupDone(ctx, &h.loops) {
+		log.Println("⚠️ shutdown timed out while stopping hub loops")
+	}
+}
+
+// waitGroupDone は wg の完了か ctx の終了まで待つ（完了したら true）
+func waitGroupDone(ctx context.Context, wg *sync.WaitGroup) bool {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func waitFlushed(ctx context.Context, clients []*Client) {
+	deadline := time.NewTimer(drainFlushTimeout)
+	defer deadline.Stop()
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		pending := false
+		for _, c := range clients {
+			if len(c.send) > 0 {
+				pending = true
+				break
+			}
+		}
+		if !pending {
+			return
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-deadline.C:
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,214 @@
+package ws
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"chillow/db"
+	"chillow/model"
+)
+
+// ユーザー単位のオンライン状態
+const (
+	StatusOnline  = "online"
+	StatusAway    = "away"
+	StatusOffline = "offline"
+)
+
+func statusRank(status string) int {
+	switch status {
+	case StatusOnline:
+		return 2
+	case StatusAway:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// statusTable は自ノードと他ノードのユーザー状態を保持する。
+// 書き込みは Hub の Run ループからのみ、読み出しは任意の goroutine から行う。
+type statusTable struct {
+	mu     sync.RWMutex
+	local  map[uint]string
+	remote map[string]map[uint]string // ノードID → ユーザーID → 状態
+}
+
+func newStatusTable() *statusTable {
+	return &statusTable{
+		local:  make(map[uint]string),
+		remote: make(map[string]map[uint]string),
+	}
+}
+
+// 全ノードのうち最も「オンライン寄り」の状態を採用する
+func (t *statusTable) aggregateLocked(userID uint) string {
+	best := t.local[userID]
+	for _, users := range t.remote {
+		if s := users[userID]; statusRank(s) > statusRank(best) {
+			best = s
+		}
+	}
+	if best == "" {
+		return StatusOffline
+	}
+	return best
+}
+
+func (t *statusTable) aggregate(userID uint) string {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.aggregateLocked(userID)
+}
+
+// setLocal は自ノードの状態を更新し、変化があれば true と更新前後の集約状態を返す
+func (t *statusTable) setLocal(userID uint, status string) (before, after string, changed bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	prev := t.local[userID]
+	if prev == "" {
+		prev = StatusOffline
+	}
+	if prev == status {
+		return "", "", false
+	}
+	before = t.aggregateLocked(userID)
+	if status == StatusOffline {
+		delete(t.local, userID)
+	} else {
+		t.local[userID] = status
+	}
+	return before, t.aggregateLocked(userID), true
+}
+
+func (t *statusTable) localSnapshot() map[uint]string {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	out := make(map[uint]string, len(t.local))
+	for id, s := range t.local {
+		out[id] = s
+	}
+	return out
+}
+
+func (t *statusTable) setRemote(node string, userID uint, status string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	users := t.remote[node]
+	if users == nil {
+		users = make(map[uint]string)
+		t.remote[node] = users
+	}
+	if status == StatusOffline {
+		delete(users, userID)
+	} else {
+		users[userID] = status
+	}
+}
+
+func (t *statusTable) replaceRemote(node string, users map[uint]string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.remote[node] = users
+}
+
+// dropNode は停止したノードの状態を破棄し、集約状態が変わったユーザーを返す
+func (t *statusTable) dropNode(node string) map[uint]string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	users := t.remote[node]
+	before := make(map[uint]string, len(users))
+	for id := range users {
+		before[id] = t.aggregateLocked(id)
+	}
+	delete(t.remote, node)
+	changed := make(map[uint]string)
+	for id, prev := range before {
+		if now := t.aggregateLocked(id); now != prev {
+			changed[id] = now
+		}
+	}
+	return changed
+}
+
+// ---- 友達への通知 ----
+
+type statusNotice struct {
+	userID  uint
+	status  string
+	refresh bool // 公開設定の変更による再通知
+}
+
+func (h *Hub) queueNotice(n statusNotice) {
+	select {
+	case h.notices <- n:
+	default:
+		log.Printf("⚠️ presence notice queue full, dropping user %d", n.userID)
+	}
+}
+
+// DB 参照を伴うため Run ループとは別 goroutine で順番に処理する
+func (h *Hub) noticeLoop() {
+	defer h.loops.Done()
+	for {
+		select {
+		case <-h.quit:
+			h.drainNotices()
+			return
+		case n := <-h.notices:
+			notifyFriends(n)
+		}
+	}
+}
+
+// drainNotices は停止時に残った通知（停止のために切断した接続の offline など）を期限まで送り切る
+func (h *Hub) drainNotices() {
+	for {
+		select {
+		case <-h.drainCtx.Done():
+			log.Printf("⚠️ dropped %d presence notices at shutdown", len(h.notices))
+			return
+		case n := <-h.notices:
+			notifyFriends(n)
+		default:
+			return
+		}
+	}
+}
+
+func notifyFriends(n statusNotice) {
+	var user model.User
+	if err := db.DB.Select("id", "hide_presence", "last_seen_at").First(&user, n.userID).Error; err != nil {
+		log.Printf("⚠️ failed to load user %d for presence: %v", n.userID, err)
+		return
+	}
+	if !n.refresh {
+		now := time.Now()
+		if err := model.TouchLastSeen(n.userID, now); err != nil {
+			log.Printf("⚠️ failed to update last_seen_at for user %d: %v", n.userID, err)
+		}
+		user.LastSeenAt = &now
+		// 非公開ユーザーは接続・切断のタイミングも漏らさない
+		if user.HidePresence {
+			return
+		}
+	}
+
+	event := UserPresenceEvent{Type: "presence:user", UserID: n.userID, Status: n.status}
+	if user.HidePresence {
+		event.Status = StatusOffline
+	} else if user.LastSeenAt != nil {
+		seen := user.LastSeenAt.UTC().Format(time.RFC3339)
+		event.LastSeenAt = &seen
+	}
+
+	friendIDs, err := model.FriendIDs(n.userID)
+	if err != nil {
+		log.Printf("⚠️ failed to load friends of user %d: %v", n.userID, err)
+		return
+	}
+	for _, id := range friendIDs {
+		SendToUser(id, event)
+	}
+}
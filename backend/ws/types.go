@@ -6,28 +6,61 @@ type MessageDTO struct {
 	RoomID        string  `json:"room_id"`
 	SenderID      uint    `json:"sender_id"`
 	ReceiverID    uint    `json:"receiver_id"`
+	ClientMsgID   *string `json:"client_msg_id,omitempty"`
 	Content       string  `json:"content"`
 	MessageType   string  `json:"message_type"`
 	AttachmentURL *string `json:"attachment_url"`
 	AttachmentObj *string `json:"attachment_object"`
-	IsDeleted     bool    `json:"is_deleted"`
-	IsRead        bool    `json:"is_read"`
-	CreatedAt     string  `json:"created_at"`
-	EditedAt      *string `json:"edited_at"`
+	// 画像のみ。縮小版は元画像が小さい場合は省略される（attachment_url を使う）
+	AttachmentWidth     *int    `json:"attachment_width,omitempty"`
+	AttachmentHeight    *int    `json:"attachment_height,omitempty"`
+	AttachmentBlurhash  *string `json:"attachment_blurhash,omitempty"`
+	AttachmentThumbURL  *string `json:"attachment_thumb_url,omitempty"`
+	AttachmentMediumURL *string `json:"attachment_medium_url,omitempty"`
+	// 元のファイル名とサイズ（再生時間は音声のみ）
+	AttachmentName       *string `json:"attachment_name,omitempty"`
+	AttachmentSize       *int64  `json:"attachment_size,omitempty"`
+	AttachmentDurationMs *int64  `json:"attachment_duration_ms,omitempty"`
+	IsDeleted            bool    `json:"is_deleted"`
+	IsRead               bool    `json:"is_read"`
+	Seq                  uint64  `json:"seq"`
+	CreatedAt            string  `json:"created_at"`
+	EditedAt             *string `json:"edited_at"`
 }
 
 type MessageEvent struct {
 	Type    string     `json:"type"`
 	RoomID  string     `json:"roomId"`
+	Seq     uint64     `json:"seq,omitempty"`
 	Message MessageDTO `json:"message"`
 }
 
+type MessagesExpiredEvent struct {
+	Type       string `json:"type"`
+	RoomID     string `json:"roomId"`
+	Seq        uint64 `json:"seq,omitempty"`
+	MessageIDs []uint `json:"messageIds"`
+}
+
 type PresenceEvent struct {
 	Type   string `json:"type"`
 	RoomID string `json:"roomId"`
 	Users  []uint `json:"users"`
 }
 
+// 友達のオンライン状態の変化（ルーム参加の有無に関係なく届く）
+type UserPresenceEvent struct {
+	Type       string  `json:"type"`
+	UserID     uint    `json:"userId"`
+	Status     string  `json:"status"`
+	LastSeenAt *string `json:"lastSeenAt,omitempty"`
+}
+
+type PresenceSetEvent struct {
+	Type   string `json:"type"`
+	Status string `json:"status"` // "online" | "away"
+}
+
 type TypingBroadcast struct {
 	Type   string `json:"type"`
 	RoomID string `json:"roomId"`
@@ -40,12 +73,24 @@ type JoinEvent struct {
 }
 
 type SendMessageEvent struct {
-	Type          string  `json:"type"`
-	RoomID        string  `json:"roomId"`
-	Content       string  `json:"content"`
-	MessageType   string  `json:"messageType"`
-	AttachmentURL *string `json:"attachmentUrl"`
-	AttachmentObj *string `json:"attachmentObject"`
+	Type         string `json:"type"`
+	RoomID       string `json:"roomId"`
+	ClientMsgID  string `json:"clientMsgId"`
+	Content      string `json:"content"`
+	MessageType  string `json:"messageType"`
+	AttachmentID *uint  `json:"attachmentId"` // /messages/media で取得した添付ID
+}
+
+// MessageAckEvent ... message:send の結果を送信元ソケットにのみ返す
+type MessageAckEvent struct {
+	Type        string      `json:"type"`
+	RequestID   string      `json:"requestId,omitempty"`
+	RoomID      string      `json:"roomId"`
+	ClientMsgID string      `json:"clientMsgId,omitempty"`
+	MessageID   uint        `json:"messageId,omitempty"`
+	Duplicate   bool        `json:"duplicate,omitempty"`
+	Error       string      `json:"error,omitempty"`
+	Message     *MessageDTO `json:"message,omitempty"`
 }
 
 type EditMessageEvent struct {
@@ -0,0 +1,126 @@
+package ws
+
+import (
+	"encoding/json"
+	"log"
+	"time"
+
+	"chillow/broker"
+)
+
+const (
+	// typing:start が来なくなってから自動で typing:stop を出すまでの時間
+	typingTimeout = 6 * time.Second
+	// 同じユーザーの typing:start を再配信する最短間隔
+	typingThrottle      = 2 * time.Second
+	typingSweepInterval = time.Second
+)
+
+type typingKey struct {
+	roomID string
+	userID uint
+}
+
+type typingState struct {
+	client    *Client
+	expiresAt time.Time
+	lastSent  time.Time
+}
+
+type typingKind int
+
+const (
+	typingStart typingKind = iota
+	typingStop
+	typingDisconnect
+)
+
+type typingReq struct {
+	kind   typingKind
+	roomID string
+	userID uint
+	client *Client
+}
+
+// 外部から呼ぶAPI
+func (h *Hub) StartTyping(roomID string, c *Client) {
+	enqueue(h, h.typingReqs, typingReq{kind: typingStart, roomID: roomID, userID: c.userID, client: c})
+}
+
+func (h *Hub) StopTyping(roomID string, userID uint) {
+	enqueue(h, h.typingReqs, typingReq{kind: typingStop, roomID: roomID, userID: userID})
+}
+
+func (h *Hub) clearTyping(c *Client) {
+	enqueue(h, h.typingReqs, typingReq{kind: typingDisconnect, client: c})
+}
+
+// 以下は Run ループ内でのみ呼ぶ
+
+func (h *Hub) handleTypingReq(req typingReq, now time.Time) {
+	switch req.kind {
+	case typingStart:
+		key := typingKey{roomID: req.roomID, userID: req.userID}
+		state, ok := h.typing[key]
+		if !ok {
+			state = &typingState{}
+			h.typing[key] = state
+		}
+		state.client = req.client
+		state.expiresAt = now.Add(typingTimeout)
+		if ok && now.Sub(state.lastSent) < typingThrottle {
+			return
+		}
+		state.lastSent = now
+		h.emitTyping("typing:start", req.roomID, req.userID)
+	case typingStop:
+		key := typingKey{roomID: req.roomID, userID: req.userID}
+		if _, ok := h.typing[key]; !ok {
+			return
+		}
+		delete(h.typing, key)
+		h.emitTyping("typing:stop", req.roomID, req.userID)
+	case typingDisconnect:
+		for key, state := range h.typing {
+			if state.client != req.client {
+				continue
+			}
+			delete(h.typing, key)
+			h.emitTyping("typing:stop", key.roomID, key.userID)
+		}
+	}
+}
+
+func (h *Hub) expireTyping(now time.Time) {
+	for key, state := range h.typing {
+		if now.Before(state.expiresAt) {
+			continue
+		}
+		delete(h.typing, key)
+		h.emitTyping("typing:stop", key.roomID, key.userID)
+	}
+}
+
+// Run ループからは Broadcast を呼べない（ローカル配信でループ自身を待つため）ので、
+// ブローカー接続時は送信キュー、未接続時はルームへ直接配信する
+func (h *Hub) emitTyping(eventType, roomID string, userID uint) {
+	data, err := json.Marshal(TypingBroadcast{Type: eventType, RoomID: roomID, UserID: userID})
+	if err != nil {
+		return
+	}
+	if h.broker == nil {
+		if r, ok := h.rooms[roomID]; ok {
+			r.Broadcast(data)
+		}
+		return
+	}
+	payload, err := json.Marshal(roomEnvelope{RoomID: roomID, Data: data})
+	if err != nil {
+		return
+	}
+	select {
+	case h.publish <- outboundMessage{channel: broker.ChannelRoom, payload: payload}:
+	default:
+		log.Printf("⚠️ typing publish queue full, dropping %s", eventType)
+	}
+}
@@ -2,7 +2,7 @@ import { useCallback, useEffect, useMemo, useRef, useState, type KeyboardEvent,
 import type { MessagePayload } from "../../types/chat";
 import type { Friend } from "../../types/friend";
 import { useChatSocket } from "../../hooks/useChatSocket";
-import { uploadMessageAttachment, reportMessage } from "../../services/api/chat";
+import { uploadMessageAttachment, reportMessage, type AttachmentKind } from "../../services/api/chat";
 import { EMOJI_PRESETS, STICKER_PRESETS, type PickerMode, type EmojiPreset, type StickerPreset } from "../../constants/chatPalette";
 import { useIsMobile } from "../../hooks/useIsMobile";
 
@@ -29,6 +29,23 @@ const formatDateLabel = (value: string) => {
 	return `${month}/${day}`;
 };
 
+const attachmentKindOf = (file: File): AttachmentKind => {
+	if (file.type.startsWith("image/")) return "image";
+	if (file.type.startsWith("audio/")) return "audio";
+	return "file";
+};
+
+const formatFileSize = (bytes: number) => {
+	if (bytes < 1024) return `${bytes} B`;
+	if (bytes < 1024 * 1024) return `${(bytes / 1024).toFixed(1)} KB`;
+	return `${(bytes / (1024 * 1024)).toFixed(1)} MB`;
+};
+
+const formatDuration = (ms: number) => {
+	const total = Math.round(ms / 1000);
+	return `${Math.floor(total / 60)}:${(total % 60).toString().padStart(2, "0")}`;
+};
+
 const ChatRoom = ({ friend, showHeader = true }: Props) => {
 	const chatEndRef = useRef<HTMLDivElement>(null);
 	const messageListRef = useRef<HTMLDivElement>(null);
@@ -38,6 +55,7 @@ const ChatRoom = ({ friend, showHeader = true }: Props) => {
 	const isComposing = useRef(false);
 	const typingPulseRef = useRef<number | null>(null);
 	const typingActiveRef = useRef(false);
+	const typingSentAtRef = useRef(0);
 	const {
 		messages,
 		sendMessage,
@@ -132,9 +150,12 @@ const ChatRoom = ({ friend, showHeader = true }: Props) => {
 
 	const handleMessageChange = (e: ChangeEvent<HTMLInputElement>) => {
 		setMessageText(e.target.value);
-		if (!typingActiveRef.current) {
+		// サーバー側の入力状態は数秒で期限切れになるため、入力が続く間は定期的に送り直す
+		const now = Date.now();
+		if (!typingActiveRef.current || now - typingSentAtRef.current > 3000) {
 			notifyTypingStart();
 			typingActiveRef.current = true;
+			typingSentAtRef.current = now;
 		}
 		resetTypingPulse();
 	};
@@ -177,8 +198,9 @@ const ChatRoom = ({ friend, showHeader = true }: Props) => {
 		if (!file) return;
 		setUploading(true);
 		try {
-			const resp = await uploadMessageAttachment(file);
-			sendMessage("", { messageType: "image", attachmentUrl: resp.url, attachmentObject: resp.objectKey ?? null });
+			const kind = attachmentKindOf(file);
+			const resp = await uploadMessageAttachment(file, kind);
+			sendMessage("", { messageType: kind, attachmentId: resp.attachment_id });
 		} catch (error) {
 			console.error("❌ ファイル送信に失敗", error);
 		} finally {
@@ -400,7 +422,26 @@ const ChatRoom = ({ friend, showHeader = true }: Props) => {
 								{msg.is_deleted ? (
 									<p className="italic text-sm text-gray-300">このメッセージは削除されました</p>
 								) : msg.message_type === "image" && msg.attachment_url ? (
-									<img src={msg.attachment_url} alt="upload" className="rounded-lg max-h-64 object-cover" />
+									<img
+										src={msg.attachment_medium_url ?? msg.attachment_url}
+										width={msg.attachment_width ?? undefined}
+										height={msg.attachment_height ?? undefined}
+										loading="lazy"
+										alt="upload"
+										className="rounded-lg max-h-64 w-auto object-cover"
+									/>
+								) : msg.message_type === "audio" && msg.attachment_url ? (
+									<div className="flex flex-col gap-1">
+										<audio controls preload="none" src={msg.attachment_url} className="max-w-[260px]" />
+										{msg.attachment_duration_ms ? (
+											<span className="text-xs opacity-70">{formatDuration(msg.attachment_duration_ms)}</span>
+										) : null}
+									</div>
+								) : msg.message_type === "file" && msg.attachment_url ? (
+									<a href={msg.attachment_url} className="flex items-center gap-2 underline break-all">
+										<span>📎 {msg.attachment_name ?? "ファイル"}</span>
+										{msg.attachment_size ? <span className="text-xs opacity-70">({formatFileSize(msg.attachment_size)})</span> : null}
+									</a>
 								) : msg.message_type === "sticker" ? (
 									typeof msg.content === "string" && (msg.content.startsWith("/") || msg.content.startsWith("http")) ? (
 										<img src={msg.content} alt="sticker" className="w-[220px] h-[220px] object-contain" />
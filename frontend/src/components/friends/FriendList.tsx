@@ -33,6 +33,10 @@ const buildLastMessagePreview = (friend: Friend) => {
 	switch (friend.last_message_type) {
 		case "image":
 			return `${ownLabel} : 画像`;
+		case "file":
+			return `${ownLabel} : ${friend.last_message_attachment_name ?? "ファイル"}`;
+		case "audio":
+			return `${ownLabel} : ボイスメッセージ`;
 		case "sticker":
 			return `${ownLabel} : スタンプ`;
 		default:
@@ -10,8 +10,7 @@ import type { MessageDTO } from "../types/ws";
 
 type SendMessageOptions = {
 	messageType?: MessagePayload["message_type"];
-	attachmentUrl?: string | null;
-	attachmentObject?: string | null;
+	attachmentId?: number | null;
 };
 
 export function useChatSocket(friendUserId: number) {
@@ -39,6 +38,11 @@ export function useChatSocket(friendUserId: number) {
 				message_type: base.message_type ?? "text",
 				attachment_url: base.attachment_url ?? null,
 				attachment_object: base.attachment_object ?? null,
+				attachment_width: base.attachment_width ?? null,
+				attachment_height: base.attachment_height ?? null,
+				attachment_blurhash: base.attachment_blurhash ?? null,
+				attachment_thumb_url: base.attachment_thumb_url ?? null,
+				attachment_medium_url: base.attachment_medium_url ?? null,
 				created_at: base.created_at!,
 				edited_at: base.edited_at ?? null,
 				is_deleted: base.is_deleted ?? false,
@@ -179,14 +183,13 @@ export function useChatSocket(friendUserId: number) {
 			const messageType = options?.messageType ?? "text";
 			const trimmed = content.trim();
 			if (messageType === "text" && !trimmed) return;
-			if (messageType === "image" && !options?.attachmentUrl) return;
+			if ((messageType === "image" || messageType === "file" || messageType === "audio") && !options?.attachmentId) return;
 			send({
 				type: "message:send",
 				roomId,
 				content: trimmed,
 				messageType,
-				attachmentUrl: options?.attachmentUrl ?? null,
-				attachmentObject: options?.attachmentObject ?? null,
+				attachmentId: options?.attachmentId ?? null,
 			});
 		},
 		[roomId, send]
@@ -132,6 +132,8 @@ export function useFriendsData() {
 						last_message_content: dto.content,
 						last_message_type: dto.message_type,
 						last_message_attachment_url: dto.attachment_url ?? null,
+						last_message_attachment_name: dto.attachment_name ?? null,
+						last_message_attachment_duration_ms: dto.attachment_duration_ms ?? null,
 						last_message_at: dto.created_at,
 						last_message_edited_at: dto.edited_at ?? null,
 						last_message_is_deleted: dto.is_deleted,
@@ -150,13 +152,17 @@ export function useFriendsData() {
 		const offUpdated = onType("message:updated", (event) => applyMessageMeta(event.message, "updated"));
 		const offDeleted = onType("message:deleted", (event) => applyMessageMeta(event.message, "deleted"));
 		const offRead = onType("message:read", (event) => applyMessageMeta(event.message, "updated"));
-		const offPresence = onType("presence:update", (event) => {
-			const friendId = parseFriendIdFromRoom(event.roomId);
-			if (!friendId) return;
+		// 友達一覧のオンライン表示はルーム単位の presence:update ではなくユーザー単位の presence:user を使う
+		const offUserPresence = onType("presence:user", (event) => {
 			setFriends((prev) =>
 				prev.map((friend) =>
-					friend.friend_id === friendId
-						? { ...friend, is_online: event.users.includes(friendId) }
+					friend.friend_id === event.userId
+						? {
+								...friend,
+								presence: event.status,
+								is_online: event.status !== "offline",
+								last_seen_at: event.lastSeenAt ?? null,
+							}
 						: friend
 				)
 			);
@@ -171,7 +177,7 @@ export function useFriendsData() {
 			offUpdated();
 			offDeleted();
 			offRead();
-			offPresence();
+			offUserPresence();
 			offRevoked();
 		};
 	}, [applyMessageMeta, onType, parseFriendIdFromRoom]);
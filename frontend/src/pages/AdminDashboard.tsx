@@ -2,8 +2,25 @@ import { useEffect, useMemo, useState } from "react";
 import { useNavigate } from "react-router-dom";
 import { useRecoilValue, useSetRecoilState } from "recoil";
 import { authLoadingState, currentUserState } from "../store/auth";
-import type { AdminReport, BannedUser } from "../types/admin";
-import { fetchReports, resolveReport, fetchBannedUsers, unbanUser } from "../services/api/admin";
+import type { AdminReport, AdminUserDetail, BannedUser, MalwareDetection, Sanction } from "../types/admin";
+
+const sanctionLabels: Record<Sanction["kind"], string> = {
+	warning: "警告",
+	mute: "送信停止",
+	temp_ban: "期限付き BAN",
+	permanent_ban: "無期限 BAN",
+};
+import {
+	fetchReports,
+	resolveReport,
+	fetchBannedUsers,
+	unbanUser,
+	fetchMyPermissions,
+	searchUsers,
+	fetchUserDetail,
+	fetchSanctions,
+	revokeSanction,
+} from "../services/api/admin";
 import { logout } from "../services/api/auth";
 import { clearStoredUser } from "../utils/authStorage";
 
@@ -13,10 +30,20 @@ const AdminDashboard = () => {
 	const setAuthLoading = useSetRecoilState(authLoadingState);
 	const [reports, setReports] = useState<AdminReport[]>([]);
 	const [bannedUsers, setBannedUsers] = useState<BannedUser[]>([]);
+	// ウイルス検査で拒否された添付（画面を開いてから受信したもののみ）
+	const [permissions, setPermissions] = useState<string[]>([]);
+	const [detections, setDetections] = useState<(MalwareDetection & { nickname?: string })[]>([]);
 	const [loading, setViewLoading] = useState(true);
 	const [error, setError] = useState<string | null>(null);
 	const [resolvingId, setResolvingId] = useState<number | null>(null);
-	const [activeTab, setActiveTab] = useState<"reports" | "banned">("reports");
+	const [activeTab, setActiveTab] = useState<"reports" | "banned" | "users">("reports");
+	// ユーザー検索（users.view）
+	const [userQuery, setUserQuery] = useState("");
+	const [foundUsers, setFoundUsers] = useState<BannedUser[]>([]);
+	const [foundTotal, setFoundTotal] = useState(0);
+	const [nextBeforeId, setNextBeforeId] = useState<number | null>(null);
+	const [searching, setSearching] = useState(false);
+	const [userDetail, setUserDetail] = useState<AdminUserDetail | null>(null);
 	const navigate = useNavigate();
 	const apiBase = useMemo(() => import.meta.env.VITE_API_URL ?? "http://localhost:8080/api", []);
 	const adminAvatar = currentUser?.avatar_url ?? "";
@@ -26,7 +53,12 @@ const AdminDashboard = () => {
 		try {
 			setError(null);
 			setViewLoading(true);
-			const [reportsRes, bansRes] = await Promise.all([fetchReports("pending"), fetchBannedUsers()]);
+			const perms = await fetchMyPermissions();
+			setPermissions(perms);
+			const [reportsRes, bansRes] = await Promise.all([
+				fetchReports("pending"),
+				perms.includes("users.ban") ? fetchBannedUsers() : Promise.resolve([]),
+			]);
 			setReports(reportsRes);
 			setBannedUsers(bansRes);
 		} catch (err) {
@@ -46,7 +78,7 @@ const AdminDashboard = () => {
 		const source = new EventSource(`${apiBase}/admin/events`, { withCredentials: true });
 		source.onmessage = (event) => {
 			try {
-				const payload = JSON.parse(event.data) as { type: string; report?: AdminReport; user?: BannedUser };
+				const payload = JSON.parse(event.data) as { type: string; report?: AdminReport; user?: BannedUser; detection?: MalwareDetection };
 				switch (payload.type) {
 					case "report:new":
 						if (payload.report) {
@@ -74,6 +106,12 @@ const AdminDashboard = () => {
 							setBannedUsers((prev) => prev.filter((user) => user.id !== payload.user!.id));
 						}
 						break;
+					case "attachment:infected":
+						if (payload.detection) {
+							const detection = { ...payload.detection, nickname: payload.user?.nickname };
+							setDetections((prev) => [detection, ...prev].slice(0, 20));
+						}
+						break;
 					default:
 						break;
 				}
@@ -95,9 +133,18 @@ const AdminDashboard = () => {
 			if (action === "ban") {
 				const banReason = window.prompt("BAN理由を入力してください", `通報理由: ${report.reason}`);
 				if (!banReason) return;
-				const durationInput = window.prompt("BAN期間を時間で指定（空欄で無期限）");
+				// 違反の回数に応じた提案を既定値にする。無期限 BAN は users.ban_permanent を持つロールのみ
+				const { strikes, suggestion } = await fetchSanctions(report.reported_user_id);
+				const canBanPermanently = permissions.includes("users.ban_permanent");
+				const suggested = suggestion.kind === "temp_ban" ? String(suggestion.duration_hours ?? "") : "";
+				const durationInput = window.prompt(
+					`${canBanPermanently ? "BAN期間を時間で指定（空欄で無期限）" : "BAN期間を時間で指定"}\n違反 ${strikes} 回 / 提案: ${sanctionLabels[suggestion.kind]}`,
+					suggested,
+				);
+				if (durationInput === null) return;
+				if (!canBanPermanently && !durationInput) return;
 				const duration = durationInput ? Number(durationInput) : null;
-				const updated = await resolveReport(report.id, { action: "ban", ban_reason: banReason, duration_hours: duration ?? undefined });
+				const updated = await resolveReport(report.id, { action: "ban", reason: banReason, duration_hours: duration ?? undefined });
 				setReports((prev) => prev.filter((item) => item.id !== report.id));
 				if (updated.reported_user) {
 					setBannedUsers((prev) => {
@@ -122,9 +169,11 @@ const AdminDashboard = () => {
 	};
 
 	const handleUnban = async (userId: number) => {
-		if (!window.confirm("BANを解除しますか？")) return;
+		// 理由は監査ログに残る（キャンセルで中止）
+		const reason = window.prompt("BAN解除の理由を入力してください（任意）");
+		if (reason === null) return;
 		try {
-			await unbanUser(userId);
+			await unbanUser(userId, reason);
 			setBannedUsers((prev) => prev.filter((user) => user.id !== userId));
 		} catch (err) {
 			console.error("❌ BAN解除に失敗", err);
@@ -132,6 +181,46 @@ const AdminDashboard = () => {
 		}
 	};
 
+	const handleSearchUsers = async (more = false) => {
+		try {
+			setSearching(true);
+			const page = await searchUsers({ q: userQuery.trim() || undefined, before_id: more ? nextBeforeId ?? undefined : undefined });
+			setFoundUsers((prev) => (more ? [...prev, ...page.users] : page.users));
+			setFoundTotal(page.total);
+			setNextBeforeId(page.next_before_id);
+		} catch (err) {
+			console.error("❌ ユーザー検索に失敗", err);
+			alert("ユーザー検索に失敗しました");
+		} finally {
+			setSearching(false);
+		}
+	};
+
+	const handleOpenUser = async (userId: number) => {
+		if (userDetail?.user.id === userId) {
+			setUserDetail(null);
+			return;
+		}
+		try {
+			setUserDetail(await fetchUserDetail(userId));
+		} catch (err) {
+			console.error("❌ ユーザー詳細の取得に失敗", err);
+			alert("ユーザー詳細の取得に失敗しました");
+		}
+	};
+
+	const handleRevokeSanction = async (sanction: Sanction) => {
+		const reason = window.prompt("取り消しの理由を入力してください（取り消した制裁は違反の回数に数えません）");
+		if (!reason) return;
+		try {
+			await revokeSanction(sanction.id, reason);
+			setUserDetail(await fetchUserDetail(sanction.user_id));
+		} catch (err) {
+			console.error("❌ 制裁の取り消しに失敗", err);
+			alert("制裁の取り消しに失敗しました");
+		}
+	};
+
 	const handleLogout = async () => {
 		try {
 			await logout();
@@ -150,7 +239,17 @@ const AdminDashboard = () => {
 			<header className="bg-[#292b31] border-b border-white/5">
 				<div className="mx-auto flex w-full max-w-8xl flex-col gap-2 px-4 py-4 sm:px-6">
 					<p className="text-sm text-white/60">運営専用モニタリング</p>
-					<h1 className="text-2xl font-semibold">管理ダッシュボード</h1>
+					<div className="flex items-center justify-between gap-3">
+						<h1 className="text-2xl font-semibold">管理ダッシュボード</h1>
+						{permissions.includes("audit.view") && (
+							<a
+								href={`${apiBase}/admin/audit?format=csv`}
+								className="rounded-lg bg-white/10 px-3 py-1.5 text-xs font-semibold text-white/80 hover:bg-white/20"
+							>
+								監査ログ (CSV)
+							</a>
+						)}
+					</div>
 				</div>
 			</header>
 
@@ -170,14 +269,35 @@ const AdminDashboard = () => {
 					</div>
 				</section>
 
+				{detections.length > 0 && (
+					<section className="rounded-2xl border border-red-500/30 bg-red-500/10 p-4 shadow-lg">
+						<h2 className="text-sm font-semibold text-red-300">ウイルス検査で拒否された添付</h2>
+						<ul className="mt-2 space-y-1 text-xs text-white/70">
+							{detections.map((d) => (
+								<li key={`${d.sha256}-${d.detected_at}`} className="break-all">
+									{new Date(d.detected_at).toLocaleString()} / {d.nickname ?? `ユーザー #${d.user_id}`} / {d.filename}（{d.signature}）
+								</li>
+							))}
+						</ul>
+					</section>
+				)}
+
 				<section className="rounded-2xl border border-white/10 bg-[#24262f] shadow-2xl">
 					<div className="flex gap-4 border-b border-white/5 px-4 py-4 sm:items-center sm:px-6">
 						<div className="flex-1">
-							<h2 className="text-xl font-semibold">{activeTab === "reports" ? "通報一覧" : "BAN中ユーザー"}</h2>
+							<h2 className="text-xl font-semibold">
+								{activeTab === "reports" ? "通報一覧" : activeTab === "banned" ? "BAN中ユーザー" : "ユーザー検索"}
+							</h2>
 							<p className="hidden text-sm text-white/60 sm:block">
-								{activeTab === "reports" ? "ユーザーから寄せられた通報の詳細を確認できます。" : "現在制限中のユーザー一覧です。"}
+								{activeTab === "reports"
+									? "ユーザーから寄せられた通報の詳細を確認できます。"
+									: activeTab === "banned"
+										? "現在制限中のユーザー一覧です。"
+										: "メールアドレス・ニックネーム・フレンドコードで検索できます。"}
+							</p>
+							<p className="text-xs text-white/60 sm:hidden">
+								{activeTab === "reports" ? "通報詳細を確認" : activeTab === "banned" ? "BAN中ユーザー一覧" : "ユーザーを検索"}
 							</p>
-							<p className="text-xs text-white/60 sm:hidden">{activeTab === "reports" ? "通報詳細を確認" : "BAN中ユーザー一覧"}</p>
 						</div>
 						<div className="ml-auto flex flex-row justify-end gap-1 text-[0.6rem] text-right sm:w-auto sm:gap-2 sm:text-sm sm:text-left">
 							<button
@@ -202,6 +322,19 @@ const AdminDashboard = () => {
 							>
 								BAN中ユーザー
 							</button>
+							{permissions.includes("users.view") && (
+								<button
+									type="button"
+									className={`min-w-[4.2rem] rounded-lg px-1 py-0.5 font-semibold transition sm:min-w-[9rem] sm:px-3 sm:py-1.5 ${
+										activeTab === "users"
+											? "bg-discord-accent text-white shadow-lg"
+											: "bg-white/5 text-white/70 hover:bg-white/10"
+									}`}
+									onClick={() => setActiveTab("users")}
+								>
+									ユーザー検索
+								</button>
+							)}
 						</div>
 					</div>
 
@@ -250,6 +383,105 @@ const AdminDashboard = () => {
 									</ul>
 								)}
 							</>
+						) : activeTab === "users" ? (
+							<>
+								<form
+									className="mb-4 flex gap-2"
+									onSubmit={(e) => {
+										e.preventDefault();
+										handleSearchUsers();
+									}}
+								>
+									<input
+										value={userQuery}
+										onChange={(e) => setUserQuery(e.target.value)}
+										placeholder="メールアドレス / ニックネーム / フレンドコード"
+										className="flex-1 rounded-lg bg-black/30 px-3 py-2 text-sm text-white placeholder:text-white/40"
+									/>
+									<button
+										type="submit"
+										className="rounded-lg bg-white/10 px-3 py-2 text-sm font-semibold hover:bg-white/20 disabled:opacity-50"
+										disabled={searching}
+									>
+										検索
+									</button>
+								</form>
+								{foundTotal > 0 && <p className="mb-2 text-xs text-white/50">{foundTotal} 件</p>}
+								{foundUsers.length === 0 ? (
+									<p className="text-sm text-white/60">該当するユーザーはいません。</p>
+								) : (
+									<ul className="max-h-[65vh] space-y-3 overflow-y-auto pr-1">
+										{foundUsers.map((user) => (
+											<li key={user.id} className="rounded-2xl border border-white/10 bg-[#1f2129] p-4 shadow-inner">
+												<button type="button" className="w-full text-left" onClick={() => handleOpenUser(user.id)}>
+													<p className="font-semibold">
+														{user.nickname} <span className="text-xs text-white/50">#{user.id} / {user.friend_code} / {user.role}</span>
+														{user.is_banned && <span className="ml-2 text-xs text-red-300">BAN中</span>}
+													</p>
+													<p className="text-xs text-white/60 break-all">{user.email}</p>
+												</button>
+												{userDetail?.user.id === user.id && (
+													<div className="mt-3 space-y-2 text-xs text-white/70">
+														<p>
+															登録: {new Date(userDetail.user.created_at).toLocaleString()} / 友達 {userDetail.friend_count} 人 / 送信{" "}
+															{userDetail.messages.sent_total} 件（30日 {userDetail.messages.sent_recent} 件）/ 受信 {userDetail.messages.received_total} 件
+														</p>
+														<p>
+															通報された {userDetail.reports.received_count} 件 / 通報した {userDetail.reports.filed_count} 件
+														</p>
+														{userDetail.reports.received.map((report) => (
+															<p key={report.id} className="pl-2 text-white/50">
+																{report.status} / {report.reason}（{report.reporter.nickname}）
+															</p>
+														))}
+														<p>
+															制裁 / 違反 {userDetail.sanctions.strikes} 回（直近 {Math.round(userDetail.sanctions.strike_window_hours / 24)} 日）/ 次の提案:{" "}
+															{sanctionLabels[userDetail.sanctions.suggestion.kind]}
+															{userDetail.sanctions.suggestion.duration_hours ? `（${userDetail.sanctions.suggestion.duration_hours} 時間）` : ""}
+														</p>
+														{userDetail.sanctions.sanctions.length === 0 ? (
+															<p className="pl-2 text-white/50">なし</p>
+														) : (
+															userDetail.sanctions.sanctions.map((sanction) => (
+																<p key={sanction.id} className={`pl-2 ${sanction.voided ? "text-white/30 line-through" : "text-white/50"}`}>
+																	{new Date(sanction.created_at).toLocaleString()} {sanctionLabels[sanction.kind]} /{" "}
+																	{sanction.issuer?.nickname ?? (sanction.issued_by ? `#${sanction.issued_by}` : "移行")} / {sanction.reason}
+																	{sanction.expires_at && ` / 〜${new Date(sanction.expires_at).toLocaleString()}`}
+																	{sanction.revoked_at && !sanction.voided && " / 解除済み"}
+																	{!sanction.voided &&
+																		permissions.includes(sanction.kind === "permanent_ban" ? "users.ban_permanent" : "users.ban") && (
+																		<button type="button" className="ml-2 underline" onClick={() => handleRevokeSanction(sanction)}>
+																			取り消し
+																		</button>
+																	)}
+																</p>
+															))
+														)}
+														<p>最近のログイン</p>
+														{userDetail.sessions.map((session) => (
+															<p key={session.id} className="pl-2 text-white/50 break-all">
+																{new Date(session.created_at).toLocaleString()} / {session.ip} / {session.user_agent}
+															</p>
+														))}
+													</div>
+												)}
+											</li>
+										))}
+										{nextBeforeId && (
+											<li>
+												<button
+													type="button"
+													className="w-full rounded-lg bg-white/5 px-3 py-2 text-sm hover:bg-white/10 disabled:opacity-50"
+													disabled={searching}
+													onClick={() => handleSearchUsers(true)}
+												>
+													さらに表示
+												</button>
+											</li>
+										)}
+									</ul>
+								)}
+							</>
 						) : (
 							<>
 								{bannedUsers.length === 0 ? (
@@ -4,6 +4,7 @@ import { authLoadingState, currentUserState } from "../store/auth";
 import { loginWithGoogle } from "../services/api/auth";
 import { Navigate, useNavigate } from "react-router-dom";
 import { storeUser } from "../utils/authStorage";
+import { isStaffRole } from "../types/user";
 
 const Login = () => {
 	const setUser = useSetRecoilState(currentUserState);
@@ -23,14 +24,14 @@ const Login = () => {
 			storeUser(res.user);
 			setUser(res.user);
 			setLoading(false);
-			navigate(res.user.role === "admin" ? "/admin" : "/");
+			navigate(isStaffRole(res.user.role) ? "/admin" : "/");
 		} catch (err) {
 			console.error("❌ サーバー認証に失敗しました", err);
 		}
 	};
 
 	if (currentUser) {
-		return <Navigate to={currentUser.role === "admin" ? "/admin" : "/"} replace />;
+		return <Navigate to={isStaffRole(currentUser.role) ? "/admin" : "/"} replace />;
 	}
 
 	return (
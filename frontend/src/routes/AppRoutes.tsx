@@ -5,6 +5,7 @@ import Mypage from '../pages/Mypage';
 import FriendManage from '../pages/FriendManage';
 import PrivateRoute from './PrivateRoute';
 import AdminDashboard from '../pages/AdminDashboard';
+import { STAFF_ROLES } from '../types/user';
 
 const AppRoutes = () => (
   <BrowserRouter>
@@ -21,7 +22,7 @@ const AppRoutes = () => (
           </PrivateRoute>
         }
       />
-      <Route path="/admin" element={<PrivateRoute allowRoles={['admin']}><AdminDashboard /></PrivateRoute>} />
+      <Route path="/admin" element={<PrivateRoute allowRoles={STAFF_ROLES}><AdminDashboard /></PrivateRoute>} />
     </Routes>
   </BrowserRouter>
 );
@@ -2,6 +2,7 @@ import { Navigate } from 'react-router-dom';
 import { useRecoilValue } from 'recoil';
 import { authLoadingState, currentUserState } from '../store/auth';
 import type { ReactElement } from 'react';
+import { isStaffRole } from '../types/user';
 
 interface Props {
 	children: ReactElement;
@@ -26,7 +27,7 @@ const PrivateRoute = ({ children, allowRoles, redirectPath }: Props): ReactEleme
 	}
 
 	if (allowRoles && !allowRoles.includes(currentUser.role)) {
-		const fallback = currentUser.role === "admin" ? "/admin" : "/";
+		const fallback = isStaffRole(currentUser.role) ? "/admin" : "/";
 		return <Navigate to={redirectPath ?? fallback} replace />;
 	}
 
@@ -1,5 +1,5 @@
 import axios from "../../utils/axios";
-import type { AdminReport, BannedUser } from "../../types/admin";
+import type { AdminReport, AdminUserDetail, AdminUserPage, BannedUser, Sanction, SanctionKind, SanctionSummary } from "../../types/admin";
 
 export const fetchReports = async (status: "pending" | "all" = "pending"): Promise<AdminReport[]> => {
 	const res = await axios.get("/admin/reports", { params: { status } });
@@ -8,12 +8,18 @@ export const fetchReports = async (status: "pending" | "all" = "pending"): Promi
 
 export const resolveReport = async (
 	reportId: number,
-	payload: { action: "ban" | "reject"; note?: string; ban_reason?: string; duration_hours?: number | null },
+	payload: { action: "ban" | "warn" | "mute" | "reject"; note?: string; reason?: string; duration_hours?: number | null },
 ): Promise<AdminReport> => {
 	const res = await axios.post(`/admin/reports/${reportId}/resolve`, payload);
 	return res.data;
 };
 
+// 操作している本人の権限（ロールごとに異なる）
+export const fetchMyPermissions = async (): Promise<string[]> => {
+	const res = await axios.get("/admin/roles");
+	return res.data?.permissions ?? [];
+};
+
 export const fetchBannedUsers = async (): Promise<BannedUser[]> => {
 	const res = await axios.get("/admin/banned-users");
 	return res.data ?? [];
@@ -23,6 +29,38 @@ export const banUser = async (userId: number, reason: string, durationHours?: nu
 	await axios.post(`/admin/users/${userId}/ban`, { reason, duration_hours: durationHours });
 };
 
-export const unbanUser = async (userId: number): Promise<void> => {
-	await axios.post(`/admin/users/${userId}/unban`);
+export const unbanUser = async (userId: number, reason?: string): Promise<void> => {
+	await axios.post(`/admin/users/${userId}/unban`, { reason });
+};
+
+// メールアドレス・ニックネーム・フレンドコードで検索（before_id に next_before_id を渡して続きを取得）
+export const searchUsers = async (
+	params: { q?: string; role?: string; banned?: boolean; created_since?: string; created_until?: string; before_id?: number } = {},
+): Promise<AdminUserPage> => {
+	const res = await axios.get("/admin/users", { params });
+	return res.data;
+};
+
+export const fetchUserDetail = async (userId: number): Promise<AdminUserDetail> => {
+	const res = await axios.get(`/admin/users/${userId}`);
+	return res.data;
+};
+
+// 制裁の履歴と、違反の回数に応じた次の制裁の提案
+export const fetchSanctions = async (userId: number): Promise<SanctionSummary> => {
+	const res = await axios.get(`/admin/users/${userId}/sanctions`);
+	return res.data;
+};
+
+export const issueSanction = async (
+	userId: number,
+	payload: { kind: SanctionKind; reason: string; duration_hours?: number | null; report_id?: number },
+): Promise<Sanction> => {
+	const res = await axios.post(`/admin/users/${userId}/sanctions`, payload);
+	return res.data.sanction;
+};
+
+// 誤った制裁の取り消し（違反の回数に数えなくなる）
+export const revokeSanction = async (sanctionId: number, reason: string): Promise<void> => {
+	await axios.post(`/admin/sanctions/${sanctionId}/revoke`, { reason });
 };
@@ -10,13 +10,62 @@ export const markMessageAsRead = async (messageId: number): Promise<void> => {
 	await axios.post(`/messages/${messageId}/read`);
 };
 
-export const uploadMessageAttachment = async (file: File): Promise<{ url: string; objectKey?: string }> => {
-	const formData = new FormData();
-	formData.append("file", file);
-	const res = await axios.post("/messages/media", formData, {
-		headers: { "Content-Type": "multipart/form-data" },
+type PresignResponse = {
+	attachment_id: number;
+	objectKey: string;
+	upload: { method: string; url: string; headers: Record<string, string>; expires_at: string };
+};
+
+export type AttachmentKind = "image" | "file" | "audio";
+
+type UploadedAttachment = { attachment_id: number; kind: AttachmentKind; url: string; objectKey?: string };
+
+const sha256Hex = async (file: File): Promise<string | null> => {
+	// crypto.subtle は安全なコンテキスト（HTTPS / localhost）でのみ使える
+	if (!globalThis.crypto?.subtle) return null;
+	const digest = await crypto.subtle.digest("SHA-256", await file.arrayBuffer());
+	return Array.from(new Uint8Array(digest), (b) => b.toString(16).padStart(2, "0")).join("");
+};
+
+// 以前に同じ中身をアップロードしていれば送信を省く（確認できなければ通常どおりアップロードする）
+const preflightAttachment = async (file: File, kind: AttachmentKind): Promise<UploadedAttachment | null> => {
+	try {
+		const sha256 = await sha256Hex(file);
+		if (!sha256) return null;
+		const res = await axios.post<{ found: boolean } & UploadedAttachment>("/messages/media/preflight", {
+			kind,
+			filename: file.name,
+			sha256,
+			size: file.size,
+		});
+		return res.data.found ? res.data : null;
+	} catch {
+		return null;
+	}
+};
+
+// 署名付き URL でストレージへ直接アップロードし、完了をサーバーに確認させる
+export const uploadMessageAttachment = async (
+	file: File,
+	kind: AttachmentKind = "image"
+): Promise<UploadedAttachment> => {
+	const existing = await preflightAttachment(file, kind);
+	if (existing) {
+		return existing;
+	}
+	const presign = await axios.post<PresignResponse>("/messages/media/presign", {
+		kind,
+		filename: file.name,
+		content_type: file.type,
+		size: file.size,
 	});
-	return res.data;
+	const { upload, attachment_id } = presign.data;
+	const res = await fetch(upload.url, { method: upload.method, headers: upload.headers, body: file });
+	if (!res.ok) {
+		throw new Error(`upload failed: ${res.status}`);
+	}
+	const confirmed = await axios.post("/messages/media/confirm", { attachment_id });
+	return confirmed.data;
 };
 
 export const reportMessage = async (messageId: number, reason: string): Promise<void> => {
@@ -23,6 +23,8 @@ export class WSClient {
 	private reconnectTimer: number | null = null;
 	private readonly reconnectBaseDelayMs = 800;
 	private readonly reconnectMaxDelayMs = 30_000;
+	// server:restarting で指定された次回の再接続待ち時間
+	private restartHintMs: number | null = null;
 
 	constructor(baseUrl: string) {
 		this.baseUrl = baseUrl;
@@ -109,6 +111,7 @@ export class WSClient {
 		const ev = data as WsReceiveEvent;
 
 		if (ev.type === "pong") this.lastPongAt = Date.now();
+		if (ev.type === "server:restarting") this.restartHintMs = ev.reconnectAfterMs;
 
 		this.anyListeners.forEach((fn) => { try { fn(ev); } catch {} });
 		const set = this.typedListeners.get(ev.type);
@@ -136,10 +139,11 @@ export class WSClient {
 
 	private scheduleReconnect() {
 		this.clearReconnectTimer();
-		const delay = Math.min(
+		const delay = this.restartHintMs ?? Math.min(
 			this.reconnectBaseDelayMs * Math.pow(2, this.reconnectAttempts),
 			this.reconnectMaxDelayMs
 		);
+		this.restartHintMs = null;
 		this.reconnectAttempts += 1;
 
 		this.reconnectTimer = window.setTimeout(() => {
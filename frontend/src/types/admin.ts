@@ -19,9 +19,69 @@ export type AdminReport = {
 	reported_user: User;
 };
 
+export type MalwareDetection = {
+	user_id: number;
+	kind: string;
+	filename: string;
+	size: number;
+	sha256: string;
+	signature: string;
+	scanner: string;
+	detected_at: string;
+};
+
 export type BannedUser = User & {
 	is_banned: boolean;
 	banned_at?: string | null;
 	ban_reason?: string | null;
 	ban_expires_at?: string | null;
 };
+
+export type LoginSession = {
+	id: number;
+	ip: string;
+	user_agent: string;
+	created_at: string;
+};
+
+export type SanctionKind = "warning" | "mute" | "temp_ban" | "permanent_ban";
+
+// 制裁の記録（revoked_at があれば解除済み、voided なら取り消し済みで違反の回数に数えない）
+export type Sanction = {
+	id: number;
+	user_id: number;
+	kind: SanctionKind;
+	issued_by: number | null;
+	issuer?: User;
+	reason: string;
+	report_id?: number;
+	expires_at: string | null;
+	revoked_at?: string | null;
+	revoke_reason?: string | null;
+	voided: boolean;
+	created_at: string;
+};
+
+export type SanctionSummary = {
+	sanctions: Sanction[];
+	strikes: number;
+	strike_window_hours: number;
+	suggestion: { kind: SanctionKind; duration_hours?: number };
+};
+
+export type AdminUserPage = {
+	users: BannedUser[];
+	total: number;
+	next_before_id: number | null;
+};
+
+export type AdminUserDetail = {
+	user: BannedUser & { created_at: string };
+	permissions: string[];
+	friend_count: number;
+	messages: { sent_total: number; sent_recent: number; received_total: number; last_sent_at: string | null };
+	storage: { used_bytes: number; quota_bytes: number };
+	reports: { received_count: number; filed_count: number; received: AdminReport[]; filed: AdminReport[] };
+	sanctions: SanctionSummary;
+	sessions: LoginSession[];
+};
@@ -3,9 +3,17 @@ export type MessagePayload = {
 	sender_id: number;
 	receiver_id: number;
 	content: string;
-	message_type: "text" | "image" | "sticker" | string;
+	message_type: "text" | "image" | "file" | "audio" | "sticker" | string;
 	attachment_url?: string | null;
 	attachment_object?: string | null;
+	attachment_width?: number | null;
+	attachment_height?: number | null;
+	attachment_blurhash?: string | null;
+	attachment_thumb_url?: string | null;
+	attachment_medium_url?: string | null;
+	attachment_name?: string | null;
+	attachment_size?: number | null;
+	attachment_duration_ms?: number | null;
 	created_at: string;
 	edited_at?: string | null;
 	is_deleted?: boolean;
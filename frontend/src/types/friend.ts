@@ -12,6 +12,8 @@ export type Friend = {
 	last_message_content?: string | null;
 	last_message_type?: string | null;
 	last_message_attachment_url?: string | null;
+	last_message_attachment_name?: string | null;
+	last_message_attachment_duration_ms?: number | null;
 	last_message_at?: string | null;
 	last_message_edited_at?: string | null;
 	last_message_is_deleted?: boolean | null;
@@ -19,6 +21,8 @@ export type Friend = {
 	last_message_sender_id?: number | null;
 	unread_count?: number;
 	is_online?: boolean;
+	presence?: 'online' | 'away' | 'offline';
+	last_seen_at?: string | null;
 };
 
 export type FriendRequest = {
@@ -8,4 +8,15 @@ export type User = {
 	is_banned?: boolean;
 	ban_reason?: string | null;
 	ban_expires_at?: string | null;
+	hide_presence?: boolean;
+	last_seen_at?: string | null;
+	storage_quota_bytes?: number | null;
+	// GET /users/me のみ（0 以下の quota_bytes は無制限）
+	storage?: { used_bytes: number; quota_bytes: number };
+	permissions?: string[];
 };
+
+// 運営スタッフのロール（チャットは使えず、管理画面のみ）
+export const STAFF_ROLES = ["moderator", "admin", "superadmin"];
+
+export const isStaffRole = (role: string | undefined): boolean => !!role && STAFF_ROLES.includes(role);
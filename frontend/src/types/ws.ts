@@ -3,10 +3,19 @@ export type MessageDTO = {
 	room_id: string;
 	sender_id: number;
 	receiver_id: number;
+	client_msg_id?: string | null;
 	content: string;
-	message_type: "text" | "image" | "sticker" | string;
+	message_type: "text" | "image" | "file" | "audio" | "sticker" | string;
 	attachment_url?: string | null;
 	attachment_object?: string | null;
+	attachment_width?: number | null;
+	attachment_height?: number | null;
+	attachment_blurhash?: string | null;
+	attachment_thumb_url?: string | null;
+	attachment_medium_url?: string | null;
+	attachment_name?: string | null;
+	attachment_size?: number | null;
+	attachment_duration_ms?: number | null;
 	is_deleted: boolean;
 	is_read: boolean;
 	created_at: string;
@@ -15,20 +24,24 @@ export type MessageDTO = {
 
 export type WsSendEvent =
 	| { type: "join"; roomId: string }
-	| { type: "message:send"; roomId: string; content: string; messageType: MessageDTO["message_type"]; attachmentUrl?: string | null; attachmentObject?: string | null }
+	| { type: "message:send"; roomId: string; clientMsgId?: string; content: string; messageType: MessageDTO["message_type"]; attachmentId?: number | null }
 	| { type: "message:edit"; roomId: string; messageId: number; content: string }
 	| { type: "message:delete"; roomId: string; messageId: number }
 	| { type: "typing:start"; roomId: string }
 	| { type: "typing:stop"; roomId: string }
+	| { type: "presence:set"; status: "online" | "away" }
 	| { type: "ping" };
 
 export type WsReceiveEvent =
 	| { type: "message:new"; roomId: string; message: MessageDTO }
+	| { type: "message:ack"; roomId: string; clientMsgId?: string; messageId?: number; duplicate?: boolean; error?: string; message?: MessageDTO }
 	| { type: "message:updated"; roomId: string; message: MessageDTO }
 	| { type: "message:deleted"; roomId: string; message: MessageDTO }
 	| { type: "message:read"; roomId: string; message: MessageDTO }
 	| { type: "typing:start"; roomId: string; userId: number }
 	| { type: "typing:stop"; roomId: string; userId: number }
 	| { type: "presence:update"; roomId: string; users: number[] }
+	| { type: "presence:user"; userId: number; status: "online" | "away" | "offline"; lastSeenAt?: string }
 	| { type: "room:revoked"; roomId: string }
+	| { type: "server:restarting"; reconnectAfterMs: number }
 	| { type: "pong" };